@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	sumpb "github.com/aelhady03/sumflow/adder/proto/sum"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	poolSize     int
+	maxRetries   int
+	retryBackoff time.Duration
+	dialOptions  []grpc.DialOption
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithPoolSize sets how many connections the client spreads calls across. Defaults to 1.
+func WithPoolSize(n int) Option {
+	return func(o *options) {
+		o.poolSize = n
+	}
+}
+
+// WithRetry sets how many times a call failing with Unavailable is retried
+// and the initial backoff, which doubles after each attempt. Defaults to 3 retries from 100ms.
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.maxRetries = maxRetries
+		o.retryBackoff = backoff
+	}
+}
+
+// WithDialOptions appends gRPC dial options, e.g. transport credentials.
+// Without credentials the client connects insecurely.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// Client is a traced, retrying client for the adder's SumNumbers service.
+type Client struct {
+	conns   []*grpc.ClientConn
+	clients []sumpb.SumNumbersServiceClient
+	next    atomic.Uint64
+	opts    options
+}
+
+// New creates a client for the adder at target.
+func New(target string, opts ...Option) (*Client, error) {
+	o := options{
+		poolSize:     1,
+		maxRetries:   3,
+		retryBackoff: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.poolSize < 1 {
+		o.poolSize = 1
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+	// Caller options come last so they override the defaults
+	dialOpts = append(dialOpts, o.dialOptions...)
+
+	c := &Client{opts: o}
+	for range o.poolSize {
+		conn, err := grpc.NewClient(target, dialOpts...)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.conns = append(c.conns, conn)
+		c.clients = append(c.clients, sumpb.NewSumNumbersServiceClient(conn))
+	}
+
+	return c, nil
+}
+
+// Add asks the adder to sum x and y, retrying while the service is unavailable.
+func (c *Client) Add(ctx context.Context, x, y int64) (int64, error) {
+	req := &sumpb.SumNumbersRequest{X: x, Y: y}
+	return c.retry(ctx, func(client sumpb.SumNumbersServiceClient) (int64, error) {
+		resp, err := client.SumNumbers(ctx, req)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Sum, nil
+	})
+}
+
+// SumMany asks the adder to sum numbers, retrying while the service is unavailable.
+func (c *Client) SumMany(ctx context.Context, numbers ...int64) (int64, error) {
+	req := &sumpb.SumManyRequest{Numbers: numbers}
+	return c.retry(ctx, func(client sumpb.SumNumbersServiceClient) (int64, error) {
+		resp, err := client.SumMany(ctx, req)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Sum, nil
+	})
+}
+
+// retry runs call on the next pooled client, retrying with backoff while the
+// service is unavailable.
+func (c *Client) retry(ctx context.Context, call func(sumpb.SumNumbersServiceClient) (int64, error)) (int64, error) {
+	backoff := c.opts.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		sum, err := call(c.pick())
+		if err == nil {
+			return sum, nil
+		}
+		if status.Code(err) != codes.Unavailable || attempt >= c.opts.maxRetries {
+			return 0, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Close closes all pooled connections.
+func (c *Client) Close() error {
+	var errs []error
+	for _, conn := range c.conns {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pick returns the next pooled client in round-robin order.
+func (c *Client) pick() sumpb.SumNumbersServiceClient {
+	n := c.next.Add(1)
+	return c.clients[n%uint64(len(c.clients))]
+}
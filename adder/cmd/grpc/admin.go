@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aelhady03/sumflow/adder/internal/outbox"
+	"github.com/aelhady03/sumflow/pkg/pgmaint"
+	"github.com/aelhady03/sumflow/pkg/redact"
+	"github.com/google/uuid"
+)
+
+// redacted returns the effective configuration with secrets masked.
+func (cfg config) redacted() map[string]any {
+	return map[string]any{
+		"port":                           cfg.port,
+		"metrics_port":                   cfg.MetricsPort,
+		"db_dsn":                         redact.DSN(cfg.DBDSN),
+		"kafka_brokers":                  cfg.KafkaBrokers,
+		"kafka_topic":                    cfg.KafkaTopic,
+		"relay":                          cfg.runRelay,
+		"relay_interval":                 cfg.RelayInterval.String(),
+		"relay_max_interval":             cfg.RelayMaxInterval.String(),
+		"relay_batch":                    cfg.RelayBatch,
+		"otlp_endpoint":                  cfg.OTLPEndpoint,
+		"event_sequencing":               cfg.sequencing,
+		"kafka_key_field":                cfg.KafkaKeyField,
+		"kafka_batch_size":               cfg.KafkaBatchSize,
+		"kafka_batch_timeout":            cfg.KafkaBatchTimeout.String(),
+		"metric_buckets":                 cfg.MetricBuckets,
+		"max_abs_result":                 cfg.maxAbsResult,
+		"max_payload_bytes":              cfg.maxPayloadBytes,
+		"health_check_interval":          cfg.healthCheckInterval.String(),
+		"outbox_partitioning":            cfg.Partitioned,
+		"backpressure_high":              cfg.BackpressureHigh,
+		"backpressure_low":               cfg.BackpressureLow,
+		"relay_sideline_after":           cfg.SidelineAfter,
+		"relay_publish_timeout":          cfg.RelayPublishTimeout.String(),
+		"relay_max_concurrent_batches":   cfg.RelayMaxConcurrentBatches,
+		"relay_base_retry_delay":         cfg.RelayBaseRetryDelay.String(),
+		"relay_max_retry_delay":          cfg.RelayMaxRetryDelay.String(),
+		"relay_backlog_metrics_interval": cfg.RelayBacklogMetricsInterval.String(),
+		"pushgateway_url":                redact.DSN(cfg.PushgatewayURL),
+		"admin_token":                    redact.Secret(cfg.AdminToken),
+		"log_sample_interval":            cfg.LogSampleInterval.String(),
+		"shutdown_timeout":               cfg.ShutdownTimeout.String(),
+	}
+}
+
+// requireAdmin only lets requests through that carry the admin bearer token.
+// Admin endpoints are disabled entirely when no token is configured.
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing authentication token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// configHandler returns the effective configuration with secrets masked.
+func (app *application) configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"config": app.config.redacted()}); err != nil {
+		log.Printf("Error writing config: %v", err)
+	}
+}
+
+// diagnosticsHandler returns a support bundle: redacted config, connection
+// pool stats and the outbox backlog in a single response.
+func (app *application) diagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	stat := app.pool.Stat()
+
+	outbox := map[string]any{
+		"shedding": app.relay.Shedding(),
+	}
+	if backlog, err := app.outboxRepo.CountUnpublished(r.Context()); err != nil {
+		outbox["error"] = err.Error()
+	} else {
+		outbox["unpublished"] = backlog
+	}
+
+	diag := map[string]any{
+		"config": app.config.redacted(),
+		"pool": map[string]any{
+			"total_conns":         stat.TotalConns(),
+			"idle_conns":          stat.IdleConns(),
+			"acquired_conns":      stat.AcquiredConns(),
+			"max_conns":           stat.MaxConns(),
+			"acquire_count":       stat.AcquireCount(),
+			"empty_acquire_count": stat.EmptyAcquireCount(),
+			"acquire_duration":    stat.AcquireDuration().String(),
+		},
+		"outbox": outbox,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diag); err != nil {
+		log.Printf("Error writing diagnostics: %v", err)
+	}
+}
+
+// failedEventsHandler lists outbox events that exhausted their publish
+// retries or were dead-lettered, with their retry count and last error.
+func (app *application) failedEventsHandler(w http.ResponseWriter, r *http.Request) {
+	events, err := app.outboxRepo.GetFailedEvents(r.Context(), app.config.RelayConfig().MaxRetries)
+	if err != nil {
+		log.Printf("Error listing failed events: %v", err)
+		http.Error(w, "the server encountered a problem and could not process your request", http.StatusInternalServerError)
+		return
+	}
+
+	inspected := make([]outbox.Inspection, len(events))
+	for i, event := range events {
+		inspected[i] = event.Inspect()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"events": inspected}); err != nil {
+		log.Printf("Error writing failed events: %v", err)
+	}
+}
+
+// maxRequeueIDs bounds how many events one requeue request may name.
+const maxRequeueIDs = 1000
+
+// requeueFailedEventsHandler returns failed or dead-lettered outbox events to
+// the publish queue with their retries reset, so they can be replayed once
+// the broker issue behind them is fixed.
+func (app *application) requeueFailedEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("body must be a JSON object with ids: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(req.IDs) == 0:
+		http.Error(w, "ids must be provided", http.StatusBadRequest)
+		return
+	case len(req.IDs) > maxRequeueIDs:
+		http.Error(w, fmt.Sprintf("at most %d ids may be requeued at once", maxRequeueIDs), http.StatusBadRequest)
+		return
+	}
+
+	requeued, err := app.outboxRepo.RequeueFailed(r.Context(), req.IDs)
+	if err != nil {
+		log.Printf("Error requeueing failed events: %v", err)
+		http.Error(w, "the server encountered a problem and could not process your request", http.StatusInternalServerError)
+		return
+	}
+
+	found := make(map[uuid.UUID]bool, len(requeued))
+	for _, id := range requeued {
+		found[id] = true
+	}
+	notFound := []uuid.UUID{}
+	for _, id := range req.IDs {
+		if !found[id] {
+			notFound = append(notFound, id)
+		}
+	}
+	log.Printf("requeued %d failed outbox events, %d not found or already published", len(requeued), len(notFound))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"requeued": requeued, "not_found": notFound}); err != nil {
+		log.Printf("Error writing requeue result: %v", err)
+	}
+}
+
+// vacuumOutboxHandler vacuums and analyzes the outbox table, reporting its
+// size before and after. Run it after large cleanups.
+func (app *application) vacuumOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	tables, err := pgmaint.Vacuum(r.Context(), app.pool, "outbox")
+	if err != nil {
+		log.Printf("Error vacuuming outbox: %v", err)
+		http.Error(w, "the server encountered a problem and could not process your request", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("outbox vacuumed: %d bytes before, %d after", tables[0].BytesBefore, tables[0].BytesAfter)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"tables": tables}); err != nil {
+		log.Printf("Error writing vacuum result: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Command relay runs only the outbox relay: it publishes events written by
+// the adder's gRPC server to Kafka, so the write path and the publish path
+// can be scaled independently. Run the gRPC server with -relay=false when
+// using it. It accepts the same shared flags as the gRPC server.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	adderconfig "github.com/aelhady03/sumflow/adder/internal/config"
+	"github.com/aelhady03/sumflow/adder/internal/database"
+	"github.com/aelhady03/sumflow/adder/internal/kafka"
+	"github.com/aelhady03/sumflow/adder/internal/outbox"
+	"github.com/aelhady03/sumflow/pkg/shutdown"
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	var cfg adderconfig.Shared
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	// The signal only starts the shutdown; ctx is cancelled once the relay
+	// has drained, so in-flight publishes can still mark their events
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	buckets, err := telemetry.ParseBuckets(cfg.MetricBuckets)
+	if err != nil {
+		log.Fatalf("invalid -metric-buckets: %v", err)
+	}
+	metrics := telemetry.NewMetrics(prometheus.DefaultRegisterer, telemetry.MetricsOptions{Buckets: buckets})
+
+	shutdownTracer, err := telemetry.InitTracer(ctx, telemetry.Config{
+		ServiceName:    "adder-relay",
+		ServiceVersion: "1.0.0",
+		OTLPEndpoint:   cfg.OTLPEndpoint,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to initialize tracer: %v", err)
+	}
+
+	pool, err := database.NewPool(ctx, database.DefaultConfig(cfg.DBDSN))
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	migrate := database.RunMigrations
+	if cfg.Partitioned {
+		migrate = database.RunPartitionedMigrations
+	}
+	if err := migrate(ctx, pool); err != nil {
+		log.Fatalf("failed to run migrations: %v", err)
+	}
+	if err := database.CheckSchema(ctx, pool); err != nil {
+		log.Fatal(err)
+	}
+
+	outboxRepo := outbox.NewRepository(pool)
+	if cfg.Partitioned {
+		outboxRepo.EnablePartitioning()
+		if err := outboxRepo.EnsurePartitions(ctx); err != nil {
+			log.Fatalf("failed to create outbox partitions: %v", err)
+		}
+	}
+
+	producer := kafka.NewKafkaProducer(cfg.ProducerConfig(), metrics)
+	relay := outbox.NewRelay(outboxRepo, producer, cfg.RelayConfig(), metrics)
+	relay.Start(ctx)
+
+	metricsServer := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.MetricsPort),
+		Handler: promhttp.Handler(),
+	}
+	go func() {
+		log.Printf("Metrics server started at port %d\n", cfg.MetricsPort)
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+
+	log.Printf("outbox relay publishing to %s", cfg.KafkaTopic)
+	<-sigCtx.Done()
+
+	log.Println("Shutting down gracefully...")
+	steps := []shutdown.Step{
+		{Name: "outbox relay", Stop: func(ctx context.Context) error {
+			defer cancel()
+			return shutdown.Func(relay.Stop)(ctx)
+		}},
+		{Name: "metrics server", Stop: metricsServer.Shutdown},
+	}
+	if shutdownTracer != nil {
+		steps = append(steps, shutdown.Step{Name: "tracer", Stop: shutdownTracer})
+	}
+	steps = append(steps, shutdown.Step{Name: "kafka producer", Stop: producer.Close})
+	if cfg.PushgatewayURL != "" {
+		steps = append(steps, shutdown.Step{Name: "metrics push", Stop: telemetry.PushMetrics(cfg.PushgatewayURL, "adder-relay", prometheus.DefaultGatherer)})
+	}
+	shutdown.Run(cfg.ShutdownTimeout, log.Printf, steps...)
+
+	log.Println("Shutdown complete")
+}
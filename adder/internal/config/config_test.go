@@ -0,0 +1,26 @@
+package config
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		relayBatch int
+		kafkaBatch int
+		wantErr    bool
+	}{
+		{"relay batch within kafka batch", 50, 100, false},
+		{"relay batch equals kafka batch", 100, 100, false},
+		{"relay batch exceeds kafka batch", 200, 100, true},
+		{"relay batch within default kafka batch", 100, 0, false},
+		{"relay batch exceeds default kafka batch", 101, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Shared{RelayBatch: tt.relayBatch, KafkaBatchSize: tt.kafkaBatch}
+			if err := s.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
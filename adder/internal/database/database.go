@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/aelhady03/sumflow/pkg/schemacheck"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -64,9 +65,95 @@ CREATE TABLE IF NOT EXISTS outbox (
 
 CREATE INDEX IF NOT EXISTS idx_outbox_unpublished ON outbox(created_at)
     WHERE published_at IS NULL;
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS sequence BIGINT;
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT 'application/json';
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS payload_bytes BYTEA;
+ALTER TABLE outbox ALTER COLUMN payload DROP NOT NULL;
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS dead_lettered_at TIMESTAMPTZ;
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS trace_context JSONB;
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS aggregate_sequences (
+    aggregate_id    TEXT PRIMARY KEY,
+    last_sequence   BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS outbox_republish_progress (
+    topic            TEXT PRIMARY KEY,
+    last_created_at  TIMESTAMPTZ NOT NULL,
+    last_id          UUID NOT NULL,
+    updated_at       TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS outbox_idempotency_keys (
+    key         TEXT PRIMARY KEY,
+    event_id    UUID NOT NULL,
+    payload     JSONB,
+    created_at  TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS outbox_sidelined_aggregates (
+    aggregate_type  TEXT NOT NULL,
+    aggregate_id    TEXT NOT NULL,
+    sidelined_at    TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (aggregate_type, aggregate_id)
+);
+`
+
+// PartitionedOutboxSchema creates the outbox as a table range-partitioned by
+// created_at. It must run before AdderSchema on a database without an outbox
+// table; an existing unpartitioned outbox is left as is.
+const PartitionedOutboxSchema = `
+CREATE TABLE IF NOT EXISTS outbox (
+    id              UUID NOT NULL DEFAULT gen_random_uuid(),
+    aggregate_type  TEXT NOT NULL,
+    aggregate_id    TEXT NOT NULL,
+    event_type      TEXT NOT NULL,
+    payload         JSONB NOT NULL,
+    created_at      TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    published_at    TIMESTAMPTZ,
+    retry_count     INTEGER DEFAULT 0,
+    last_error      TEXT,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+CREATE TABLE IF NOT EXISTS outbox_default PARTITION OF outbox DEFAULT;
 `
 
 func RunMigrations(ctx context.Context, pool *pgxpool.Pool) error {
 	_, err := pool.Exec(ctx, AdderSchema)
 	return err
-}
\ No newline at end of file
+}
+
+// RunPartitionedMigrations runs the migrations with a partitioned outbox table.
+func RunPartitionedMigrations(ctx context.Context, pool *pgxpool.Pool) error {
+	if _, err := pool.Exec(ctx, PartitionedOutboxSchema); err != nil {
+		return err
+	}
+	return RunMigrations(ctx, pool)
+}
+
+// expectedColumns are the tables and columns the adder reads and writes.
+var expectedColumns = map[string][]string{
+	"outbox": {
+		"id", "aggregate_type", "aggregate_id", "event_type", "payload", "created_at",
+		"published_at", "retry_count", "last_error", "sequence", "content_type",
+		"payload_bytes", "dead_lettered_at", "trace_context",
+		"next_retry_at",
+	},
+	"aggregate_sequences":         {"aggregate_id", "last_sequence"},
+	"outbox_republish_progress":   {"topic", "last_created_at", "last_id", "updated_at"},
+	"outbox_idempotency_keys":     {"key", "event_id", "payload", "created_at"},
+	"outbox_sidelined_aggregates": {"aggregate_type", "aggregate_id", "sidelined_at"},
+}
+
+// CheckSchema verifies that the database has every table and column the
+// adder expects. Run it after migrations.
+func CheckSchema(ctx context.Context, pool *pgxpool.Pool) error {
+	return schemacheck.Check(ctx, pool, expectedColumns)
+}
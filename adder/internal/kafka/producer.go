@@ -1,8 +1,13 @@
 package kafka
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
 	"time"
 
 	"github.com/aelhady03/sumflow/adder/internal/outbox"
@@ -10,6 +15,7 @@ import (
 	kafka "github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -39,24 +45,154 @@ func (c *kafkaHeaderCarrier) Keys() []string {
 	return keys
 }
 
+// KeyExtractor derives the Kafka message key for an outbox event. Keys are
+// hashed to partitions, so events with the same key are published to one
+// partition in order.
+type KeyExtractor func(event *outbox.Event) ([]byte, error)
+
+// AggregateIDKey keys messages by the event's aggregate ID: the account a sum
+// was recorded for, or the event itself for sums without one. It is the
+// default KeyExtractor.
+func AggregateIDKey(event *outbox.Event) ([]byte, error) {
+	return []byte(event.AggregateID), nil
+}
+
+// PayloadFieldKey returns a KeyExtractor that keys messages by a top-level
+// field of the JSON payload, so related events share a partition.
+func PayloadFieldKey(field string) KeyExtractor {
+	return func(event *outbox.Event) ([]byte, error) {
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return nil, err
+		}
+		raw, ok := payload[field]
+		if !ok {
+			return nil, fmt.Errorf("payload has no field %q", field)
+		}
+
+		// Use string values unquoted; other JSON values are keyed by their encoding
+		var str string
+		if err := json.Unmarshal(raw, &str); err == nil {
+			return []byte(str), nil
+		}
+		return raw, nil
+	}
+}
+
+type ProducerConfig struct {
+	Brokers []string
+	Topic   string
+	// KeyExtractor derives each message's key. Defaults to AggregateIDKey.
+	KeyExtractor KeyExtractor
+	// BatchSize is the most messages the writer sends in one request, and
+	// BatchTimeout the longest it waits to fill a batch before flushing what
+	// it has. A lone event can sit for up to BatchTimeout, so lower it for
+	// latency under light load; raise both for throughput under heavy load.
+	// Zero values use the kafka-go defaults (100 messages, 1s).
+	BatchSize    int
+	BatchTimeout time.Duration
+}
+
 type KafkaProducer struct {
-	writer *kafka.Writer
-	topic  string
+	writer       *kafka.Writer
+	topic        string
+	keyExtractor KeyExtractor
+	metrics      *telemetry.Metrics
 }
 
-func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
+func NewKafkaProducer(cfg ProducerConfig, metrics *telemetry.Metrics) *KafkaProducer {
+	keyExtractor := cfg.KeyExtractor
+	if keyExtractor == nil {
+		keyExtractor = AggregateIDKey
+	}
+
 	return &KafkaProducer{
 		writer: &kafka.Writer{
-			Addr:     kafka.TCP(brokers...),
-			Topic:    topic,
-			Balancer: &kafka.LeastBytes{},
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			BatchSize:    cfg.BatchSize,
+			BatchTimeout: cfg.BatchTimeout,
 		},
-		topic: topic,
+		topic:        cfg.Topic,
+		keyExtractor: keyExtractor,
+		metrics:      metrics,
 	}
 }
 
+// messageKey extracts the key for an event, falling back to the aggregate ID
+// if extraction fails so a bad payload never blocks publishing.
+func (p *KafkaProducer) messageKey(event *outbox.Event) []byte {
+	key, err := p.keyExtractor(event)
+	if err != nil {
+		log.Printf("warning: key extraction failed for event %s, using aggregate ID: %v", event.ID, err)
+		return []byte(event.AggregateID)
+	}
+	return key
+}
+
 // PublishEvent publishes an outbox event to Kafka with tracing and metrics
 func (p *KafkaProducer) PublishEvent(ctx context.Context, event *outbox.Event) error {
+	err := p.PublishBatch(ctx, []*outbox.Event{event})
+	var errs outbox.PublishErrors
+	if errors.As(err, &errs) {
+		return errs[0]
+	}
+	return err
+}
+
+// PublishBatch publishes outbox events to Kafka in a single WriteMessages
+// call, with a span per event. If only some events fail it returns
+// outbox.PublishErrors.
+func (p *KafkaProducer) PublishBatch(ctx context.Context, events []*outbox.Event) error {
+	errs := make(outbox.PublishErrors, len(events))
+	spans := make([]trace.Span, len(events))
+	msgs := make([]kafka.Message, 0, len(events))
+	// indexes maps each message to its event
+	indexes := make([]int, 0, len(events))
+
+	for i, event := range events {
+		var msg kafka.Message
+		spans[i], msg, errs[i] = p.message(ctx, event)
+		if errs[i] == nil {
+			msgs = append(msgs, msg)
+			indexes = append(indexes, i)
+		}
+	}
+
+	p.write(ctx, msgs, indexes, errs)
+
+	failed := 0
+	for i, span := range spans {
+		switch err := errs[i]; {
+		case err == nil:
+			p.metrics.KafkaMessagesProduced.WithLabelValues(p.topic, "success").Inc()
+		case errors.Is(err, outbox.ErrUnpublishable):
+			p.metrics.KafkaMessagesProduced.WithLabelValues(p.topic, "too_large").Inc()
+			span.RecordError(err)
+			failed++
+		default:
+			p.metrics.KafkaMessagesProduced.WithLabelValues(p.topic, "error").Inc()
+			span.RecordError(err)
+			failed++
+		}
+		span.End()
+	}
+
+	if failed == 0 {
+		return nil
+	}
+	return errs
+}
+
+// message starts the produce span for an event and builds its Kafka message
+// with the span's trace context in the headers.
+func (p *KafkaProducer) message(ctx context.Context, event *outbox.Event) (trace.Span, kafka.Message, error) {
+	// Continue the trace of the request that recorded the event
+	if len(event.TraceContext) > 0 {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(event.TraceContext))
+	}
+
 	// Start span
 	ctx, span := tracer.Start(ctx, "kafka.produce",
 		trace.WithSpanKind(trace.SpanKindProducer),
@@ -66,7 +202,6 @@ func (p *KafkaProducer) PublishEvent(ctx context.Context, event *outbox.Event) e
 			attribute.String("messaging.message_id", event.ID.String()),
 		),
 	)
-	defer span.End()
 
 	// Set published_at timestamp
 	now := time.Now().UTC()
@@ -75,36 +210,110 @@ func (p *KafkaProducer) PublishEvent(ctx context.Context, event *outbox.Event) e
 	// Serialize event
 	data, err := event.ToJSON()
 	if err != nil {
-		telemetry.KafkaMessagesProduced.WithLabelValues(p.topic, "error").Inc()
-		span.RecordError(err)
-		return err
+		return span, kafka.Message{}, err
 	}
 
 	// Inject trace context into headers
 	var headers kafkaHeaderCarrier
 	otel.GetTextMapPropagator().Inject(ctx, &headers)
+	if event.ContentType != "" {
+		headers.Set("content-type", event.ContentType)
+	}
 
-	// Publish message
-	err = p.writer.WriteMessages(ctx, kafka.Message{
-		Key:     []byte(event.AggregateID),
+	return span, kafka.Message{
+		Key:     p.messageKey(event),
 		Value:   data,
 		Headers: headers,
-	})
+	}, nil
+}
 
-	if err != nil {
-		telemetry.KafkaMessagesProduced.WithLabelValues(p.topic, "error").Inc()
-		span.RecordError(err)
-		log.Printf("kafka publish error: %v", err)
-		return err
+// write sends msgs in one WriteMessages call and records each message's
+// error at its event's index in errs.
+func (p *KafkaProducer) write(ctx context.Context, msgs []kafka.Message, indexes []int, errs outbox.PublishErrors) {
+	for len(msgs) > 0 {
+		err := p.writer.WriteMessages(ctx, msgs...)
+
+		// The writer rejects the whole call, before sending anything, if a
+		// message exceeds its BatchBytes; fail that message and send the rest
+		var tooLarge kafka.MessageTooLargeError
+		if errors.As(err, &tooLarge) {
+			i := slices.IndexFunc(msgs, func(msg kafka.Message) bool {
+				return bytes.Equal(msg.Value, tooLarge.Message.Value)
+			})
+			if i >= 0 {
+				errs[indexes[i]] = fmt.Errorf("%w: %d byte message exceeds the broker limit: %v", outbox.ErrUnpublishable, len(msgs[i].Value), err)
+				msgs = slices.Delete(msgs, i, i+1)
+				indexes = slices.Delete(indexes, i, i+1)
+				continue
+			}
+		}
+
+		if err != nil {
+			log.Printf("kafka publish error: %v", err)
+		}
+
+		var werrs kafka.WriteErrors
+		if errors.As(err, &werrs) && len(werrs) == len(msgs) {
+			for i, werr := range werrs {
+				if werr != nil {
+					errs[indexes[i]] = writeError(werr, msgs[i])
+				}
+			}
+			return
+		}
+		if err != nil {
+			for i, msg := range msgs {
+				errs[indexes[i]] = writeError(err, msg)
+			}
+		}
+		return
 	}
+}
+
+// writeError marks an error writing msg as unpublishable if the message is
+// too large for the broker, since retrying can never fix that.
+func writeError(err error, msg kafka.Message) error {
+	if isMessageTooLarge(err) {
+		return fmt.Errorf("%w: %d byte message exceeds the broker limit: %v", outbox.ErrUnpublishable, len(msg.Value), err)
+	}
+	return err
+}
 
-	telemetry.KafkaMessagesProduced.WithLabelValues(p.topic, "success").Inc()
-	return nil
+// isMessageTooLarge reports whether a write failed because the message exceeds
+// the writer's BatchBytes or the broker's message.max.bytes.
+func isMessageTooLarge(err error) bool {
+	if err == nil {
+		return false
+	}
+	var werrs kafka.WriteErrors
+	if errors.As(err, &werrs) {
+		for _, werr := range werrs {
+			if isMessageTooLarge(werr) {
+				return true
+			}
+		}
+		return false
+	}
+	return errors.Is(err, kafka.MessageSizeTooLarge)
 }
 
-func (p *KafkaProducer) Close() error {
-	if p.writer != nil {
-		return p.writer.Close()
+// Close flushes messages still buffered in the writer and closes it, giving
+// up when ctx is done. Stop the relay first: events are only marked published
+// once their write returns, so no outbox state is left to reconcile here.
+func (p *KafkaProducer) Close(ctx context.Context) error {
+	if p.writer == nil {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.writer.Close()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("flushing kafka producer: %w", ctx.Err())
 	}
-	return nil
 }
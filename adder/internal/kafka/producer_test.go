@@ -0,0 +1,121 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aelhady03/sumflow/adder/internal/outbox"
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	kafka "github.com/segmentio/kafka-go"
+	metadataAPI "github.com/segmentio/kafka-go/protocol/metadata"
+	produceAPI "github.com/segmentio/kafka-go/protocol/produce"
+)
+
+func newTestProducer(cfg ProducerConfig) *KafkaProducer {
+	cfg.Brokers = []string{"localhost:9092"}
+	cfg.Topic = "test-events"
+	return NewKafkaProducer(cfg, telemetry.NewMetrics(prometheus.NewRegistry(), telemetry.MetricsOptions{}))
+}
+
+func TestMessageRoundTripsBinaryPayload(t *testing.T) {
+	p := newTestProducer(ProducerConfig{})
+	data := []byte{0x00, 0x01, 0xfe, 0xff, '{'}
+	event := &outbox.Event{
+		AggregateType: outbox.AggregateTypeSum,
+		AggregateID:   "binary",
+		EventType:     outbox.EventTypeSumCalculated,
+		ContentType:   "application/x-protobuf",
+		Data:          data,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	span, msg, err := p.message(context.Background(), event)
+	span.End()
+	if err != nil {
+		t.Fatalf("message: %v", err)
+	}
+
+	var headerType string
+	for _, h := range msg.Headers {
+		if h.Key == "content-type" {
+			headerType = string(h.Value)
+		}
+	}
+	if headerType != event.ContentType {
+		t.Errorf("content-type header %q, want %q", headerType, event.ContentType)
+	}
+
+	var got outbox.Event
+	if err := json.Unmarshal(msg.Value, &got); err != nil {
+		t.Fatalf("decode message: %v", err)
+	}
+	if got.ContentType != event.ContentType {
+		t.Errorf("content type %q, want %q", got.ContentType, event.ContentType)
+	}
+	if !bytes.Equal(got.Data, data) {
+		t.Errorf("data %x, want %x", got.Data, data)
+	}
+}
+
+// fakeTransport stands in for a broker with one single-partition topic,
+// recording when each produce request arrives.
+type fakeTransport struct {
+	topic    string
+	produced chan time.Time
+}
+
+func (f *fakeTransport) RoundTrip(ctx context.Context, addr net.Addr, req kafka.Request) (kafka.Response, error) {
+	switch req.(type) {
+	case *metadataAPI.Request:
+		return &metadataAPI.Response{
+			Brokers: []metadataAPI.ResponseBroker{{NodeID: 1, Host: "localhost", Port: 9092}},
+			Topics: []metadataAPI.ResponseTopic{{
+				Name:       f.topic,
+				Partitions: []metadataAPI.ResponsePartition{{PartitionIndex: 0, LeaderID: 1}},
+			}},
+		}, nil
+	case *produceAPI.Request:
+		f.produced <- time.Now()
+		return &produceAPI.Response{
+			Topics: []produceAPI.ResponseTopic{{
+				Topic:      f.topic,
+				Partitions: []produceAPI.ResponsePartition{{Partition: 0}},
+			}},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unexpected request %T", req)
+	}
+}
+
+func TestPublishEventFlushesLoneEventWithinBatchTimeout(t *testing.T) {
+	const batchTimeout = 50 * time.Millisecond
+	p := newTestProducer(ProducerConfig{BatchSize: 100, BatchTimeout: batchTimeout})
+	transport := &fakeTransport{topic: p.topic, produced: make(chan time.Time, 1)}
+	p.writer.Transport = transport
+	defer p.Close(context.Background())
+
+	event, err := outbox.NewSumCalculatedEvent("", 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := p.PublishEvent(context.Background(), event); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	select {
+	case produced := <-transport.produced:
+		// Well under kafka-go's 1s default, so the configured timeout applied
+		if waited := produced.Sub(start); waited > batchTimeout+500*time.Millisecond {
+			t.Errorf("lone event flushed after %s, want about %s", waited, batchTimeout)
+		}
+	default:
+		t.Fatal("PublishEvent returned without producing the event")
+	}
+}
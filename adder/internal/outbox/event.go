@@ -12,48 +12,124 @@ const (
 	EventTypeSumCalculated = "sum.calculated"
 )
 
+// ContentTypeJSON is the default payload content type. Events with any other
+// content type carry their payload in Data instead of Payload.
+const ContentTypeJSON = "application/json"
+
 type Event struct {
 	ID            uuid.UUID       `json:"event_id"`
 	AggregateType string          `json:"aggregate_type"`
 	AggregateID   string          `json:"aggregate_id"`
 	EventType     string          `json:"event_type"`
-	Payload       json.RawMessage `json:"payload"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	ContentType   string          `json:"content_type,omitempty"`
+	Data          []byte          `json:"data,omitempty"`
 	CreatedAt     time.Time       `json:"created_at"`
 	PublishedAt   *time.Time      `json:"published_at,omitempty"`
+	Sequence      int64           `json:"sequence,omitempty"`
 	RetryCount    int             `json:"-"`
 	LastError     *string         `json:"-"`
+
+	// TraceContext holds the propagated trace headers of the request that
+	// recorded the event, so its publish joins that request's trace.
+	TraceContext map[string]string `json:"-"`
+
+	// IdempotencyKey, if set, makes InsertInTx insert the event at most once per key.
+	IdempotencyKey string `json:"-"`
+	// Duplicate is set by InsertInTx when IdempotencyKey had already been used;
+	// the event then holds the ID and payload of the original insert.
+	Duplicate bool `json:"-"`
+}
+
+// Inspection is the JSON view of an event for inspection endpoints. Unlike
+// the published envelope it includes the delivery state; LastError is null
+// when the event has not failed.
+type Inspection struct {
+	*Event
+	RetryCount int     `json:"retry_count"`
+	LastError  *string `json:"last_error"`
 }
 
+// Inspect returns the event's inspection view.
+func (e *Event) Inspect() Inspection {
+	return Inspection{Event: e, RetryCount: e.RetryCount, LastError: e.LastError}
+}
+
+// SumCalculatedPayload is the payload of a sum.calculated event. A sum of two
+// numbers records them as X and Y; a sum of any other count records them as
+// Operands instead. AccountID is the account the sum was recorded for, if any.
 type SumCalculatedPayload struct {
-	X      int `json:"x"`
-	Y      int `json:"y"`
-	Result int `json:"result"`
+	X         *int64  `json:"x,omitempty"`
+	Y         *int64  `json:"y,omitempty"`
+	Operands  []int64 `json:"operands,omitempty"`
+	Result    int64   `json:"result"`
+	AccountID string  `json:"account_id,omitempty"`
 }
 
-func NewSumCalculatedEvent(x, y, result int) (*Event, error) {
-	payload := SumCalculatedPayload{
-		X:      x,
-		Y:      y,
-		Result: result,
-	}
+// NewSumCalculatedEvent creates a sum.calculated event for x + y. A non-empty
+// accountID becomes the event's aggregate, so the account's events share a
+// sequence and a partition key; otherwise each event is its own aggregate.
+func NewSumCalculatedEvent(accountID string, x, y, result int64) (*Event, error) {
+	return newSumCalculatedEvent(SumCalculatedPayload{
+		X:         &x,
+		Y:         &y,
+		Result:    result,
+		AccountID: accountID,
+	})
+}
 
+// NewSumManyCalculatedEvent creates a sum.calculated event for a sum of an
+// arbitrary list of operands, with the same aggregate as NewSumCalculatedEvent.
+func NewSumManyCalculatedEvent(accountID string, operands []int64, result int64) (*Event, error) {
+	return newSumCalculatedEvent(SumCalculatedPayload{
+		Operands:  operands,
+		Result:    result,
+		AccountID: accountID,
+	})
+}
+
+func newSumCalculatedEvent(payload SumCalculatedPayload) (*Event, error) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
 
 	eventID := uuid.New()
+	aggregateID := payload.AccountID
+	if aggregateID == "" {
+		aggregateID = eventID.String()
+	}
 
 	return &Event{
 		ID:            eventID,
 		AggregateType: AggregateTypeSum,
-		AggregateID:   eventID.String(),
+		AggregateID:   aggregateID,
 		EventType:     EventTypeSumCalculated,
 		Payload:       payloadBytes,
 		CreatedAt:     time.Now().UTC(),
 	}, nil
 }
 
+// NewBinaryEvent creates an event with a non-JSON payload, e.g. a serialized
+// protobuf message. The payload is stored as bytes and base64-encoded in the
+// published JSON envelope.
+func NewBinaryEvent(aggregateType, aggregateID, eventType, contentType string, data []byte) *Event {
+	return &Event{
+		ID:            uuid.New(),
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		EventType:     eventType,
+		ContentType:   contentType,
+		Data:          data,
+		CreatedAt:     time.Now().UTC(),
+	}
+}
+
+// IsJSON reports whether the event's payload is JSON.
+func (e *Event) IsJSON() bool {
+	return e.ContentType == "" || e.ContentType == ContentTypeJSON
+}
+
 // ToJSON converts the event to JSON for publishing to Kafka
 func (e *Event) ToJSON() ([]byte, error) {
 	return json.Marshal(e)
@@ -0,0 +1,145 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	partitionPrefix     = "outbox_p"
+	partitionDateLayout = "20060102"
+	// partitionsAhead is how many future daily partitions are kept ready so
+	// inserts never land in the default partition.
+	partitionsAhead = 3
+)
+
+// EnablePartitioning switches cleanup from row deletes to dropping daily
+// partitions. The outbox table must have been created with
+// database.RunPartitionedMigrations.
+func (r *Repository) EnablePartitioning() {
+	r.partitioned = true
+}
+
+// EnsurePartitions creates daily partitions from today through partitionsAhead days out.
+func (r *Repository) EnsurePartitions(ctx context.Context) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for i := 0; i <= partitionsAhead; i++ {
+		if err := r.ensurePartition(ctx, today.AddDate(0, 0, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensurePartition creates the partition for day if it doesn't exist. Postgres
+// refuses to create a partition while the default partition holds rows in its
+// range, so any such rows (inserted while the partition was missing) are
+// moved into it, with the default partition detached in the meantime. It all
+// happens in one transaction, so the rows are never missing from outbox.
+func (r *Repository) ensurePartition(ctx context.Context, day time.Time) error {
+	name := partitionPrefix + day.Format(partitionDateLayout)
+	table := pgx.Identifier{name}.Sanitize()
+	from, to := day, day.AddDate(0, 0, 1)
+
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var exists bool
+		err := tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM pg_class WHERE relname = $1 AND pg_table_is_visible(oid))`, name,
+		).Scan(&exists)
+		if err != nil || exists {
+			return err
+		}
+
+		// Hold off inserts, and any other process creating the partition,
+		// until it is in place
+		if _, err := tx.Exec(ctx, `LOCK TABLE outbox IN ACCESS EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+
+		create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF outbox FOR VALUES FROM ('%s') TO ('%s')`,
+			table, from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+		var stranded bool
+		err = tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM outbox_default WHERE created_at >= $1 AND created_at < $2)`, from, to,
+		).Scan(&stranded)
+		if err != nil {
+			return err
+		}
+		if !stranded {
+			_, err := tx.Exec(ctx, create)
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `ALTER TABLE outbox DETACH PARTITION outbox_default`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, create); err != nil {
+			return err
+		}
+		move := fmt.Sprintf(`
+			WITH moved AS (
+				DELETE FROM outbox_default WHERE created_at >= $1 AND created_at < $2
+				RETURNING *
+			)
+			INSERT INTO %s SELECT * FROM moved
+		`, table)
+		if _, err := tx.Exec(ctx, move, from, to); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `ALTER TABLE outbox ATTACH PARTITION outbox_default DEFAULT`)
+		return err
+	})
+}
+
+// dropExpiredPartitions drops daily partitions that end before the retention
+// cutoff and hold no unpublished events, returning the number of rows dropped.
+func (r *Repository) dropExpiredPartitions(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'outbox' AND c.relname LIKE 'outbox\_p%'
+	`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().UTC().Add(-retention)
+	var dropped int64
+	for _, name := range names {
+		day, err := time.Parse(partitionDateLayout, name[len(partitionPrefix):])
+		if err != nil || day.AddDate(0, 0, 1).After(cutoff) {
+			continue
+		}
+
+		table := pgx.Identifier{name}.Sanitize()
+		var count int64
+		var pending bool
+		err = r.db.QueryRow(ctx,
+			fmt.Sprintf(`SELECT COUNT(*), COALESCE(BOOL_OR(published_at IS NULL), false) FROM %s`, table),
+		).Scan(&count, &pending)
+		if err != nil {
+			return dropped, err
+		}
+		if pending {
+			continue
+		}
+
+		if _, err := r.db.Exec(ctx, fmt.Sprintf(`DROP TABLE %s`, table)); err != nil {
+			return dropped, err
+		}
+		dropped += count
+	}
+
+	return dropped, nil
+}
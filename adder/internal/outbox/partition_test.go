@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aelhady03/sumflow/adder/internal/database"
+	"github.com/aelhady03/sumflow/pkg/pgtest"
+)
+
+func TestEnsurePartitionMovesRowsOutOfTheDefaultPartition(t *testing.T) {
+	pool := pgtest.Pool(t)
+	ctx := context.Background()
+	if err := database.RunPartitionedMigrations(ctx, pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	repo := NewRepository(pool)
+	repo.EnablePartitioning()
+
+	// Beyond partitionsAhead, so it lands in the default partition
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, partitionsAhead+5)
+	event, err := NewSumCalculatedEvent("", 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.CreatedAt = day.Add(time.Hour)
+	insert(t, repo, pool, event)
+
+	if err := repo.ensurePartition(ctx, day); err != nil {
+		t.Fatalf("ensure partition: %v", err)
+	}
+
+	partition := partitionPrefix + day.Format(partitionDateLayout)
+	var where string
+	err = pool.QueryRow(ctx, `SELECT tableoid::regclass::text FROM outbox WHERE id = $1`, event.ID).Scan(&where)
+	if err != nil {
+		t.Fatalf("locate event: %v", err)
+	}
+	if where != partition {
+		t.Errorf("event in %s, want %s", where, partition)
+	}
+
+	// The default partition is attached again and still takes stray rows
+	later, err := NewSumCalculatedEvent("", 4, 5, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later.CreatedAt = day.AddDate(0, 0, 10)
+	insert(t, repo, pool, later)
+
+	// Running it again is a no-op
+	if err := repo.ensurePartition(ctx, day); err != nil {
+		t.Fatalf("ensure existing partition: %v", err)
+	}
+}
@@ -2,29 +2,127 @@ package outbox
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/aelhady03/sumflow/pkg/logsample"
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/google/uuid"
 )
 
+// ErrUnpublishable is wrapped by publish errors that retrying can never fix,
+// such as a message exceeding the broker's size limit. The relay dead-letters
+// such events instead of retrying them.
+var ErrUnpublishable = errors.New("event can never be published")
+
 type Publisher interface {
 	PublishEvent(ctx context.Context, event *Event) error
+	// PublishBatch publishes events in one round trip. If only some of them
+	// fail it returns PublishErrors; any other error applies to them all.
+	PublishBatch(ctx context.Context, events []*Event) error
+}
+
+// PublishErrors reports a partially failed PublishBatch: the error for each
+// event, in order, nil for those that were published.
+type PublishErrors []error
+
+func (e PublishErrors) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d events failed to publish, first: %v", failed, len(e), first)
 }
 
 type RelayConfig struct {
-	PollInterval     time.Duration
-	BatchSize        int
-	MaxRetries       int
-	CleanupInterval  time.Duration
-	RetentionPeriod  time.Duration
+	PollInterval    time.Duration
+	BatchSize       int
+	MaxRetries      int
+	CleanupInterval time.Duration
+	RetentionPeriod time.Duration
+
+	// MaxPollInterval, if above PollInterval, makes polling adaptive: the
+	// interval doubles after each empty batch up to MaxPollInterval and
+	// halves after each full one down to PollInterval.
+	MaxPollInterval time.Duration
+
+	// BackpressureHigh is the unpublished backlog at which the relay starts
+	// shedding load; shedding stops once the backlog falls to BackpressureLow.
+	// Zero disables backpressure.
+	BackpressureHigh          int64
+	BackpressureLow           int64
+	BackpressureCheckInterval time.Duration
+
+	// LogSampleInterval limits repetitive publish error logs to one per
+	// interval with a suppressed count. Zero logs every error.
+	LogSampleInterval time.Duration
+
+	// SidelineAfter keeps each aggregate's events in order and, once one of
+	// its events has failed this many times, moves the aggregate's pending
+	// events to a retry queue published every SidelineRetryInterval, so the
+	// main loop isn't held up by it. A sidelined event that exhausts
+	// MaxRetries is dead-lettered, letting the rest of its aggregate through;
+	// it can be requeued once the cause is fixed. Zero disables sidelining.
+	SidelineAfter         int
+	SidelineRetryInterval time.Duration
+
+	// PublishTimeout bounds each publish, so a hung broker connection can't
+	// stall the batch. A timed-out event is marked failed and retried like
+	// any other publish failure; the broker may still have received it, in
+	// which case the retry is a duplicate the consumer's dedup absorbs.
+	// Zero disables the timeout.
+	PublishTimeout time.Duration
+
+	// MaxConcurrentBatches bounds how many batches, main or sideline
+	// retries, are claimed and published at once, so the relay can't exceed
+	// the producer's concurrency. Each loop publishes one batch at a time,
+	// so 1 serializes the two loops and values above 2 have no effect.
+	// Values below 1 are treated as 1.
+	MaxConcurrentBatches int
+
+	// BaseRetryDelay is how long a failed event waits before the main loop
+	// retries it. The delay doubles with each failure up to MaxRetryDelay,
+	// and each one is jittered by up to half, so a broker outage isn't
+	// hammered every poll. Zero retries on the next poll.
+	BaseRetryDelay time.Duration
+	MaxRetryDelay  time.Duration
+
+	// BacklogMetricsInterval is how often the relay refreshes the pending
+	// events and oldest unpublished age gauges. Zero disables them.
+	BacklogMetricsInterval time.Duration
+
+	// Logger receives a record for every event published or dead-lettered.
+	// Defaults to slog.Default().
+	Logger *slog.Logger
 }
 
 func DefaultRelayConfig() RelayConfig {
 	return RelayConfig{
-		PollInterval:     100 * time.Millisecond,
-		BatchSize:        100,
-		MaxRetries:       5,
-		CleanupInterval:  time.Hour,
-		RetentionPeriod:  7 * 24 * time.Hour, // 7 days
+		PollInterval:    100 * time.Millisecond,
+		BatchSize:       100,
+		MaxRetries:      5,
+		CleanupInterval: time.Hour,
+		RetentionPeriod: 7 * 24 * time.Hour, // 7 days
+
+		BackpressureCheckInterval: 5 * time.Second,
+		SidelineRetryInterval:     5 * time.Second,
+		PublishTimeout:            30 * time.Second,
+		MaxConcurrentBatches:      2,
+		BaseRetryDelay:            time.Second,
+		MaxRetryDelay:             time.Minute,
+		BacklogMetricsInterval:    15 * time.Second,
 	}
 }
 
@@ -32,32 +130,82 @@ type Relay struct {
 	repo      *Repository
 	publisher Publisher
 	config    RelayConfig
+	metrics   *telemetry.Metrics
 	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	shedding  atomic.Bool
+
+	// batchSlots holds a token for each batch being published
+	batchSlots chan struct{}
+
+	batchErrLog   *logsample.Sampler
+	publishErrLog *logsample.Sampler
+	skipLog       *logsample.Sampler
 }
 
-func NewRelay(repo *Repository, publisher Publisher, config RelayConfig) *Relay {
+func NewRelay(repo *Repository, publisher Publisher, config RelayConfig, metrics *telemetry.Metrics) *Relay {
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.MaxConcurrentBatches < 1 {
+		config.MaxConcurrentBatches = 1
+	}
 	return &Relay{
 		repo:      repo,
 		publisher: publisher,
 		config:    config,
+		metrics:   metrics,
 		stopCh:    make(chan struct{}),
+
+		batchSlots: make(chan struct{}, config.MaxConcurrentBatches),
+
+		batchErrLog:   logsample.New(config.LogSampleInterval),
+		publishErrLog: logsample.New(config.LogSampleInterval),
+		skipLog:       logsample.New(config.LogSampleInterval),
 	}
 }
 
 // Start begins the relay background processing
 func (r *Relay) Start(ctx context.Context) {
-	go r.runPublishLoop(ctx)
-	go r.runCleanupLoop(ctx)
+	r.spawn(ctx, r.runPublishLoop)
+	r.spawn(ctx, r.runCleanupLoop)
+	if r.config.BackpressureHigh > 0 {
+		r.spawn(ctx, r.runBackpressureLoop)
+	}
+	if r.config.SidelineAfter > 0 {
+		r.spawn(ctx, r.runSidelineLoop)
+	}
+	if r.config.BacklogMetricsInterval > 0 {
+		r.spawn(ctx, r.runBacklogMetricsLoop)
+	}
+}
+
+// MonitorBacklog runs only the backpressure check, for a process whose events
+// are published by a relay running elsewhere.
+func (r *Relay) MonitorBacklog(ctx context.Context) {
+	r.spawn(ctx, r.runBackpressureLoop)
+}
+
+func (r *Relay) spawn(ctx context.Context, loop func(context.Context)) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		loop(ctx)
+	}()
 }
 
-// Stop signals the relay to stop processing
+// Stop signals the relay to stop processing and waits for its loops to exit,
+// so a batch being published finishes marking its events before Stop returns.
 func (r *Relay) Stop() {
 	close(r.stopCh)
+	r.wg.Wait()
 }
 
 func (r *Relay) runPublishLoop(ctx context.Context) {
-	ticker := time.NewTicker(r.config.PollInterval)
-	defer ticker.Stop()
+	interval := r.config.PollInterval
+	r.metrics.OutboxRelayPollInterval.Set(interval.Seconds())
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
 
 	for {
 		select {
@@ -65,40 +213,276 @@ func (r *Relay) runPublishLoop(ctx context.Context) {
 			return
 		case <-r.stopCh:
 			return
-		case <-ticker.C:
-			if err := r.processBatch(ctx); err != nil {
-				log.Printf("outbox relay error: %v", err)
+		case <-timer.C:
+			fetched, err := r.processBatch(ctx)
+			if err != nil {
+				r.batchErrLog.Printf("outbox relay error: %v", err)
 			}
+			interval = r.nextInterval(interval, fetched)
+			r.metrics.OutboxRelayPollInterval.Set(interval.Seconds())
+			timer.Reset(interval)
 		}
 	}
 }
 
-func (r *Relay) processBatch(ctx context.Context) error {
-	events, err := r.repo.FetchUnpublished(ctx, r.config.BatchSize)
-	if err != nil {
-		return err
+// nextInterval adapts the poll interval to the last batch when
+// MaxPollInterval is set: a full batch means a backlog, so polling speeds up
+// towards PollInterval; an empty one means idle, so it backs off towards
+// MaxPollInterval. Otherwise the interval stays at PollInterval.
+func (r *Relay) nextInterval(current time.Duration, fetched int) time.Duration {
+	floor, ceiling := r.config.PollInterval, r.config.MaxPollInterval
+	if ceiling <= floor {
+		return floor
 	}
 
+	switch {
+	case fetched >= r.config.BatchSize:
+		return max(current/2, floor)
+	case fetched == 0:
+		return min(current*2, ceiling)
+	default:
+		return current
+	}
+}
+
+// processBatch publishes one batch of unpublished events and returns how many
+// were fetched. The events stay claimed until they are marked.
+func (r *Relay) processBatch(ctx context.Context) (int, error) {
+	if !r.acquireBatch(ctx) {
+		return 0, nil
+	}
+	defer r.releaseBatch()
+
+	fetched := 0
+	err := r.repo.Claim(ctx, func(repo *Repository) error {
+		events, err := repo.FetchUnpublished(ctx, r.config.BatchSize)
+		if err != nil {
+			return err
+		}
+		fetched = len(events)
+		r.publishEvents(ctx, repo, events, true)
+		return nil
+	})
+	return fetched, err
+}
+
+// acquireBatch waits for a free batch slot. It returns false without one if
+// the relay is stopping first.
+func (r *Relay) acquireBatch(ctx context.Context) bool {
+	select {
+	case r.batchSlots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	case <-r.stopCh:
+		return false
+	}
+	r.metrics.OutboxRelayActiveBatches.Inc()
+	return true
+}
+
+func (r *Relay) releaseBatch() {
+	<-r.batchSlots
+	r.metrics.OutboxRelayActiveBatches.Dec()
+}
+
+// publishEvents publishes events in one batch. With sidelining enabled, an
+// aggregate with an event that has exhausted its retries is left out of the
+// batch to keep its events in order, and once an event has failed
+// SidelineAfter times its aggregate is sidelined (if sideline is true).
+// Events of sidelined aggregates (sideline is false) that exhaust their
+// retries are dead-lettered instead, so the rest of the aggregate can drain
+// and the aggregate be released. The events are marked through repo, the
+// Claim that fetched them.
+func (r *Relay) publishEvents(ctx context.Context, repo *Repository, events []*Event, sideline bool) {
+	ordered := r.config.SidelineAfter > 0
+	blocked := make(map[aggregateKey]bool)
+
+	batch := make([]*Event, 0, len(events))
 	for _, event := range events {
+		if ordered && blocked[keyOf(event)] {
+			continue
+		}
+
 		if event.RetryCount >= r.config.MaxRetries {
-			log.Printf("outbox event %s exceeded max retries, skipping", event.ID)
+			if ordered && !sideline {
+				r.deadLetter(ctx, repo, event, retriesExhausted(event))
+				continue
+			}
+			r.skipLog.Printf("outbox event %s exceeded max retries, skipping", event.ID)
+			blocked[keyOf(event)] = true
+			if ordered && sideline {
+				r.sideline(ctx, repo, event)
+			}
 			continue
 		}
 
-		if err := r.publisher.PublishEvent(ctx, event); err != nil {
-			log.Printf("failed to publish event %s: %v", event.ID, err)
-			if markErr := r.repo.MarkFailed(ctx, event.ID, err.Error()); markErr != nil {
-				log.Printf("failed to mark event as failed: %v", markErr)
+		batch = append(batch, event)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	// The batch is written in one call. An aggregate's events share a
+	// partition key and the relay batch is validated to fit in one produce
+	// request, so they usually succeed or fail together. They can still
+	// straddle two requests when the writer's pending batch holds messages
+	// from the other loop; an event that fails after later events of its
+	// aggregate went out is retried, and reaches consumers out of order.
+	errs := r.publish(ctx, batch)
+	if ordered {
+		r.logReordered(batch, errs)
+	}
+
+	published := make([]*Event, 0, len(batch))
+	for i, event := range batch {
+		err := errs[i]
+		if err == nil {
+			published = append(published, event)
+			continue
+		}
+
+		if errors.Is(err, ErrUnpublishable) {
+			r.deadLetter(ctx, repo, event, err)
+			continue
+		}
+		r.publishErrLog.Printf("failed to publish event %s: %v", event.ID, err)
+		if markErr := repo.MarkFailed(ctx, event.ID, err.Error(), r.retryDelay(event.RetryCount)); markErr != nil {
+			r.batchErrLog.Printf("failed to mark event as failed: %v", markErr)
+		}
+		if ordered && sideline && !blocked[keyOf(event)] && event.RetryCount+1 >= r.config.SidelineAfter {
+			r.sideline(ctx, repo, event)
+		}
+		blocked[keyOf(event)] = true
+	}
+	if len(published) == 0 {
+		return
+	}
+
+	ids := make([]uuid.UUID, len(published))
+	for i, event := range published {
+		ids[i] = event.ID
+	}
+	if err := repo.MarkPublishedBatch(ctx, ids); err != nil {
+		log.Printf("failed to mark events as published: %v", err)
+	}
+	for _, event := range published {
+		r.config.Logger.LogAttrs(ctx, slog.LevelInfo, "event published",
+			slog.String(telemetry.EventIDKey, event.ID.String()),
+			slog.String("event_type", event.EventType),
+			slog.Int("retry_count", event.RetryCount),
+		)
+	}
+}
+
+// logReordered logs each published event that follows a failed event of its
+// aggregate in batch, as the failed event will reach consumers after it.
+func (r *Relay) logReordered(batch []*Event, errs []error) {
+	failed := make(map[aggregateKey]*Event)
+	for i, event := range batch {
+		key := keyOf(event)
+		if errs[i] != nil {
+			if failed[key] == nil {
+				failed[key] = event
 			}
 			continue
 		}
+		if earlier := failed[key]; earlier != nil {
+			r.publishErrLog.Printf("outbox event %s published ahead of failed event %s of aggregate %s/%s",
+				event.ID, earlier.ID, event.AggregateType, event.AggregateID)
+		}
+	}
+}
 
-		if err := r.repo.MarkPublished(ctx, event.ID); err != nil {
-			log.Printf("failed to mark event as published: %v", err)
+// retriesExhausted is the dead-letter cause for an event that failed every
+// retry.
+func retriesExhausted(event *Event) error {
+	lastError := "unknown error"
+	if event.LastError != nil {
+		lastError = *event.LastError
+	}
+	return fmt.Errorf("gave up after %d failed publishes, last: %s", event.RetryCount, lastError)
+}
+
+// retryDelay returns how long an event that has already failed retries times
+// waits before its next attempt: BaseRetryDelay doubled per earlier failure,
+// capped at MaxRetryDelay, with its upper half jittered.
+func (r *Relay) retryDelay(retries int) time.Duration {
+	base, ceiling := r.config.BaseRetryDelay, r.config.MaxRetryDelay
+	if base <= 0 {
+		return 0
+	}
+	if ceiling < base {
+		ceiling = base
+	}
+
+	delay := base
+	for range retries {
+		if delay >= ceiling/2 {
+			delay = ceiling
+			break
 		}
+		delay *= 2
 	}
+	delay = min(delay, ceiling)
+	return delay/2 + rand.N(delay/2+1)
+}
 
-	return nil
+// publish publishes a batch within the configured PublishTimeout and returns
+// each event's error, nil for those published.
+func (r *Relay) publish(ctx context.Context, events []*Event) []error {
+	publishCtx := ctx
+	if r.config.PublishTimeout > 0 {
+		var cancel context.CancelFunc
+		publishCtx, cancel = context.WithTimeout(ctx, r.config.PublishTimeout)
+		defer cancel()
+	}
+
+	err := r.publisher.PublishBatch(publishCtx, events)
+	errs := make([]error, len(events))
+	var partial PublishErrors
+	switch {
+	case err == nil:
+		return errs
+	case errors.As(err, &partial) && len(partial) == len(events):
+		copy(errs, partial)
+	default:
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+
+	if errors.Is(publishCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		r.metrics.OutboxPublishTimeouts.Inc()
+		for i, err := range errs {
+			if err != nil {
+				errs[i] = fmt.Errorf("publish timed out after %s: %w", r.config.PublishTimeout, err)
+			}
+		}
+	}
+	return errs
+}
+
+func (r *Relay) sideline(ctx context.Context, repo *Repository, event *Event) {
+	if err := repo.SidelineAggregate(ctx, event.AggregateType, event.AggregateID); err != nil {
+		r.batchErrLog.Printf("failed to sideline aggregate %s/%s: %v", event.AggregateType, event.AggregateID, err)
+		return
+	}
+	log.Printf("outbox relay: sidelined aggregate %s/%s after event %s failed %d times",
+		event.AggregateType, event.AggregateID, event.ID, event.RetryCount+1)
+}
+
+// deadLetter takes an event that can never be published out of the publish
+// queue so it doesn't block the relay.
+func (r *Relay) deadLetter(ctx context.Context, repo *Repository, event *Event, cause error) {
+	r.metrics.OutboxEventsDeadLettered.WithLabelValues(event.EventType).Inc()
+	r.config.Logger.LogAttrs(ctx, slog.LevelWarn, "event dead-lettered",
+		slog.String(telemetry.EventIDKey, event.ID.String()),
+		slog.String("event_type", event.EventType),
+		slog.String("error", cause.Error()),
+	)
+	if err := repo.MarkDeadLettered(ctx, event.ID, cause.Error()); err != nil {
+		r.batchErrLog.Printf("failed to dead-letter event %s: %v", event.ID, err)
+	}
 }
 
 func (r *Relay) runCleanupLoop(ctx context.Context) {
@@ -118,6 +502,87 @@ func (r *Relay) runCleanupLoop(ctx context.Context) {
 			} else if deleted > 0 {
 				log.Printf("outbox cleanup: deleted %d old events", deleted)
 			}
+
+			keys, err := r.repo.CleanupIdempotencyKeys(ctx, r.config.RetentionPeriod)
+			if err != nil {
+				log.Printf("idempotency key cleanup error: %v", err)
+			} else if keys > 0 {
+				log.Printf("idempotency key cleanup: deleted %d old keys", keys)
+			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// runBacklogMetricsLoop keeps the backlog gauges current, so alerts can fire
+// when the relay falls behind.
+func (r *Relay) runBacklogMetricsLoop(ctx context.Context) {
+	ticker := time.NewTicker(r.config.BacklogMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			pending, oldest, err := r.repo.Backlog(ctx)
+			if err != nil {
+				r.batchErrLog.Printf("outbox backlog metrics error: %v", err)
+				continue
+			}
+			r.metrics.OutboxPendingEvents.Set(float64(pending))
+			r.metrics.OutboxOldestUnpublishedSeconds.Set(oldest.Seconds())
+		}
+	}
+}
+
+// Shedding reports whether the outbox backlog is above the high-water mark
+// and new events should be rejected until the relay catches up.
+func (r *Relay) Shedding() bool {
+	return r.shedding.Load()
+}
+
+func (r *Relay) runBackpressureLoop(ctx context.Context) {
+	ticker := time.NewTicker(r.config.BackpressureCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			if err := r.checkBackpressure(ctx); err != nil {
+				log.Printf("outbox backpressure check error: %v", err)
+			}
+		}
+	}
+}
+
+// checkBackpressure toggles shedding with hysteresis so the service doesn't
+// flap around a single threshold.
+func (r *Relay) checkBackpressure(ctx context.Context) error {
+	backlog, err := r.repo.CountUnpublished(ctx)
+	if err != nil {
+		return err
+	}
+	r.metrics.OutboxBacklog.Set(float64(backlog))
+
+	switch {
+	case !r.shedding.Load() && backlog >= r.config.BackpressureHigh:
+		r.shedding.Store(true)
+		log.Printf("outbox backlog %d reached high-water mark %d, shedding load", backlog, r.config.BackpressureHigh)
+	case r.shedding.Load() && backlog <= r.config.BackpressureLow:
+		r.shedding.Store(false)
+		log.Printf("outbox backlog %d fell to low-water mark %d, accepting load", backlog, r.config.BackpressureLow)
+	}
+
+	if r.shedding.Load() {
+		r.metrics.OutboxBackpressure.Set(1)
+	} else {
+		r.metrics.OutboxBackpressure.Set(0)
+	}
+	return nil
+}
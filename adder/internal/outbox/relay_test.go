@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAcquireBatchBoundsConcurrentBatches(t *testing.T) {
+	metrics := telemetry.NewMetrics(prometheus.NewRegistry(), telemetry.MetricsOptions{})
+	r := NewRelay(nil, nil, RelayConfig{MaxConcurrentBatches: 1}, metrics)
+	ctx := context.Background()
+
+	if !r.acquireBatch(ctx) {
+		t.Fatal("first batch not acquired")
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- r.acquireBatch(ctx) }()
+	select {
+	case <-acquired:
+		t.Fatal("second batch acquired while the first holds the only slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.releaseBatch()
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatal("second batch not acquired after the first was released")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second batch still waiting after the first was released")
+	}
+
+	go func() { acquired <- r.acquireBatch(ctx) }()
+	close(r.stopCh)
+	select {
+	case ok := <-acquired:
+		if ok {
+			t.Error("batch acquired after the relay stopped")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquireBatch still waiting after the relay stopped")
+	}
+}
@@ -3,75 +3,270 @@ package outbox
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
+// uniqueViolation is the PostgreSQL SQLSTATE for unique_violation.
+const uniqueViolation = "23505"
+
+// ErrPayloadTooLarge is returned by InsertInTx for an event whose payload
+// exceeds the limit set with SetMaxPayloadBytes.
+var ErrPayloadTooLarge = errors.New("event payload too large")
+
+// querier runs queries on the pool or on a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type Repository struct {
-	pool *pgxpool.Pool
+	pool        *pgxpool.Pool
+	sequencing  bool
+	partitioned bool
+
+	// db runs the repository's queries: the pool, or a Claim's transaction
+	db querier
+
+	maxPayloadBytes int
 }
 
 func NewRepository(pool *pgxpool.Pool) *Repository {
-	return &Repository{pool: pool}
+	return &Repository{pool: pool, db: pool}
+}
+
+// Claim runs fn in a transaction with a copy of the repository bound to it,
+// so the rows FetchUnpublished or FetchSidelined lock stay locked, and are
+// skipped by other relays, until fn has marked them and the transaction
+// commits. The transaction is rolled back if fn returns an error.
+func (r *Repository) Claim(ctx context.Context, fn func(repo *Repository) error) error {
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		claimed := *r
+		claimed.db = tx
+		return fn(&claimed)
+	})
 }
 
-// InsertInTx inserts an event into the outbox within an existing transaction
+// EnableSequencing makes InsertInTx stamp each event with a per-aggregate
+// sequence number so consumers can detect out-of-order delivery.
+func (r *Repository) EnableSequencing() {
+	r.sequencing = true
+}
+
+// SetMaxPayloadBytes makes InsertInTx reject events whose payload is larger
+// than n bytes, so an event too big to publish fails at write time rather
+// than in the relay. Zero removes the limit.
+func (r *Repository) SetMaxPayloadBytes(n int) {
+	r.maxPayloadBytes = n
+}
+
+// InsertInTx inserts an event into the outbox within an existing transaction.
+// If the event carries an idempotency key that was already used, nothing is
+// inserted: the event is filled in from the original and marked Duplicate.
 func (r *Repository) InsertInTx(ctx context.Context, tx pgx.Tx, event *Event) error {
+	if err := r.checkPayloadSize(event); err != nil {
+		return err
+	}
+
+	if event.IdempotencyKey == "" {
+		return r.insertInTx(ctx, tx, event)
+	}
+
+	// A unique violation aborts the enclosing transaction, so claim the key
+	// under a savepoint that can be rolled back on its own
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer sp.Rollback(ctx)
+
+	if err := r.insertInTx(ctx, sp, event); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO outbox_idempotency_keys (key, event_id, payload)
+		VALUES ($1, $2, $3)
+	`
+	_, err = sp.Exec(ctx, query, event.IdempotencyKey, event.ID, event.Payload)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		if err := sp.Rollback(ctx); err != nil {
+			return err
+		}
+		return r.loadDuplicateInTx(ctx, tx, event)
+	}
+	if err != nil {
+		return err
+	}
+	return sp.Commit(ctx)
+}
+
+func (r *Repository) checkPayloadSize(event *Event) error {
+	if r.maxPayloadBytes <= 0 {
+		return nil
+	}
+	size := len(event.Data)
+	if event.IsJSON() {
+		size = len(event.Payload)
+	}
+	if size > r.maxPayloadBytes {
+		return fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrPayloadTooLarge, size, r.maxPayloadBytes)
+	}
+	return nil
+}
+
+func (r *Repository) insertInTx(ctx context.Context, tx pgx.Tx, event *Event) error {
+	if r.sequencing {
+		seq, err := r.nextSequenceInTx(ctx, tx, event.AggregateID)
+		if err != nil {
+			return err
+		}
+		event.Sequence = seq
+	}
+
+	// Keep the caller's trace context so the event is published as part of
+	// the request that recorded it
+	if event.TraceContext == nil {
+		carrier := propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(ctx, carrier)
+		if len(carrier) > 0 {
+			event.TraceContext = carrier
+		}
+	}
+
 	query := `
-		INSERT INTO outbox (aggregate_type, aggregate_id, event_type, payload, created_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO outbox (aggregate_type, aggregate_id, event_type, payload, content_type, payload_bytes, created_at, sequence, trace_context)
+		VALUES ($1, $2, $3, $4, COALESCE(NULLIF($5, ''), 'application/json'), $6, $7, NULLIF($8, 0), $9)
+		RETURNING id
 	`
-	_, err := tx.Exec(ctx, query,
+	var payload, data []byte
+	if event.IsJSON() {
+		payload = event.Payload
+	} else {
+		data = event.Data
+	}
+	return tx.QueryRow(ctx, query,
 		event.AggregateType,
 		event.AggregateID,
 		event.EventType,
-		event.Payload,
+		payload,
+		event.ContentType,
+		data,
 		event.CreatedAt,
-	)
-	return err
+		event.Sequence,
+		event.TraceContext,
+	).Scan(&event.ID)
+}
+
+// loadDuplicateInTx fills event with the original event recorded for its idempotency key
+func (r *Repository) loadDuplicateInTx(ctx context.Context, tx pgx.Tx, event *Event) error {
+	query := `
+		SELECT event_id, payload
+		FROM outbox_idempotency_keys
+		WHERE key = $1
+	`
+	if err := tx.QueryRow(ctx, query, event.IdempotencyKey).Scan(&event.ID, &event.Payload); err != nil {
+		return err
+	}
+	event.Sequence = 0
+	event.Duplicate = true
+	return nil
+}
+
+// CleanupIdempotencyKeys forgets idempotency keys older than the retention period
+func (r *Repository) CleanupIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `
+		DELETE FROM outbox_idempotency_keys
+		WHERE created_at < $1
+	`
+	cutoff := time.Now().UTC().Add(-retention)
+	result, err := r.db.Exec(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-// FetchUnpublished retrieves unpublished events ordered by creation time
+// nextSequenceInTx allocates the next sequence number for an aggregate. The
+// row lock taken by the upsert serializes concurrent inserts for the same aggregate.
+func (r *Repository) nextSequenceInTx(ctx context.Context, tx pgx.Tx, aggregateID string) (int64, error) {
+	query := `
+		INSERT INTO aggregate_sequences (aggregate_id, last_sequence)
+		VALUES ($1, 1)
+		ON CONFLICT (aggregate_id) DO UPDATE SET last_sequence = aggregate_sequences.last_sequence + 1
+		RETURNING last_sequence
+	`
+	var seq int64
+	err := tx.QueryRow(ctx, query, aggregateID).Scan(&seq)
+	return seq, err
+}
+
+// FetchUnpublished retrieves unpublished events ordered by creation time,
+// leaving out sidelined aggregates and aggregates with an event waiting out
+// its retry delay, so an aggregate's events stay in order. The rows are
+// locked for the transaction, so call it within Claim.
 func (r *Repository) FetchUnpublished(ctx context.Context, limit int) ([]*Event, error) {
 	query := `
-		SELECT id, aggregate_type, aggregate_id, event_type, payload, created_at, retry_count, last_error
+		SELECT id, aggregate_type, aggregate_id, event_type, payload, content_type, payload_bytes, created_at, COALESCE(sequence, 0), retry_count, last_error, trace_context
 		FROM outbox
-		WHERE published_at IS NULL
+		WHERE published_at IS NULL AND dead_lettered_at IS NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM outbox_sidelined_aggregates s
+			WHERE s.aggregate_type = outbox.aggregate_type AND s.aggregate_id = outbox.aggregate_id
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM outbox b
+			WHERE b.aggregate_type = outbox.aggregate_type AND b.aggregate_id = outbox.aggregate_id
+			  AND b.published_at IS NULL AND b.dead_lettered_at IS NULL
+			  AND b.next_retry_at > NOW()
+		  )
 		ORDER BY created_at ASC
 		LIMIT $1
 		FOR UPDATE SKIP LOCKED
 	`
-	rows, err := r.pool.Query(ctx, query, limit)
+	rows, err := r.db.Query(ctx, query, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanEvents(rows)
+}
 
-	var events []*Event
-	for rows.Next() {
-		var e Event
-		var payload []byte
-		err := rows.Scan(
-			&e.ID,
-			&e.AggregateType,
-			&e.AggregateID,
-			&e.EventType,
-			&payload,
-			&e.CreatedAt,
-			&e.RetryCount,
-			&e.LastError,
-		)
-		if err != nil {
-			return nil, err
-		}
-		e.Payload = json.RawMessage(payload)
-		events = append(events, &e)
-	}
+// CountUnpublished returns the number of events waiting to be published
+func (r *Repository) CountUnpublished(ctx context.Context) (int64, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM outbox
+		WHERE published_at IS NULL AND dead_lettered_at IS NULL
+	`
+	var count int64
+	err := r.db.QueryRow(ctx, query).Scan(&count)
+	return count, err
+}
 
-	return events, rows.Err()
+// Backlog returns the number of events waiting to be published and the age
+// of the oldest, zero if there are none
+func (r *Repository) Backlog(ctx context.Context) (int64, time.Duration, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at)), 0)::float8
+		FROM outbox
+		WHERE published_at IS NULL AND dead_lettered_at IS NULL
+	`
+	var count int64
+	var age float64
+	if err := r.db.QueryRow(ctx, query).Scan(&count, &age); err != nil {
+		return 0, 0, err
+	}
+	return count, time.Duration(age * float64(time.Second)), nil
 }
 
 // MarkPublished marks an event as successfully published
@@ -81,30 +276,63 @@ func (r *Repository) MarkPublished(ctx context.Context, id uuid.UUID) error {
 		SET published_at = $1
 		WHERE id = $2
 	`
-	_, err := r.pool.Exec(ctx, query, time.Now().UTC(), id)
+	_, err := r.db.Exec(ctx, query, time.Now().UTC(), id)
 	return err
 }
 
-// MarkFailed increments retry count and records the error
-func (r *Repository) MarkFailed(ctx context.Context, id uuid.UUID, errMsg string) error {
+// MarkPublishedBatch marks events as successfully published
+func (r *Repository) MarkPublishedBatch(ctx context.Context, ids []uuid.UUID) error {
 	query := `
 		UPDATE outbox
-		SET retry_count = retry_count + 1, last_error = $1
+		SET published_at = $1
+		WHERE id = ANY($2)
+	`
+	_, err := r.db.Exec(ctx, query, time.Now().UTC(), ids)
+	return err
+}
+
+// MarkFailed increments retry count, records the error and holds the event
+// back from FetchUnpublished for retryDelay
+func (r *Repository) MarkFailed(ctx context.Context, id uuid.UUID, errMsg string, retryDelay time.Duration) error {
+	query := `
+		UPDATE outbox
+		SET retry_count = retry_count + 1, last_error = $1, next_retry_at = NOW() + $3::interval
 		WHERE id = $2
 	`
-	_, err := r.pool.Exec(ctx, query, errMsg, id)
+	_, err := r.db.Exec(ctx, query, errMsg, id, retryDelay)
 	return err
 }
 
-// CleanupOldEvents deletes published events older than the retention period
+// MarkDeadLettered records that an event can never be published, removing it
+// from the publish queue while keeping it for inspection
+func (r *Repository) MarkDeadLettered(ctx context.Context, id uuid.UUID, errMsg string) error {
+	query := `
+		UPDATE outbox
+		SET dead_lettered_at = $1, last_error = $2
+		WHERE id = $3
+	`
+	_, err := r.db.Exec(ctx, query, time.Now().UTC(), errMsg, id)
+	return err
+}
+
+// CleanupOldEvents deletes published events older than the retention period.
+// With partitioning enabled it instead keeps future partitions created and
+// drops whole expired partitions, avoiding delete bloat.
 func (r *Repository) CleanupOldEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	if r.partitioned {
+		if err := r.EnsurePartitions(ctx); err != nil {
+			return 0, err
+		}
+		return r.dropExpiredPartitions(ctx, retention)
+	}
+
 	query := `
 		DELETE FROM outbox
 		WHERE published_at IS NOT NULL
 		AND published_at < $1
 	`
 	cutoff := time.Now().UTC().Add(-retention)
-	result, err := r.pool.Exec(ctx, query, cutoff)
+	result, err := r.db.Exec(ctx, query, cutoff)
 	if err != nil {
 		return 0, err
 	}
@@ -114,15 +342,80 @@ func (r *Repository) CleanupOldEvents(ctx context.Context, retention time.Durati
 // GetFailedEvents retrieves events that have exceeded retry limit
 func (r *Repository) GetFailedEvents(ctx context.Context, maxRetries int) ([]*Event, error) {
 	query := `
-		SELECT id, aggregate_type, aggregate_id, event_type, payload, created_at, retry_count, last_error
+		SELECT id, aggregate_type, aggregate_id, event_type, payload, content_type, payload_bytes, created_at, COALESCE(sequence, 0), retry_count, last_error, trace_context
 		FROM outbox
-		WHERE published_at IS NULL AND retry_count >= $1
+		WHERE published_at IS NULL AND (retry_count >= $1 OR dead_lettered_at IS NOT NULL)
 		ORDER BY created_at ASC
 	`
-	rows, err := r.pool.Query(ctx, query, maxRetries)
+	rows, err := r.db.Query(ctx, query, maxRetries)
 	if err != nil {
 		return nil, err
 	}
+	return scanEvents(rows)
+}
+
+// RequeueFailed returns failed or dead-lettered events to the publish queue
+// with a fresh retry budget, for after the cause has been fixed. It returns
+// the IDs of the events requeued; published or unknown IDs are left alone.
+func (r *Repository) RequeueFailed(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+		UPDATE outbox
+		SET retry_count = 0, last_error = NULL, next_retry_at = NULL, dead_lettered_at = NULL
+		WHERE id = ANY($1) AND published_at IS NULL
+		RETURNING id
+	`
+	rows, err := r.db.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// FetchPublishedAfter retrieves published events ordered by (created_at, id),
+// starting after the given position. Used to page through history for republishing.
+func (r *Repository) FetchPublishedAfter(ctx context.Context, createdAt time.Time, id uuid.UUID, limit int) ([]*Event, error) {
+	query := `
+		SELECT id, aggregate_type, aggregate_id, event_type, payload, content_type, payload_bytes, created_at, COALESCE(sequence, 0), retry_count, last_error, trace_context
+		FROM outbox
+		WHERE published_at IS NOT NULL
+		AND (created_at, id) > ($1, $2)
+		ORDER BY created_at ASC, id ASC
+		LIMIT $3
+	`
+	rows, err := r.db.Query(ctx, query, createdAt, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// RepublishProgress returns the last (created_at, id) position republished to a topic.
+// A zero time and nil UUID are returned if no progress has been recorded.
+func (r *Repository) RepublishProgress(ctx context.Context, topic string) (time.Time, uuid.UUID, error) {
+	query := `SELECT last_created_at, last_id FROM outbox_republish_progress WHERE topic = $1`
+	var createdAt time.Time
+	var id uuid.UUID
+	err := r.db.QueryRow(ctx, query, topic).Scan(&createdAt, &id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, uuid.Nil, nil
+	}
+	return createdAt, id, err
+}
+
+// SaveRepublishProgress records the last position republished to a topic
+func (r *Repository) SaveRepublishProgress(ctx context.Context, topic string, createdAt time.Time, id uuid.UUID) error {
+	query := `
+		INSERT INTO outbox_republish_progress (topic, last_created_at, last_id, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (topic) DO UPDATE
+		SET last_created_at = EXCLUDED.last_created_at, last_id = EXCLUDED.last_id, updated_at = NOW()
+	`
+	_, err := r.db.Exec(ctx, query, topic, createdAt, id)
+	return err
+}
+
+// scanEvents reads outbox rows selected in the standard column order
+func scanEvents(rows pgx.Rows) ([]*Event, error) {
 	defer rows.Close()
 
 	var events []*Event
@@ -135,14 +428,20 @@ func (r *Repository) GetFailedEvents(ctx context.Context, maxRetries int) ([]*Ev
 			&e.AggregateID,
 			&e.EventType,
 			&payload,
+			&e.ContentType,
+			&e.Data,
 			&e.CreatedAt,
+			&e.Sequence,
 			&e.RetryCount,
 			&e.LastError,
+			&e.TraceContext,
 		)
 		if err != nil {
 			return nil, err
 		}
-		e.Payload = json.RawMessage(payload)
+		if payload != nil {
+			e.Payload = json.RawMessage(payload)
+		}
 		events = append(events, &e)
 	}
 
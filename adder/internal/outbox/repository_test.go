@@ -0,0 +1,156 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aelhady03/sumflow/adder/internal/database"
+	"github.com/aelhady03/sumflow/pkg/pgtest"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestRepository returns a Repository on a migrated test schema.
+func newTestRepository(t testing.TB) (*Repository, *pgxpool.Pool) {
+	t.Helper()
+	pool := pgtest.Pool(t)
+	if err := database.RunMigrations(context.Background(), pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewRepository(pool), pool
+}
+
+// insert inserts event in its own committed transaction.
+func insert(t testing.TB, repo *Repository, pool *pgxpool.Pool, event *Event) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := repo.InsertInTx(ctx, tx, event); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+}
+
+func TestInsertInTxReusedIdempotencyKeyIsANoOp(t *testing.T) {
+	repo, pool := newTestRepository(t)
+	ctx := context.Background()
+
+	first, err := NewSumCalculatedEvent("", 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.IdempotencyKey = "key-1"
+	insert(t, repo, pool, first)
+
+	second, err := NewSumCalculatedEvent("", 4, 5, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.IdempotencyKey = "key-1"
+	insert(t, repo, pool, second)
+
+	if !second.Duplicate {
+		t.Error("second insert not reported as a duplicate")
+	}
+	if second.ID != first.ID {
+		t.Errorf("second insert returned event %s, want the original %s", second.ID, first.ID)
+	}
+	if string(second.Payload) != string(first.Payload) {
+		t.Errorf("second insert returned payload %s, want the original %s", second.Payload, first.Payload)
+	}
+
+	count, err := repo.CountUnpublished(ctx)
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("%d events in the outbox, want 1", count)
+	}
+}
+
+func TestInsertInTxRoundTripsBinaryPayload(t *testing.T) {
+	repo, pool := newTestRepository(t)
+
+	data := []byte{0x00, 0x01, 0xfe, 0xff, '{'}
+	event := &Event{
+		AggregateType: AggregateTypeSum,
+		AggregateID:   "binary",
+		EventType:     EventTypeSumCalculated,
+		ContentType:   "application/x-protobuf",
+		Data:          data,
+		CreatedAt:     time.Now().UTC(),
+	}
+	insert(t, repo, pool, event)
+
+	events, err := repo.FetchUnpublished(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("fetched %d events, want 1", len(events))
+	}
+	got := events[0]
+	if got.ContentType != event.ContentType {
+		t.Errorf("content type %q, want %q", got.ContentType, event.ContentType)
+	}
+	if !bytes.Equal(got.Data, data) {
+		t.Errorf("data %x, want %x", got.Data, data)
+	}
+	if got.Payload != nil {
+		t.Errorf("payload %s, want none for a binary event", got.Payload)
+	}
+}
+
+func TestClaimHidesClaimedEventsUntilMarked(t *testing.T) {
+	repo, pool := newTestRepository(t)
+	ctx := context.Background()
+
+	event, err := NewSumCalculatedEvent("", 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(t, repo, pool, event)
+
+	err = repo.Claim(ctx, func(claimed *Repository) error {
+		events, err := claimed.FetchUnpublished(ctx, 10)
+		if err != nil {
+			return err
+		}
+		if len(events) != 1 {
+			t.Fatalf("claimed %d events, want 1", len(events))
+		}
+
+		// Another relay polling meanwhile must skip the claimed event
+		err = repo.Claim(ctx, func(other *Repository) error {
+			events, err := other.FetchUnpublished(ctx, 10)
+			if len(events) != 0 {
+				t.Errorf("second claim fetched %d events, want 0", len(events))
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return claimed.MarkPublishedBatch(ctx, []uuid.UUID{event.ID})
+	})
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	count, err := repo.CountUnpublished(ctx)
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("%d events unpublished after the claim committed, want 0", count)
+	}
+}
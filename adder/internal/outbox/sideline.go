@@ -0,0 +1,136 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// aggregateKey identifies the aggregate an event belongs to.
+type aggregateKey struct {
+	aggregateType string
+	aggregateID   string
+}
+
+func keyOf(event *Event) aggregateKey {
+	return aggregateKey{event.AggregateType, event.AggregateID}
+}
+
+// SidelineAggregate moves an aggregate's pending events out of the main
+// publish queue and into the sideline retry queue.
+func (r *Repository) SidelineAggregate(ctx context.Context, aggregateType, aggregateID string) error {
+	query := `
+		INSERT INTO outbox_sidelined_aggregates (aggregate_type, aggregate_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := r.db.Exec(ctx, query, aggregateType, aggregateID)
+	return err
+}
+
+// FetchSidelined retrieves the pending events of sidelined aggregates ordered
+// by creation time, leaving out aggregates with an event waiting out its
+// retry delay, as FetchUnpublished does. The rows are locked for the
+// transaction, so call it within Claim.
+func (r *Repository) FetchSidelined(ctx context.Context, limit int) ([]*Event, error) {
+	query := `
+		SELECT o.id, o.aggregate_type, o.aggregate_id, o.event_type, o.payload, o.content_type, o.payload_bytes, o.created_at, COALESCE(o.sequence, 0), o.retry_count, o.last_error, o.trace_context
+		FROM outbox o
+		JOIN outbox_sidelined_aggregates s
+		  ON s.aggregate_type = o.aggregate_type AND s.aggregate_id = o.aggregate_id
+		WHERE o.published_at IS NULL AND o.dead_lettered_at IS NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM outbox b
+			WHERE b.aggregate_type = o.aggregate_type AND b.aggregate_id = o.aggregate_id
+			  AND b.published_at IS NULL AND b.dead_lettered_at IS NULL
+			  AND b.next_retry_at > NOW()
+		  )
+		ORDER BY o.created_at ASC
+		LIMIT $1
+		FOR UPDATE OF o SKIP LOCKED
+	`
+	rows, err := r.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// ReleaseDrainedAggregates returns sidelined aggregates with no pending
+// events left to the main publish queue.
+func (r *Repository) ReleaseDrainedAggregates(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM outbox_sidelined_aggregates s
+		WHERE NOT EXISTS (
+			SELECT 1 FROM outbox o
+			WHERE o.aggregate_type = s.aggregate_type AND o.aggregate_id = s.aggregate_id
+			  AND o.published_at IS NULL AND o.dead_lettered_at IS NULL
+		)
+	`
+	result, err := r.db.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// CountSidelined returns the number of sidelined aggregates
+func (r *Repository) CountSidelined(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM outbox_sidelined_aggregates`).Scan(&count)
+	return count, err
+}
+
+// runSidelineLoop retries the events of sidelined aggregates apart from the
+// main publish loop, so one stuck aggregate can't hold up the others.
+func (r *Relay) runSidelineLoop(ctx context.Context) {
+	ticker := time.NewTicker(r.config.SidelineRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			if err := r.processSidelined(ctx); err != nil {
+				r.batchErrLog.Printf("outbox sideline retry error: %v", err)
+			}
+		}
+	}
+}
+
+func (r *Relay) processSidelined(ctx context.Context) error {
+	if !r.acquireBatch(ctx) {
+		return nil
+	}
+	defer r.releaseBatch()
+
+	err := r.repo.Claim(ctx, func(repo *Repository) error {
+		events, err := repo.FetchSidelined(ctx, r.config.BatchSize)
+		if err != nil {
+			return err
+		}
+		r.publishEvents(ctx, repo, events, false)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	released, err := r.repo.ReleaseDrainedAggregates(ctx)
+	if err != nil {
+		return err
+	}
+	if released > 0 {
+		log.Printf("outbox relay: released %d sidelined aggregates", released)
+	}
+
+	count, err := r.repo.CountSidelined(ctx)
+	if err != nil {
+		return err
+	}
+	r.metrics.OutboxSidelinedAggregates.Set(float64(count))
+	return nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sumpb "github.com/aelhady03/sumflow/adder/proto/sum"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Pinger is the database the health checker probes. *pgxpool.Pool implements it.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthChecker reports the server as SERVING through the standard gRPC
+// health service while the database answers pings, and NOT_SERVING while it
+// doesn't, since no sum can be recorded without it.
+type HealthChecker struct {
+	server   *health.Server
+	db       Pinger
+	interval time.Duration
+	stopCh   chan struct{}
+}
+
+// NewHealthChecker creates a checker that pings db every interval. Register
+// Server() on the gRPC server.
+func NewHealthChecker(db Pinger, interval time.Duration) *HealthChecker {
+	h := &HealthChecker{
+		server:   health.NewServer(),
+		db:       db,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+	// Not serving until the first ping succeeds
+	h.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+// Server returns the grpc.health.v1.Health implementation.
+func (h *HealthChecker) Server() healthpb.HealthServer {
+	return h.server
+}
+
+// Start checks the database once, then keeps re-checking it in the background.
+func (h *HealthChecker) Start(ctx context.Context) {
+	h.check(ctx)
+	go h.run(ctx)
+}
+
+// Stop ends the checks and reports NOT_SERVING from then on, so load
+// balancers drain the server before it shuts down.
+func (h *HealthChecker) Stop() {
+	close(h.stopCh)
+	h.server.Shutdown()
+}
+
+func (h *HealthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+			h.check(ctx)
+		}
+	}
+}
+
+func (h *HealthChecker) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, h.interval)
+	defer cancel()
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if err := h.db.Ping(ctx); err != nil {
+		log.Printf("health check: database ping failed: %v", err)
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	h.setStatus(status)
+}
+
+// setStatus sets the overall status and the SumNumbers service's.
+func (h *HealthChecker) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	h.server.SetServingStatus("", status)
+	h.server.SetServingStatus(sumpb.SumNumbersService_ServiceDesc.ServiceName, status)
+}
@@ -2,25 +2,65 @@ package server
 
 import (
 	"context"
+	"errors"
 
+	"github.com/aelhady03/sumflow/adder/internal/outbox"
 	"github.com/aelhady03/sumflow/adder/internal/service"
 	sumpb "github.com/aelhady03/sumflow/adder/proto/sum"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// Adder records sums for the server. *service.AdderService implements it;
+// the server depends on the interface so it can run against a fake.
+type Adder interface {
+	AddIdempotent(ctx context.Context, key, accountID string, x, y int64) (int64, error)
+	SumMany(ctx context.Context, key, accountID string, numbers []int64) (int64, error)
+}
+
 type SumNumbersServer struct {
 	sumpb.UnimplementedSumNumbersServiceServer
-	service *service.AdderService
+	service Adder
 }
 
-func NewSumNumbersServer(service *service.AdderService) *SumNumbersServer {
+func NewSumNumbersServer(service Adder) *SumNumbersServer {
 	return &SumNumbersServer{service: service}
 }
 
 func (s *SumNumbersServer) SumNumbers(ctx context.Context, r *sumpb.SumNumbersRequest) (*sumpb.SumNumbersResponse, error) {
-	x, y := r.X, r.Y
-	sum, err := s.service.Add(ctx, int(x), int(y))
+	sum, err := s.service.AddIdempotent(ctx, idempotencyKey(ctx), r.AccountId, r.X, r.Y)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &sumpb.SumNumbersResponse{Sum: sum}, nil
+}
+
+func (s *SumNumbersServer) SumMany(ctx context.Context, r *sumpb.SumManyRequest) (*sumpb.SumManyResponse, error) {
+	sum, err := s.service.SumMany(ctx, idempotencyKey(ctx), r.AccountId, r.Numbers)
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
+	}
+	return &sumpb.SumManyResponse{Sum: sum}, nil
+}
+
+// idempotencyKey returns the request's idempotency-key metadata, if any
+func idempotencyKey(ctx context.Context) string {
+	if vals := metadata.ValueFromIncomingContext(ctx, "idempotency-key"); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// toStatus maps service errors to gRPC status errors. Errors it doesn't
+// recognise are reported as Internal.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, service.ErrResultTooLarge), errors.Is(err, service.ErrSumOverflow), errors.Is(err, service.ErrNoOperands), errors.Is(err, outbox.ErrPayloadTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrOverloaded):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
 	}
-	return &sumpb.SumNumbersResponse{Sum: int32(sum)}, nil
 }
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aelhady03/sumflow/adder/internal/outbox"
+	"github.com/aelhady03/sumflow/adder/internal/service"
+	sumpb "github.com/aelhady03/sumflow/adder/proto/sum"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// mockAdder is an Adder that records its last call and returns sum and err.
+type mockAdder struct {
+	sum int64
+	err error
+
+	key, accountID string
+	numbers        []int64
+}
+
+func (m *mockAdder) AddIdempotent(ctx context.Context, key, accountID string, x, y int64) (int64, error) {
+	m.key, m.accountID, m.numbers = key, accountID, []int64{x, y}
+	return m.sum, m.err
+}
+
+func (m *mockAdder) SumMany(ctx context.Context, key, accountID string, numbers []int64) (int64, error) {
+	m.key, m.accountID, m.numbers = key, accountID, numbers
+	return m.sum, m.err
+}
+
+func TestToStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"result too large", service.ErrResultTooLarge, codes.InvalidArgument},
+		{"sum overflow", service.ErrSumOverflow, codes.InvalidArgument},
+		{"no operands", service.ErrNoOperands, codes.InvalidArgument},
+		{"payload too large", outbox.ErrPayloadTooLarge, codes.InvalidArgument},
+		{"wrapped sum overflow", fmt.Errorf("sum many: %w", service.ErrSumOverflow), codes.InvalidArgument},
+		{"overloaded", service.ErrOverloaded, codes.Unavailable},
+		{"unknown", errors.New("connection reset"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := status.Code(toStatus(tt.err))
+			if got != tt.want {
+				t.Errorf("toStatus(%v) code = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSumNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		adder    *mockAdder
+		wantSum  int64
+		wantCode codes.Code
+	}{
+		{"ok", &mockAdder{sum: 5}, 5, codes.OK},
+		{"overflow", &mockAdder{err: service.ErrSumOverflow}, 0, codes.InvalidArgument},
+		{"overloaded", &mockAdder{err: service.ErrOverloaded}, 0, codes.Unavailable},
+		{"unknown", &mockAdder{err: errors.New("boom")}, 0, codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("idempotency-key", "k1"))
+			resp, err := NewSumNumbersServer(tt.adder).SumNumbers(ctx, &sumpb.SumNumbersRequest{X: 2, Y: 3, AccountId: "acct"})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %v, want %v", code, tt.wantCode)
+			}
+			if err == nil && resp.Sum != tt.wantSum {
+				t.Errorf("sum = %d, want %d", resp.Sum, tt.wantSum)
+			}
+			if tt.adder.key != "k1" || tt.adder.accountID != "acct" {
+				t.Errorf("adder called with key %q, account %q", tt.adder.key, tt.adder.accountID)
+			}
+		})
+	}
+}
+
+func TestSumManyPassesNumbersThrough(t *testing.T) {
+	adder := &mockAdder{sum: 6}
+	resp, err := NewSumNumbersServer(adder).SumMany(context.Background(), &sumpb.SumManyRequest{Numbers: []int64{1, 2, 3}})
+	if err != nil {
+		t.Fatalf("sum many: %v", err)
+	}
+	if resp.Sum != 6 || len(adder.numbers) != 3 {
+		t.Errorf("sum = %d with numbers %v, want 6 from [1 2 3]", resp.Sum, adder.numbers)
+	}
+	if adder.key != "" {
+		t.Errorf("key = %q without idempotency-key metadata, want empty", adder.key)
+	}
+}
@@ -2,25 +2,135 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
 
 	"github.com/aelhady03/sumflow/adder/internal/outbox"
+	"github.com/aelhady03/sumflow/pkg/telemetry"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("adder-service")
+
+// ErrResultTooLarge is returned when a sum's magnitude exceeds Config.MaxAbsResult.
+var ErrResultTooLarge = errors.New("result exceeds the maximum allowed contribution")
+
+// ErrSumOverflow is returned when a sum doesn't fit the result type the API
+// returns.
+var ErrSumOverflow = errors.New("sum out of range")
+
+// ErrNoOperands is returned by SumMany when there is nothing to sum.
+var ErrNoOperands = errors.New("no numbers to sum")
+
+// ErrOverloaded is returned while the load shedder is rejecting new work.
+var ErrOverloaded = errors.New("outbox backlog too large, try again later")
+
+// LoadShedder reports whether new work should be rejected. *outbox.Relay
+// implements it based on the unpublished backlog.
+type LoadShedder interface {
+	Shedding() bool
+}
+
+type Config struct {
+	// MaxAbsResult caps |x + y| for a single request so one event can't
+	// dominate the running total. Zero disables the limit.
+	MaxAbsResult int
+	// LoadShedder, if set, makes Add fail fast with ErrOverloaded while it is shedding.
+	LoadShedder LoadShedder
+	// Logger receives a record for every event written to the outbox.
+	// Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
 type AdderService struct {
 	pool       *pgxpool.Pool
 	outboxRepo *outbox.Repository
+	config     Config
+	metrics    *telemetry.Metrics
 }
 
-func NewAdderService(pool *pgxpool.Pool, outboxRepo *outbox.Repository) *AdderService {
+func NewAdderService(pool *pgxpool.Pool, outboxRepo *outbox.Repository, config Config, metrics *telemetry.Metrics) *AdderService {
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
 	return &AdderService{
 		pool:       pool,
 		outboxRepo: outboxRepo,
+		config:     config,
+		metrics:    metrics,
 	}
 }
 
-func (a *AdderService) Add(ctx context.Context, x, y int) (int, error) {
-	sum := x + y
+func (a *AdderService) Add(ctx context.Context, x, y int64) (int64, error) {
+	return a.AddIdempotent(ctx, "", "", x, y)
+}
+
+// operation labels write-path metrics by whether the caller supplied an idempotency key.
+func operation(key string) string {
+	if key == "" {
+		return "add"
+	}
+	return "add_idempotent"
+}
+
+// AddIdempotent is Add with a client-supplied idempotency key. Retrying with
+// the same key records no new event and returns the original result. A
+// non-empty accountID keys the event by that account, so the account's sums
+// are published in order.
+func (a *AdderService) AddIdempotent(ctx context.Context, key, accountID string, x, y int64) (int64, error) {
+	return a.record(ctx, operation(key), key, func() (int64, *outbox.Event, error) {
+		sum, err := sumInt64(x, y)
+		if err != nil {
+			return 0, nil, err
+		}
+		event, err := outbox.NewSumCalculatedEvent(accountID, x, y, sum)
+		return sum, event, err
+	})
+}
+
+// SumMany sums numbers and records them as a single sum.calculated event,
+// with the same idempotency and account semantics as AddIdempotent.
+func (a *AdderService) SumMany(ctx context.Context, key, accountID string, numbers []int64) (int64, error) {
+	return a.record(ctx, "sum_many", key, func() (int64, *outbox.Event, error) {
+		if len(numbers) == 0 {
+			return 0, nil, ErrNoOperands
+		}
+		sum, err := sumInt64(numbers...)
+		if err != nil {
+			return 0, nil, err
+		}
+		event, err := outbox.NewSumManyCalculatedEvent(accountID, numbers, sum)
+		return sum, event, err
+	})
+}
+
+// record computes a sum and its event with calculate and writes the event to
+// the outbox, returning the original result if key was already used.
+func (a *AdderService) record(ctx context.Context, op, key string, calculate func() (int64, *outbox.Event, error)) (int64, error) {
+	start := time.Now()
+	defer func() {
+		a.metrics.AdderAddDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	}()
+
+	if a.config.LoadShedder != nil && a.config.LoadShedder.Shedding() {
+		return 0, ErrOverloaded
+	}
+
+	sum, event, err := calculate()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := a.checkContribution(sum); err != nil {
+		return 0, err
+	}
 
 	tx, err := a.pool.Begin(ctx)
 	if err != nil {
@@ -28,18 +138,65 @@ func (a *AdderService) Add(ctx context.Context, x, y int) (int, error) {
 	}
 	defer tx.Rollback(ctx)
 
-	event, err := outbox.NewSumCalculatedEvent(x, y, sum)
+	event.IdempotencyKey = key
+
+	insertStart := time.Now()
+	insertCtx, span := tracer.Start(ctx, "outbox.insert",
+		trace.WithAttributes(attribute.String("event.type", event.EventType)),
+	)
+	// The event keeps the insert span's context, so its publish joins this trace
+	err = a.outboxRepo.InsertInTx(insertCtx, tx, event)
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+	a.metrics.OutboxInsertDuration.WithLabelValues(op).Observe(time.Since(insertStart).Seconds())
 	if err != nil {
 		return 0, err
 	}
 
-	if err := a.outboxRepo.InsertInTx(ctx, tx, event); err != nil {
-		return 0, err
+	if event.Duplicate {
+		var original outbox.SumCalculatedPayload
+		if err := json.Unmarshal(event.Payload, &original); err != nil {
+			return 0, err
+		}
+		sum = original.Result
 	}
 
 	if err := tx.Commit(ctx); err != nil {
 		return 0, err
 	}
 
+	a.config.Logger.LogAttrs(ctx, slog.LevelInfo, "event recorded",
+		slog.String(telemetry.EventIDKey, event.ID.String()),
+		slog.String("event_type", event.EventType),
+		slog.Bool("duplicate", event.Duplicate),
+		telemetry.TraceAttr(ctx),
+	)
+	return sum, nil
+}
+
+// sumInt64 adds numbers, failing instead of wrapping if a partial sum leaves
+// the int64 range.
+func sumInt64(numbers ...int64) (int64, error) {
+	var sum int64
+	for _, n := range numbers {
+		if (n > 0 && sum > math.MaxInt64-n) || (n < 0 && sum < math.MinInt64-n) {
+			return 0, fmt.Errorf("%w: sum of %d numbers overflows int64", ErrSumOverflow, len(numbers))
+		}
+		sum += n
+	}
 	return sum, nil
 }
+
+// checkContribution rejects results whose magnitude exceeds the configured limit
+func (a *AdderService) checkContribution(result int64) error {
+	if a.config.MaxAbsResult <= 0 {
+		return nil
+	}
+	limit := int64(a.config.MaxAbsResult)
+	if result > limit || result < -limit {
+		return fmt.Errorf("%w: |%d| > %d", ErrResultTooLarge, result, a.config.MaxAbsResult)
+	}
+	return nil
+}
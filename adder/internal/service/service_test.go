@@ -0,0 +1,38 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSumInt64(t *testing.T) {
+	tests := []struct {
+		name    string
+		numbers []int64
+		want    int64
+		wantErr error
+	}{
+		// Sums past the int32 range were rejected while responses were int32
+		{"MaxInt32 + 1", []int64{math.MaxInt32, 1}, math.MaxInt32 + 1, nil},
+		{"MinInt32 - 1", []int64{math.MinInt32, -1}, math.MinInt32 - 1, nil},
+		{"MaxInt64", []int64{math.MaxInt64 - 1, 1}, math.MaxInt64, nil},
+		{"MinInt64", []int64{math.MinInt64 + 1, -1}, math.MinInt64, nil},
+		{"MaxInt64 + 1", []int64{math.MaxInt64, 1}, 0, ErrSumOverflow},
+		{"MinInt64 - 1", []int64{math.MinInt64, -1}, 0, ErrSumOverflow},
+		{"partial sum overflows", []int64{math.MaxInt64, 1, -1}, 0, ErrSumOverflow},
+		{"opposite extremes", []int64{math.MaxInt64, math.MinInt64}, -1, nil},
+		{"no numbers", nil, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sumInt64(tt.numbers...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("sumInt64(%v) error = %v, want %v", tt.numbers, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("sumInt64(%v) = %d, want %d", tt.numbers, got, tt.want)
+			}
+		})
+	}
+}
@@ -23,8 +23,9 @@ const (
 
 type SumNumbersRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	X             int32                  `protobuf:"varint,1,opt,name=x,proto3" json:"x,omitempty"`
-	Y             int32                  `protobuf:"varint,2,opt,name=y,proto3" json:"y,omitempty"`
+	X             int64                  `protobuf:"varint,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y             int64                  `protobuf:"varint,2,opt,name=y,proto3" json:"y,omitempty"`
+	AccountId     string                 `protobuf:"bytes,3,opt,name=account_id,json=accountId,proto3" json:"account_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -59,23 +60,30 @@ func (*SumNumbersRequest) Descriptor() ([]byte, []int) {
 	return file_adder_proto_sum_sum_proto_rawDescGZIP(), []int{0}
 }
 
-func (x *SumNumbersRequest) GetX() int32 {
+func (x *SumNumbersRequest) GetX() int64 {
 	if x != nil {
 		return x.X
 	}
 	return 0
 }
 
-func (x *SumNumbersRequest) GetY() int32 {
+func (x *SumNumbersRequest) GetY() int64 {
 	if x != nil {
 		return x.Y
 	}
 	return 0
 }
 
+func (x *SumNumbersRequest) GetAccountId() string {
+	if x != nil {
+		return x.AccountId
+	}
+	return ""
+}
+
 type SumNumbersResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Sum           int32                  `protobuf:"varint,1,opt,name=sum,proto3" json:"sum,omitempty"`
+	Sum           int64                  `protobuf:"varint,1,opt,name=sum,proto3" json:"sum,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -110,7 +118,103 @@ func (*SumNumbersResponse) Descriptor() ([]byte, []int) {
 	return file_adder_proto_sum_sum_proto_rawDescGZIP(), []int{1}
 }
 
-func (x *SumNumbersResponse) GetSum() int32 {
+func (x *SumNumbersResponse) GetSum() int64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+type SumManyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Numbers       []int64                `protobuf:"varint,1,rep,packed,name=numbers,proto3" json:"numbers,omitempty"`
+	AccountId     string                 `protobuf:"bytes,2,opt,name=account_id,json=accountId,proto3" json:"account_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SumManyRequest) Reset() {
+	*x = SumManyRequest{}
+	mi := &file_adder_proto_sum_sum_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SumManyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SumManyRequest) ProtoMessage() {}
+
+func (x *SumManyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adder_proto_sum_sum_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SumManyRequest.ProtoReflect.Descriptor instead.
+func (*SumManyRequest) Descriptor() ([]byte, []int) {
+	return file_adder_proto_sum_sum_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SumManyRequest) GetNumbers() []int64 {
+	if x != nil {
+		return x.Numbers
+	}
+	return nil
+}
+
+func (x *SumManyRequest) GetAccountId() string {
+	if x != nil {
+		return x.AccountId
+	}
+	return ""
+}
+
+type SumManyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sum           int64                  `protobuf:"varint,1,opt,name=sum,proto3" json:"sum,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SumManyResponse) Reset() {
+	*x = SumManyResponse{}
+	mi := &file_adder_proto_sum_sum_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SumManyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SumManyResponse) ProtoMessage() {}
+
+func (x *SumManyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adder_proto_sum_sum_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SumManyResponse.ProtoReflect.Descriptor instead.
+func (*SumManyResponse) Descriptor() ([]byte, []int) {
+	return file_adder_proto_sum_sum_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SumManyResponse) GetSum() int64 {
 	if x != nil {
 		return x.Sum
 	}
@@ -121,15 +225,24 @@ var File_adder_proto_sum_sum_proto protoreflect.FileDescriptor
 
 const file_adder_proto_sum_sum_proto_rawDesc = "" +
 	"\n" +
-	"\x19adder/proto/sum/sum.proto\x12\x03sum\"/\n" +
+	"\x19adder/proto/sum/sum.proto\x12\x03sum\"N\n" +
 	"\x11SumNumbersRequest\x12\f\n" +
-	"\x01x\x18\x01 \x01(\x05R\x01x\x12\f\n" +
-	"\x01y\x18\x02 \x01(\x05R\x01y\"&\n" +
+	"\x01x\x18\x01 \x01(\x03R\x01x\x12\f\n" +
+	"\x01y\x18\x02 \x01(\x03R\x01y\x12\x1d\n" +
+	"\n" +
+	"account_id\x18\x03 \x01(\tR\taccountId\"&\n" +
 	"\x12SumNumbersResponse\x12\x10\n" +
-	"\x03sum\x18\x01 \x01(\x05R\x03sum2T\n" +
+	"\x03sum\x18\x01 \x01(\x03R\x03sum\"I\n" +
+	"\x0eSumManyRequest\x12\x18\n" +
+	"\anumbers\x18\x01 \x03(\x03R\anumbers\x12\x1d\n" +
+	"\n" +
+	"account_id\x18\x02 \x01(\tR\taccountId\"#\n" +
+	"\x0fSumManyResponse\x12\x10\n" +
+	"\x03sum\x18\x01 \x01(\x03R\x03sum2\x8c\x01\n" +
 	"\x11SumNumbersService\x12?\n" +
 	"\n" +
-	"SumNumbers\x12\x16.sum.SumNumbersRequest\x1a\x17.sum.SumNumbersResponse\"\x00B4Z2github.com/aelhady03/sumflow/adder/proto/sum;sumpbb\x06proto3"
+	"SumNumbers\x12\x16.sum.SumNumbersRequest\x1a\x17.sum.SumNumbersResponse\"\x00\x126\n" +
+	"\aSumMany\x12\x13.sum.SumManyRequest\x1a\x14.sum.SumManyResponse\"\x00B4Z2github.com/aelhady03/sumflow/adder/proto/sum;sumpbb\x06proto3"
 
 var (
 	file_adder_proto_sum_sum_proto_rawDescOnce sync.Once
@@ -143,16 +256,20 @@ func file_adder_proto_sum_sum_proto_rawDescGZIP() []byte {
 	return file_adder_proto_sum_sum_proto_rawDescData
 }
 
-var file_adder_proto_sum_sum_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_adder_proto_sum_sum_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_adder_proto_sum_sum_proto_goTypes = []any{
 	(*SumNumbersRequest)(nil),  // 0: sum.SumNumbersRequest
 	(*SumNumbersResponse)(nil), // 1: sum.SumNumbersResponse
+	(*SumManyRequest)(nil),     // 2: sum.SumManyRequest
+	(*SumManyResponse)(nil),    // 3: sum.SumManyResponse
 }
 var file_adder_proto_sum_sum_proto_depIdxs = []int32{
 	0, // 0: sum.SumNumbersService.SumNumbers:input_type -> sum.SumNumbersRequest
-	1, // 1: sum.SumNumbersService.SumNumbers:output_type -> sum.SumNumbersResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
+	2, // 1: sum.SumNumbersService.SumMany:input_type -> sum.SumManyRequest
+	1, // 2: sum.SumNumbersService.SumNumbers:output_type -> sum.SumNumbersResponse
+	3, // 3: sum.SumNumbersService.SumMany:output_type -> sum.SumManyResponse
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
 	0, // [0:0] is the sub-list for extension type_name
 	0, // [0:0] is the sub-list for extension extendee
 	0, // [0:0] is the sub-list for field type_name
@@ -169,7 +286,7 @@ func file_adder_proto_sum_sum_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_adder_proto_sum_sum_proto_rawDesc), len(file_adder_proto_sum_sum_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
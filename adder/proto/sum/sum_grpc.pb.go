@@ -20,6 +20,7 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	SumNumbersService_SumNumbers_FullMethodName = "/sum.SumNumbersService/SumNumbers"
+	SumNumbersService_SumMany_FullMethodName    = "/sum.SumNumbersService/SumMany"
 )
 
 // SumNumbersServiceClient is the client API for SumNumbersService service.
@@ -27,6 +28,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type SumNumbersServiceClient interface {
 	SumNumbers(ctx context.Context, in *SumNumbersRequest, opts ...grpc.CallOption) (*SumNumbersResponse, error)
+	SumMany(ctx context.Context, in *SumManyRequest, opts ...grpc.CallOption) (*SumManyResponse, error)
 }
 
 type sumNumbersServiceClient struct {
@@ -47,11 +49,22 @@ func (c *sumNumbersServiceClient) SumNumbers(ctx context.Context, in *SumNumbers
 	return out, nil
 }
 
+func (c *sumNumbersServiceClient) SumMany(ctx context.Context, in *SumManyRequest, opts ...grpc.CallOption) (*SumManyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SumManyResponse)
+	err := c.cc.Invoke(ctx, SumNumbersService_SumMany_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SumNumbersServiceServer is the server API for SumNumbersService service.
 // All implementations must embed UnimplementedSumNumbersServiceServer
 // for forward compatibility.
 type SumNumbersServiceServer interface {
 	SumNumbers(context.Context, *SumNumbersRequest) (*SumNumbersResponse, error)
+	SumMany(context.Context, *SumManyRequest) (*SumManyResponse, error)
 	mustEmbedUnimplementedSumNumbersServiceServer()
 }
 
@@ -65,6 +78,9 @@ type UnimplementedSumNumbersServiceServer struct{}
 func (UnimplementedSumNumbersServiceServer) SumNumbers(context.Context, *SumNumbersRequest) (*SumNumbersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SumNumbers not implemented")
 }
+func (UnimplementedSumNumbersServiceServer) SumMany(context.Context, *SumManyRequest) (*SumManyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SumMany not implemented")
+}
 func (UnimplementedSumNumbersServiceServer) mustEmbedUnimplementedSumNumbersServiceServer() {}
 func (UnimplementedSumNumbersServiceServer) testEmbeddedByValue()                           {}
 
@@ -104,6 +120,24 @@ func _SumNumbersService_SumNumbers_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SumNumbersService_SumMany_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SumManyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SumNumbersServiceServer).SumMany(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SumNumbersService_SumMany_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SumNumbersServiceServer).SumMany(ctx, req.(*SumManyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // SumNumbersService_ServiceDesc is the grpc.ServiceDesc for SumNumbersService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,6 +149,10 @@ var SumNumbersService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SumNumbers",
 			Handler:    _SumNumbersService_SumNumbers_Handler,
 		},
+		{
+			MethodName: "SumMany",
+			Handler:    _SumNumbersService_SumMany_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "adder/proto/sum/sum.proto",
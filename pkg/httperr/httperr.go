@@ -0,0 +1,58 @@
+package httperr
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StatusClientClosedRequest is the non-standard status used when the client cancelled the request.
+const StatusClientClosedRequest = 499
+
+// codeToStatus maps gRPC status codes to the HTTP status codes returned to clients.
+var codeToStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           StatusClientClosedRequest,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusUnprocessableEntity,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// StatusFromCode returns the HTTP status code for a gRPC status code.
+// Unrecognised codes map to 500 Internal Server Error.
+func StatusFromCode(code codes.Code) int {
+	if s, ok := codeToStatus[code]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// StatusFromError returns the HTTP status code for an error returned by a gRPC
+// call or a context-bound operation: a cancelled context maps to 499 and an
+// expired one to 504, as their gRPC codes do. Any other error maps to 500
+// Internal Server Error.
+func StatusFromError(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	if st, ok := status.FromError(err); ok {
+		return StatusFromCode(st.Code())
+	}
+	if st := status.FromContextError(err); st.Code() != codes.Unknown {
+		return StatusFromCode(st.Code())
+	}
+	return http.StatusInternalServerError
+}
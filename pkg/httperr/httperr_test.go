@@ -0,0 +1,57 @@
+package httperr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusFromCode(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want int
+	}{
+		{codes.OK, http.StatusOK},
+		{codes.Canceled, StatusClientClosedRequest},
+		{codes.InvalidArgument, http.StatusUnprocessableEntity},
+		{codes.DeadlineExceeded, http.StatusGatewayTimeout},
+		{codes.NotFound, http.StatusNotFound},
+		{codes.ResourceExhausted, http.StatusTooManyRequests},
+		{codes.Unavailable, http.StatusServiceUnavailable},
+		{codes.Unauthenticated, http.StatusUnauthorized},
+		{codes.Code(99), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			if got := StatusFromCode(tt.code); got != tt.want {
+				t.Errorf("StatusFromCode(%s) = %d, want %d", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusFromError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, http.StatusOK},
+		{"grpc status", status.Error(codes.InvalidArgument, "bad numbers"), http.StatusUnprocessableEntity},
+		{"cancelled", context.Canceled, StatusClientClosedRequest},
+		{"wrapped deadline", fmt.Errorf("load total: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{"plain", errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusFromError(tt.err); got != tt.want {
+				t.Errorf("StatusFromError(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package logsample
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Sampler rate-limits a repetitive log line. The first message is logged
+// immediately; further messages within the interval are counted and the
+// count is reported with the next message that gets through.
+type Sampler struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	last       time.Time
+	suppressed int
+}
+
+// New creates a sampler that logs at most once per interval. A zero interval
+// logs every message.
+func New(interval time.Duration) *Sampler {
+	return &Sampler{interval: interval}
+}
+
+// SetInterval changes the interval for subsequent messages.
+func (s *Sampler) SetInterval(interval time.Duration) {
+	s.mu.Lock()
+	s.interval = interval
+	s.mu.Unlock()
+}
+
+// Printf logs the message if the interval has elapsed since the last one,
+// otherwise it only counts it.
+func (s *Sampler) Printf(format string, args ...any) {
+	s.mu.Lock()
+	if s.interval <= 0 {
+		s.mu.Unlock()
+		log.Printf(format, args...)
+		return
+	}
+
+	now := time.Now()
+	if !s.last.IsZero() && now.Sub(s.last) < s.interval {
+		s.suppressed++
+		s.mu.Unlock()
+		return
+	}
+	suppressed := s.suppressed
+	s.last = now
+	s.suppressed = 0
+	s.mu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d similar messages suppressed)", msg, suppressed)
+	}
+	log.Print(msg)
+}
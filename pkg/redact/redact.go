@@ -0,0 +1,34 @@
+package redact
+
+import (
+	"net/url"
+	"regexp"
+)
+
+const mask = "xxxxx"
+
+var kvPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// DSN masks the password in a PostgreSQL connection string, in either URL or keyword/value form.
+func DSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), mask)
+		}
+		if q := u.Query(); q.Has("password") {
+			q.Set("password", mask)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return kvPassword.ReplaceAllString(dsn, "${1}"+mask)
+}
+
+// Secret masks a secret value. Empty values are returned as is so it stays
+// visible whether the secret was set at all.
+func Secret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return mask
+}
@@ -0,0 +1,62 @@
+package schemacheck
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Check confirms that every expected column, keyed by table name, exists in
+// the connection's current schema. It reports all missing tables and columns
+// at once, so a partial migration or a DSN pointing at an unrelated database
+// is caught before the service starts serving.
+func Check(ctx context.Context, pool *pgxpool.Pool, expected map[string][]string) error {
+	tables := slices.Sorted(maps.Keys(expected))
+
+	query := `
+		SELECT table_name, column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ANY($1)
+	`
+	rows, err := pool.Query(ctx, query, tables)
+	if err != nil {
+		return fmt.Errorf("schema check: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return fmt.Errorf("schema check: %w", err)
+		}
+		if found[table] == nil {
+			found[table] = make(map[string]bool)
+		}
+		found[table][column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("schema check: %w", err)
+	}
+
+	var missing []string
+	for _, table := range tables {
+		if found[table] == nil {
+			missing = append(missing, "table "+table)
+			continue
+		}
+		for _, column := range expected[table] {
+			if !found[table][column] {
+				missing = append(missing, "column "+table+"."+column)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("schema check: database is missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
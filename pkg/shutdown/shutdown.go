@@ -0,0 +1,68 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Step is one component's part of a graceful shutdown.
+type Step struct {
+	Name string
+	Stop func(ctx context.Context) error
+}
+
+// Func adapts a stop function without a context, such as one that waits for
+// background loops, into a Step function. If ctx is done first the step
+// returns ctx.Err() and leaves stop running in the background.
+func Func(stop func()) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			stop()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Run stops each step in order within a single overall timeout. Each step may
+// use whatever is left of the budget, but one that takes longer than an even
+// share of the remainder is reported, so slow components can be identified.
+// Errors and overruns are reported through logf.
+func Run(timeout time.Duration, logf func(format string, args ...any), steps ...Step) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	for i, step := range steps {
+		share := time.Until(deadline) / time.Duration(len(steps)-i)
+		start := time.Now()
+		err := step.Stop(ctx)
+		elapsed := time.Since(start)
+
+		switch {
+		case ctx.Err() != nil:
+			logf("shutdown: %s exhausted the %s shutdown budget after %s", step.Name, timeout, elapsed.Round(time.Millisecond))
+		case elapsed > share:
+			logf("shutdown: %s took %s, over its %s share of the shutdown budget", step.Name, elapsed.Round(time.Millisecond), share.Round(time.Millisecond))
+		}
+		if err != nil {
+			logf("shutdown: error stopping %s: %v", step.Name, err)
+		}
+	}
+}
+
+// Logf returns a logf for Run that writes through the given print function,
+// e.g. a structured logger's Warn.
+func Logf(print func(msg string, args ...any)) func(format string, args ...any) {
+	return func(format string, args ...any) {
+		print(fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,63 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRunStopsEveryStepInOrder(t *testing.T) {
+	var stopped []string
+	step := func(name string, err error) Step {
+		return Step{Name: name, Stop: func(ctx context.Context) error {
+			stopped = append(stopped, name)
+			return err
+		}}
+	}
+
+	var logged []string
+	logf := func(format string, args ...any) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	Run(time.Second, logf,
+		step("server", nil),
+		step("relay", errors.New("relay failed")),
+		step("tracer", nil),
+		step("metrics push", nil),
+	)
+
+	want := []string{"server", "relay", "tracer", "metrics push"}
+	if !slices.Equal(stopped, want) {
+		t.Errorf("stopped %v, want %v", stopped, want)
+	}
+	if len(logged) != 1 || logged[0] != "shutdown: error stopping relay: relay failed" {
+		t.Errorf("logged %q, want only the relay error", logged)
+	}
+}
+
+func TestRunStopsLaterStepsAfterBudgetIsExhausted(t *testing.T) {
+	var stopped []string
+	var logged []string
+	logf := func(format string, args ...any) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	Run(10*time.Millisecond, logf,
+		Step{Name: "stuck", Stop: Func(func() { time.Sleep(time.Second) })},
+		Step{Name: "metrics push", Stop: func(ctx context.Context) error {
+			stopped = append(stopped, "metrics push")
+			return ctx.Err()
+		}},
+	)
+
+	if !slices.Equal(stopped, []string{"metrics push"}) {
+		t.Errorf("stopped %v, want the step after the stuck one to still run", stopped)
+	}
+	if len(logged) == 0 {
+		t.Error("exhausted budget was not reported")
+	}
+}
@@ -0,0 +1,28 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor records each unary request in GRPCServerRequests,
+// GRPCServerDuration and, unless it succeeded, GRPCServerErrors. Methods are
+// labelled with their full name, e.g. "/sum.SumNumbersService/SumNumbers".
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		m.GRPCServerRequests.WithLabelValues(info.FullMethod, code.String()).Inc()
+		m.GRPCServerDuration.WithLabelValues(info.FullMethod, code.String()).Observe(time.Since(start).Seconds())
+		if code != codes.OK {
+			m.GRPCServerErrors.WithLabelValues(info.FullMethod, code.String()).Inc()
+		}
+		return resp, err
+	}
+}
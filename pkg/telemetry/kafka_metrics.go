@@ -1,49 +1,456 @@
 package telemetry
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
 )
 
 // Latency buckets: 1ms, 5ms, 10ms, 25ms, 50ms, 100ms, 250ms, 500ms, 1s, 2.5s, 5s, 10s
 var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
 
-// EventProcessingLatency measures full lifecycle latency from event creation to consumer processing.
-// Uses the created_at timestamp from the event.
-var EventProcessingLatency = promauto.NewHistogramVec(
-	prometheus.HistogramOpts{
-		Name:    "event_processing_latency_seconds",
-		Help:    "Full lifecycle latency from event creation to consumer processing (seconds)",
-		Buckets: latencyBuckets,
-	},
-	[]string{"topic", "event_type"},
-)
+// MetricsOptions customises metrics before they are registered.
+type MetricsOptions struct {
+	// Buckets overrides histogram buckets by metric name, e.g.
+	// "kafka_delivery_latency_seconds". Histograms without an entry use their default buckets.
+	Buckets map[string][]float64
+}
 
-// KafkaDeliveryLatency measures Kafka-only latency from publish to consumer processing.
-// Uses the published_at timestamp from the event.
-var KafkaDeliveryLatency = promauto.NewHistogramVec(
-	prometheus.HistogramOpts{
-		Name:    "kafka_delivery_latency_seconds",
-		Help:    "Kafka delivery latency from publish to consumer processing (seconds)",
-		Buckets: latencyBuckets,
-	},
-	[]string{"topic", "event_type"},
-)
+// Retry buckets: 0 through 10 retries
+var retryBuckets = []float64{0, 1, 2, 3, 5, 10}
 
-// KafkaMessagesProduced counts messages sent to Kafka.
-var KafkaMessagesProduced = promauto.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "kafka_messages_produced_total",
-		Help: "Total number of messages produced to Kafka",
-	},
-	[]string{"topic", "status"},
-)
+var batchSizeBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000}
 
-// KafkaMessagesConsumed counts messages consumed from Kafka.
-var KafkaMessagesConsumed = promauto.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "kafka_messages_consumed_total",
-		Help: "Total number of messages consumed from Kafka",
-	},
-	[]string{"topic", "event_type", "status"},
-)
+func (o MetricsOptions) buckets(name string) []float64 {
+	return o.bucketsOr(name, latencyBuckets)
+}
+
+func (o MetricsOptions) bucketsOr(name string, def []float64) []float64 {
+	if b, ok := o.Buckets[name]; ok && len(b) > 0 {
+		return b
+	}
+	return def
+}
+
+// ParseBuckets parses a bucket override spec of the form
+// "metric_a=0.001,0.01,0.1;metric_b=1,10,60" into MetricsOptions.Buckets.
+func ParseBuckets(spec string) (map[string][]float64, error) {
+	buckets := make(map[string][]float64)
+	if strings.TrimSpace(spec) == "" {
+		return buckets, nil
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		name, values, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid bucket spec %q: want name=v1,v2,...", entry)
+		}
+
+		var bounds []float64
+		for _, v := range strings.Split(values, ",") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bucket bound %q for %s: %w", v, name, err)
+			}
+			bounds = append(bounds, f)
+		}
+		sort.Float64s(bounds)
+		buckets[name] = bounds
+	}
+
+	return buckets, nil
+}
+
+// Metrics holds the application's Prometheus collectors. Components receive
+// it at construction rather than using package-level globals, so tests and
+// tools can register against their own registry.
+type Metrics struct {
+	// EventProcessingLatency measures full lifecycle latency from event creation to consumer processing.
+	// Uses the created_at timestamp from the event.
+	EventProcessingLatency *prometheus.HistogramVec
+
+	// KafkaDeliveryLatency measures Kafka-only latency from publish to consumer processing.
+	// Uses the published_at timestamp from the event.
+	KafkaDeliveryLatency *prometheus.HistogramVec
+
+	// KafkaMessagesProduced counts messages sent to Kafka.
+	KafkaMessagesProduced *prometheus.CounterVec
+
+	// KafkaMessagesConsumed counts messages consumed from Kafka.
+	KafkaMessagesConsumed *prometheus.CounterVec
+
+	// ConsumerProcessTimeouts counts messages whose processing exceeded the consumer's per-message timeout.
+	ConsumerProcessTimeouts *prometheus.CounterVec
+
+	// ConsumerInflightAtShutdown records how many messages were still being processed when the consumer began stopping.
+	ConsumerInflightAtShutdown *prometheus.GaugeVec
+
+	// ConsumerDrainCompleted is 1 if the last consumer shutdown drained all in-flight messages, 0 otherwise.
+	ConsumerDrainCompleted *prometheus.GaugeVec
+
+	// EventsOutOfOrder counts sequenced events applied out of per-aggregate order.
+	// kind is "late" for an event older than one already applied, "gap" when earlier events are missing.
+	EventsOutOfOrder *prometheus.CounterVec
+
+	// ConsumerMessageRetries records how many in-process retries each consumed message needed.
+	ConsumerMessageRetries *prometheus.HistogramVec
+
+	// ConsumerDeadlockRetries counts messages retried because their transaction was a deadlock victim.
+	ConsumerDeadlockRetries *prometheus.CounterVec
+
+	// KafkaMessagesMalformed counts consumed messages that could not be decoded, by
+	// what failed: the event envelope or the event's payload.
+	KafkaMessagesMalformed *prometheus.CounterVec
+
+	// KafkaCommitFailures counts failed offset commit attempts, including ones that were retried.
+	KafkaCommitFailures *prometheus.CounterVec
+
+	// KafkaAggregateLockContention counts events that waited for another event of their aggregate to finish.
+	KafkaAggregateLockContention *prometheus.CounterVec
+
+	// KafkaPartitionMessagesProcessed counts messages handled per partition, to show how evenly partitions progress.
+	KafkaPartitionMessagesProcessed *prometheus.CounterVec
+
+	// KafkaCoalescedBatchSize records how many messages each coalesced apply batch held.
+	KafkaCoalescedBatchSize *prometheus.HistogramVec
+
+	// SchemaVersion is the applied database schema version as last checked.
+	SchemaVersion prometheus.Gauge
+
+	// GRPCServerRequests counts handled gRPC requests by method and status code.
+	GRPCServerRequests *prometheus.CounterVec
+
+	// GRPCServerErrors counts gRPC requests that returned a non-OK status, by method and code.
+	GRPCServerErrors *prometheus.CounterVec
+
+	// GRPCServerDuration measures how long gRPC requests take to handle, by method and code.
+	GRPCServerDuration *prometheus.HistogramVec
+
+	// AdderAddDuration measures the adder's whole Add operation, including the outbox transaction.
+	AdderAddDuration *prometheus.HistogramVec
+
+	// OutboxInsertDuration measures the outbox insert within the Add transaction.
+	OutboxInsertDuration *prometheus.HistogramVec
+
+	// OutboxEventsDeadLettered counts outbox events taken out of the publish queue because they can never be published.
+	OutboxEventsDeadLettered *prometheus.CounterVec
+
+	// OutboxSidelinedAggregates is the number of aggregates whose events are being retried apart from the main relay loop.
+	OutboxSidelinedAggregates prometheus.Gauge
+
+	// StorageSecondaryFailures counts failed writes of the total to secondary storage backends.
+	StorageSecondaryFailures *prometheus.CounterVec
+
+	// OutboxPublishTimeouts counts relay publishes abandoned after the publish timeout.
+	OutboxPublishTimeouts prometheus.Counter
+
+	// OutboxRelayPollInterval is the relay's current poll interval in seconds.
+	OutboxRelayPollInterval prometheus.Gauge
+
+	// OutboxRelayActiveBatches is the number of relay batches being published.
+	OutboxRelayActiveBatches prometheus.Gauge
+
+	// OutboxBacklog is the number of unpublished outbox events at the last backpressure check.
+	OutboxBacklog prometheus.Gauge
+
+	// OutboxBackpressure is 1 while the adder is rejecting requests because the outbox backlog is too large.
+	OutboxBackpressure prometheus.Gauge
+
+	// OutboxPendingEvents is the number of outbox events waiting to be published.
+	OutboxPendingEvents prometheus.Gauge
+
+	// OutboxOldestUnpublishedSeconds is the age of the oldest outbox event waiting to be published.
+	OutboxOldestUnpublishedSeconds prometheus.Gauge
+}
+
+// NewMetrics creates the metrics and registers them with reg.
+func NewMetrics(reg prometheus.Registerer, opts MetricsOptions) *Metrics {
+	factory := promauto.With(reg)
+	m := &Metrics{}
+
+	m.EventProcessingLatency = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "event_processing_latency_seconds",
+			Help:    "Full lifecycle latency from event creation to consumer processing (seconds)",
+			Buckets: opts.buckets("event_processing_latency_seconds"),
+		},
+		[]string{"topic", "event_type"},
+	)
+
+	m.KafkaDeliveryLatency = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kafka_delivery_latency_seconds",
+			Help:    "Kafka delivery latency from publish to consumer processing (seconds)",
+			Buckets: opts.buckets("kafka_delivery_latency_seconds"),
+		},
+		[]string{"topic", "event_type"},
+	)
+
+	m.KafkaMessagesProduced = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_messages_produced_total",
+			Help: "Total number of messages produced to Kafka",
+		},
+		[]string{"topic", "status"},
+	)
+
+	m.KafkaMessagesConsumed = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_messages_consumed_total",
+			Help: "Total number of messages consumed from Kafka",
+		},
+		[]string{"topic", "event_type", "status"},
+	)
+
+	m.ConsumerProcessTimeouts = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_process_timeouts_total",
+			Help: "Total number of messages whose processing exceeded the per-message timeout",
+		},
+		[]string{"topic"},
+	)
+
+	m.ConsumerInflightAtShutdown = factory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "consumer_inflight_at_shutdown",
+			Help: "Number of messages in flight when consumer shutdown began",
+		},
+		[]string{"topic"},
+	)
+
+	m.ConsumerDrainCompleted = factory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "consumer_drain_completed",
+			Help: "Whether the last consumer shutdown drained all in-flight messages (1) or timed out (0)",
+		},
+		[]string{"topic"},
+	)
+
+	m.EventsOutOfOrder = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_out_of_order_total",
+			Help: "Total number of sequenced events applied out of per-aggregate order",
+		},
+		[]string{"topic", "kind"},
+	)
+
+	m.ConsumerMessageRetries = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "consumer_message_retries",
+			Help:    "Number of in-process retries a consumed message needed before succeeding or being dead-lettered",
+			Buckets: opts.bucketsOr("consumer_message_retries", retryBuckets),
+		},
+		[]string{"topic"},
+	)
+
+	m.ConsumerDeadlockRetries = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_deadlock_retries_total",
+			Help: "Total number of message retries caused by database deadlocks",
+		},
+		[]string{"topic"},
+	)
+
+	m.KafkaMessagesMalformed = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_messages_malformed_total",
+			Help: "Total number of consumed Kafka messages that could not be decoded",
+		},
+		[]string{"topic", "part"},
+	)
+
+	m.KafkaCommitFailures = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_commit_failures_total",
+			Help: "Total number of failed Kafka offset commit attempts",
+		},
+		[]string{"topic"},
+	)
+
+	m.KafkaAggregateLockContention = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_aggregate_lock_contention_total",
+			Help: "Total number of events that waited for another event of the same aggregate to be processed",
+		},
+		[]string{"topic"},
+	)
+
+	m.KafkaPartitionMessagesProcessed = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_partition_messages_processed_total",
+			Help: "Total number of messages handled per partition",
+		},
+		[]string{"topic", "partition"},
+	)
+
+	m.KafkaCoalescedBatchSize = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kafka_coalesced_batch_size",
+			Help:    "Number of messages in each coalesced apply batch",
+			Buckets: opts.bucketsOr("kafka_coalesced_batch_size", batchSizeBuckets),
+		},
+		[]string{"topic"},
+	)
+
+	m.SchemaVersion = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "schema_version",
+			Help: "Applied database schema version as last checked",
+		},
+	)
+
+	m.GRPCServerRequests = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_server_requests_total",
+			Help: "Total number of gRPC requests handled, by method and status code",
+		},
+		[]string{"method", "code"},
+	)
+
+	m.GRPCServerErrors = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_server_errors_total",
+			Help: "Total number of gRPC requests that returned a non-OK status, by method and code",
+		},
+		[]string{"method", "code"},
+	)
+
+	m.GRPCServerDuration = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time taken to handle a gRPC request (seconds)",
+			Buckets: opts.buckets("grpc_server_handling_seconds"),
+		},
+		[]string{"method", "code"},
+	)
+
+	m.AdderAddDuration = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "adder_add_duration_seconds",
+			Help:    "Duration of the adder's Add operation including its outbox transaction (seconds)",
+			Buckets: opts.buckets("adder_add_duration_seconds"),
+		},
+		[]string{"operation"},
+	)
+
+	m.OutboxInsertDuration = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "outbox_insert_duration_seconds",
+			Help:    "Duration of the outbox insert within the Add transaction (seconds)",
+			Buckets: opts.buckets("outbox_insert_duration_seconds"),
+		},
+		[]string{"operation"},
+	)
+
+	m.OutboxEventsDeadLettered = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbox_events_dead_lettered_total",
+			Help: "Total number of outbox events dead-lettered because they can never be published or exhausted their retries while sidelined",
+		},
+		[]string{"event_type"},
+	)
+
+	m.OutboxSidelinedAggregates = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_sidelined_aggregates",
+			Help: "Number of aggregates whose events are retried apart from the main relay loop",
+		},
+	)
+
+	m.StorageSecondaryFailures = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storage_secondary_failures_total",
+			Help: "Total number of failed writes of the total to secondary storage backends",
+		},
+		[]string{"backend"},
+	)
+
+	m.OutboxPublishTimeouts = factory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "outbox_publish_timeouts_total",
+			Help: "Total number of outbox relay publishes that exceeded the publish timeout",
+		},
+	)
+
+	m.OutboxRelayPollInterval = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_relay_poll_interval_seconds",
+			Help: "Current interval between outbox relay polls (seconds)",
+		},
+	)
+
+	m.OutboxRelayActiveBatches = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_relay_active_batches",
+			Help: "Number of outbox relay batches currently being published",
+		},
+	)
+
+	m.OutboxBacklog = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_backlog",
+			Help: "Number of unpublished outbox events at the last backpressure check",
+		},
+	)
+
+	m.OutboxBackpressure = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_backpressure",
+			Help: "Whether the adder is shedding load because the outbox backlog is too large (1) or not (0)",
+		},
+	)
+
+	m.OutboxPendingEvents = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_pending_events",
+			Help: "Number of outbox events waiting to be published",
+		},
+	)
+
+	m.OutboxOldestUnpublishedSeconds = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_oldest_unpublished_seconds",
+			Help: "Age of the oldest outbox event waiting to be published, 0 when there is none (seconds)",
+		},
+	)
+
+	return m
+}
+
+// ConsumedByStatus returns the current kafka_messages_consumed_total counts
+// for a topic, summed across event types by status.
+func (m *Metrics) ConsumedByStatus(topic string) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		m.KafkaMessagesConsumed.Collect(ch)
+		close(ch)
+	}()
+
+	counts := make(map[string]float64)
+	for metric := range ch {
+		var pb dto.Metric
+		if err := metric.Write(&pb); err != nil {
+			continue
+		}
+
+		var metricTopic, status string
+		for _, label := range pb.GetLabel() {
+			switch label.GetName() {
+			case "topic":
+				metricTopic = label.GetValue()
+			case "status":
+				status = label.GetValue()
+			}
+		}
+		if metricTopic == topic {
+			counts[status] += pb.GetCounter().GetValue()
+		}
+	}
+	return counts
+}
@@ -0,0 +1,23 @@
+package telemetry
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EventIDKey is the log attribute that carries an event's ID on every line
+// logged about it, in the adder, the relay and the totalizer alike, so one
+// grep follows an event through the whole pipeline.
+const EventIDKey = "event_id"
+
+// TraceAttr returns a trace_id log attribute for the span in ctx, joining log
+// lines to traces. Without a span it returns an empty attribute, which slog drops.
+func TraceAttr(ctx context.Context) slog.Attr {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return slog.Attr{}
+	}
+	return slog.String("trace_id", sc.TraceID().String())
+}
@@ -0,0 +1,21 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// PushMetrics returns a shutdown step that pushes every metric gathered from
+// g to the Prometheus Pushgateway at url, so the final values of a short-lived
+// process aren't lost between scrapes. Metrics are grouped by job and
+// instance (the hostname), and each push replaces that group's previous one.
+func PushMetrics(url, job string, g prometheus.Gatherer) func(ctx context.Context) error {
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "unknown"
+	}
+	return push.New(url, job).Gatherer(g).Grouping("instance", instance).PushContext
+}
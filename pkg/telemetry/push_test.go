@@ -0,0 +1,39 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPushMetricsPushesGatheredMetrics(t *testing.T) {
+	var path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "events_total", Help: "Events"})
+	reg.MustRegister(counter)
+	counter.Add(3)
+
+	if err := PushMetrics(srv.URL, "relay", reg)(context.Background()); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	if !strings.HasPrefix(path, "/metrics/job/relay/instance/") {
+		t.Errorf("pushed to %q, want the relay job grouped by instance", path)
+	}
+	if !strings.Contains(body, "events_total") {
+		t.Error("pushed body does not contain the gathered counter")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/aelhady03/sumflow/pkg/logsample"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
@@ -12,6 +13,17 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 )
 
+const (
+	// exportTimeout bounds each span export, retries included, so an
+	// unreachable collector can't hold up the batch processor or the
+	// shutdown flush for long. Spans that can't be exported are dropped.
+	exportTimeout = 10 * time.Second
+	// errorLogInterval limits tracing error logs while the collector is
+	// unreachable: the first error is logged, later ones are only counted
+	// until the interval has passed.
+	errorLogInterval = time.Minute
+)
+
 type Config struct {
 	ServiceName    string
 	ServiceVersion string
@@ -20,10 +32,22 @@ type Config struct {
 
 // InitTracer initializes the OpenTelemetry tracer provider with OTLP exporter.
 // Returns a shutdown function that should be called on application exit.
+//
+// The exporter connects lazily, so a collector that is down at startup
+// doesn't fail or delay it; spans are dropped until the collector is
+// reachable. Export errors are logged at most once per errorLogInterval, and
+// shutdown gives up flushing when its context is done.
 func InitTracer(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
 	exporter, err := otlptracegrpc.New(ctx,
 		otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint),
 		otlptracegrpc.WithInsecure(),
+		otlptracegrpc.WithTimeout(exportTimeout),
+		otlptracegrpc.WithRetry(otlptracegrpc.RetryConfig{
+			Enabled:         true,
+			InitialInterval: time.Second,
+			MaxInterval:     5 * time.Second,
+			MaxElapsedTime:  exportTimeout,
+		}),
 	)
 	if err != nil {
 		return nil, err
@@ -46,6 +70,11 @@ func InitTracer(ctx context.Context, cfg Config) (shutdown func(context.Context)
 		sdktrace.WithResource(res),
 	)
 
+	errLog := logsample.New(errorLogInterval)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		errLog.Printf("Warning: tracing degraded, spans may be dropped: %v", err)
+	}))
+
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
 		propagation.TraceContext{},
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aelhady03/sumflow/pkg/pgmaint"
+	"github.com/aelhady03/sumflow/pkg/redact"
+	"github.com/aelhady03/sumflow/totalizer/internal/kafka"
+)
+
+// redacted returns the effective configuration with secrets masked.
+func (cfg config) redacted() envelope {
+	return envelope{
+		"port":                          cfg.port,
+		"env":                           cfg.env,
+		"db_dsn":                        redact.DSN(cfg.dbDSN),
+		"kafka_brokers":                 cfg.kafkaBrokers,
+		"kafka_topic":                   cfg.kafkaTopic,
+		"kafka_group_id":                cfg.kafkaGroupID,
+		"otlp_endpoint":                 cfg.otlpEndpoint,
+		"migrate":                       cfg.migrate,
+		"initial_total":                 cfg.initialTotal,
+		"handler_timeout":               cfg.handlerTimeout.String(),
+		"shutdown_timeout":              cfg.shutdownTimeout.String(),
+		"pushgateway_url":               redact.DSN(cfg.pushgatewayURL),
+		"shutdown_drain_delay":          cfg.drainDelay.String(),
+		"result_cache_ttl":              cfg.resultCacheTTL.String(),
+		"history_export_max_rows":       cfg.exportMaxRows,
+		"admin_token":                   redact.Secret(cfg.adminToken),
+		"bare_responses":                cfg.bareResponses,
+		"log_sample_interval":           cfg.logSampleInterval.String(),
+		"summary_window":                cfg.summaryWindow.String(),
+		"consumer_process_timeout":      cfg.processTimeout.String(),
+		"consumer_max_process_timeouts": cfg.maxProcessTimeouts,
+		"consumer_max_in_flight":        cfg.maxInFlight,
+		"consumer_fair_scheduling":      cfg.fairScheduling,
+		"consumer_fair_quantum":         cfg.fairQuantum,
+		"consumer_partition_queue":      cfg.partitionQueueSize,
+		"consumer_prefetch":             cfg.prefetchSize,
+		"consumer_fetch_queue":          cfg.fetchQueueCapacity,
+		"consumer_fetch_min_bytes":      cfg.fetchMinBytes,
+		"consumer_fetch_max_bytes":      cfg.fetchMaxBytes,
+		"consumer_fetch_max_wait":       cfg.fetchMaxWait.String(),
+		"consumer_max_retries":          cfg.maxRetries,
+		"consumer_retry_backoff":        cfg.retryBackoff.String(),
+		"consumer_deadlock_retries":     cfg.deadlockRetries,
+		"consumer_isolation":            cfg.isolation,
+		"consumer_delivery":             cfg.delivery,
+		"consumer_total_mode":           cfg.totalMode,
+		"consumer_max_event_age":        cfg.maxEventAge.String(),
+		"consumer_backfill":             cfg.backfill,
+		"consumer_serialize_aggregates": cfg.serializeAggregates,
+		"consumer_key_ttl":              cfg.keyTTL.String(),
+		"consumer_coalesce_window":      cfg.coalesceWindow.String(),
+		"consumer_coalesce_max_batch":   cfg.coalesceMaxBatch,
+		"key_sweep_interval":            cfg.keySweepInterval.String(),
+		"dedup_store":                   cfg.dedupStore,
+		"redis_addr":                    cfg.redisAddr,
+		"dedup_ttl":                     cfg.dedupTTL.String(),
+		"snapshot_interval":             cfg.snapshotInterval.String(),
+		"history_retention":             cfg.historyRetention.String(),
+		"dlq_retention":                 cfg.dlqRetention.String(),
+		"dlq_cleanup_interval":          cfg.dlqCleanupInterval.String(),
+		"history_record_offsets":        cfg.recordOffsets,
+		"stream_checksum":               cfg.streamChecksum,
+		"check_ordering":                cfg.checkOrdering,
+		"strict_payloads":               cfg.strictPayloads,
+		"metric_buckets":                cfg.metricBuckets,
+		"canary":                        cfg.canary,
+		"canary_interval":               cfg.canaryInterval.String(),
+		"canary_timeout":                cfg.canaryTimeout.String(),
+		"aggregate_types":               cfg.aggregateTypes,
+		"chain_topic":                   cfg.chainTopic,
+		"secondary_file":                cfg.secondaryFile,
+		"secondary_file_timeout":        cfg.secondaryFileTimeout.String(),
+		"log_level":                     cfg.logLevel,
+		"reload_file":                   cfg.reloadFile,
+		"log_format":                    cfg.logFormat,
+		"log_redact_payloads":           cfg.redactPayloads,
+	}
+}
+
+// configHandler returns the effective configuration with secrets masked.
+func (app *application) configHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"config": app.config.redacted()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diagnosticsHandler returns a support bundle: redacted config, version,
+// schema version, connection pool stats and consumer status in a single
+// response.
+func (app *application) diagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	stat := app.pool.Stat()
+
+	env := envelope{
+		"version": version,
+		"config":  app.config.redacted(),
+		"schema":  app.schemaStatus(r.Context()),
+		"pool": envelope{
+			"total_conns":         stat.TotalConns(),
+			"idle_conns":          stat.IdleConns(),
+			"acquired_conns":      stat.AcquiredConns(),
+			"max_conns":           stat.MaxConns(),
+			"acquire_count":       stat.AcquireCount(),
+			"empty_acquire_count": stat.EmptyAcquireCount(),
+			"acquire_duration":    stat.AcquireDuration().String(),
+		},
+		"consumer": app.consumer.Status(),
+	}
+
+	if app.canary != nil {
+		healthy, lastErr, lastSeen := app.canary.Status()
+		canary := envelope{"healthy": healthy, "last_seen": lastSeen}
+		if lastErr != nil {
+			canary["error"] = lastErr.Error()
+		}
+		env["canary"] = canary
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// quiesceTimeout bounds how long a quiesce request waits for in-flight messages.
+const quiesceTimeout = 30 * time.Second
+
+// quiesceConsumerHandler stops consumption after draining and committing
+// in-flight messages, leaving the HTTP API serving reads.
+func (app *application) quiesceConsumerHandler(w http.ResponseWriter, r *http.Request) {
+	// Finish the drain even if the caller disconnects; it is safe to call again
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), quiesceTimeout)
+	defer cancel()
+
+	if err := app.consumer.Quiesce(ctx); err != nil {
+		app.logError(r, err)
+		app.timeoutResponse(w, r)
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"consumer": app.consumer.Status()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// seekConsumerHandler moves the consumer group's position on one partition to
+// an offset or to the first message at or after a time, to skip a poison
+// range. The body must set "confirm": true, since skipped messages are never
+// applied. It reports the committed offset before and after the seek.
+func (app *application) seekConsumerHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Partition *int      `json:"partition"`
+		Offset    *int64    `json:"offset"`
+		Time      time.Time `json:"time"`
+		Confirm   bool      `json:"confirm"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("body must be a JSON object with partition, offset or time, and confirm: %v", err))
+		return
+	}
+
+	switch {
+	case req.Partition == nil || *req.Partition < 0:
+		app.badRequestResponse(w, r, errors.New("partition must be a non-negative integer"))
+		return
+	case (req.Offset == nil) == req.Time.IsZero():
+		app.badRequestResponse(w, r, errors.New("exactly one of offset and time must be provided"))
+		return
+	case req.Offset != nil && *req.Offset < 0:
+		app.badRequestResponse(w, r, errors.New("offset must be a non-negative integer"))
+		return
+	case !req.Confirm:
+		app.badRequestResponse(w, r, errors.New("confirm must be true: messages skipped by a seek are never applied"))
+		return
+	}
+
+	var offset int64
+	if req.Offset != nil {
+		offset = *req.Offset
+	}
+
+	// Like a quiesce, finish the seek even if the caller disconnects
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), quiesceTimeout)
+	defer cancel()
+
+	result, err := app.consumer.Seek(ctx, *req.Partition, offset, req.Time)
+	if err != nil {
+		switch {
+		case errors.Is(err, kafka.ErrConsumerStopping):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"seek": result, "consumer": app.consumer.Status()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// defaultDedupRetentionDays is the retention used by dedupCleanupHandler when
+// the request doesn't override it.
+const defaultDedupRetentionDays = 7
+
+// dedupCleanupHandler deletes processed_events rows older than
+// ?retention_days (default 7) and reports how many were deleted. Events
+// redelivered after their row is deleted would be applied again, so the
+// retention must exceed how far back the consumer can ever re-read.
+func (app *application) dedupCleanupHandler(w http.ResponseWriter, r *http.Request) {
+	days := defaultDedupRetentionDays
+	if v := r.URL.Query().Get("retention_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			app.badRequestResponse(w, r, errors.New("retention_days must be a positive integer"))
+			return
+		}
+		days = n
+	}
+
+	deleted, err := app.dedup.CleanupOldEvents(r.Context(), days)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.logger.Info("dedup cleanup", "retention_days", days, "deleted", deleted)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deleted": deleted, "retention_days": days}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dedupVacuumHandler vacuums and analyzes processed_events, reporting its
+// size before and after. Run it after a large dedup cleanup.
+func (app *application) dedupVacuumHandler(w http.ResponseWriter, r *http.Request) {
+	tables, err := pgmaint.Vacuum(r.Context(), app.pool, "processed_events")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.logger.Info("dedup vacuum", "bytes_before", tables[0].BytesBefore, "bytes_after", tables[0].BytesAfter)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tables": tables}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// maxDLQReplayIDs caps how many dead letters a single replay request may select.
+const maxDLQReplayIDs = 1000
+
+// dlqReplayHandler re-publishes the dead letters selected by ID to the topics
+// they were read from, once the cause of their failure has been fixed, and
+// reports which were replayed, which failed and which don't exist. Replayed
+// events keep their IDs, so dedup skips any that were applied after all.
+func (app *application) dlqReplayHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("body must be a JSON object with ids: %v", err))
+		return
+	}
+
+	switch {
+	case len(req.IDs) == 0:
+		app.badRequestResponse(w, r, errors.New("ids must be provided"))
+		return
+	case len(req.IDs) > maxDLQReplayIDs:
+		app.badRequestResponse(w, r, fmt.Errorf("at most %d ids may be replayed at once", maxDLQReplayIDs))
+		return
+	}
+
+	result, err := app.dlq.Replay(r.Context(), app.dlqPublisher, req.IDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.logger.Info("dead letter replay", "replayed", len(result.Replayed), "failed", len(result.Failed), "not_found", len(result.NotFound))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"replay": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+
+	"github.com/aelhady03/sumflow/pkg/httperr"
 )
 
 // logError writes an error message to the application log, along with the request method and URL.
@@ -27,11 +29,21 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 	}
 }
 
-// serverErrorResponse is a helper method for sending a 500 Internal Server Error response to the client.
+// serverErrorResponse is a helper method for sending an error response for a
+// failed operation to the client, with the status httperr maps the error to:
+// 500 Internal Server Error, or 499 for a request the client cancelled. A
+// request that ran out of time gets timeoutResponse.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	switch status := httperr.StatusFromError(err); status {
+	case http.StatusGatewayTimeout:
+		app.timeoutResponse(w, r)
+	case httperr.StatusClientClosedRequest:
+		app.errorResponse(w, r, status, "the request was cancelled")
+	default:
+		message := "the server encountered a problem and could not process your request"
+		app.errorResponse(w, r, status, message)
+	}
 }
 
 // notFoundResponse is a helper method for sending a 404 error response to the client.
@@ -45,3 +57,21 @@ func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
+
+// timeoutResponse is a helper method for sending a 503 error response when a request exceeds its handler timeout.
+func (app *application) timeoutResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request took too long to complete, please try again"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// badRequestResponse is a helper method for sending a 400 error response to the client.
+func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+}
+
+// invalidAuthenticationTokenResponse is a helper method for sending a 401 error response when the admin token is missing or wrong.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
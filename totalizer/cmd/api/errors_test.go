@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aelhady03/sumflow/pkg/httperr"
+)
+
+func TestServerErrorResponse(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"failure", errors.New("connection refused"), http.StatusInternalServerError},
+		{"cancelled", fmt.Errorf("load total: %w", context.Canceled), httperr.StatusClientClosedRequest},
+		{"timed out", fmt.Errorf("load total: %w", context.DeadlineExceeded), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.serverErrorResponse(w, httptest.NewRequest(http.MethodGet, "/v1/results", nil), tt.err)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aelhady03/sumflow/totalizer/internal/storage"
+)
+
+const (
+	// exportFlushEvery is how many rows are written between flushes to the client.
+	exportFlushEvery = 500
+	// exportWriteWindow is how long the client has to accept each flushed chunk.
+	exportWriteWindow = 30 * time.Second
+)
+
+// exportHistoryHandler streams sum_history as NDJSON or CSV. Rows are read from
+// the database only as fast as the client accepts them, so the export never
+// buffers the whole table in memory.
+func (app *application) exportHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	format := qs.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		app.badRequestResponse(w, r, fmt.Errorf("format must be ndjson or csv"))
+		return
+	}
+
+	limit := app.config.exportMaxRows
+	if v := qs.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			app.badRequestResponse(w, r, errors.New("limit must be a positive integer"))
+			return
+		}
+		limit = min(n, app.config.exportMaxRows)
+	}
+
+	rc := http.NewResponseController(w)
+	// The server's WriteTimeout is sized for ordinary responses; extend it chunk by chunk instead
+	rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+
+	filename := fmt.Sprintf("sum_history_%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var write func(storage.HistoryEntry) error
+	var flush func() error
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id", "event_id", "value", "event_created_at", "applied_at", "kafka_partition", "kafka_offset", "source"}); err != nil {
+			return
+		}
+		write = func(e storage.HistoryEntry) error {
+			return cw.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.EventID.String(),
+				strconv.FormatInt(e.Value, 10),
+				e.EventCreatedAt.UTC().Format(time.RFC3339Nano),
+				e.AppliedAt.UTC().Format(time.RFC3339Nano),
+				formatOptional(e.KafkaPartition),
+				formatOptional(e.KafkaOffset),
+				e.Source,
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(e storage.HistoryEntry) error {
+			return enc.Encode(envelope{
+				"id":               e.ID,
+				"event_id":         e.EventID,
+				"value":            e.Value,
+				"event_created_at": e.EventCreatedAt,
+				"applied_at":       e.AppliedAt,
+				"kafka_partition":  e.KafkaPartition,
+				"kafka_offset":     e.KafkaOffset,
+				"source":           e.Source,
+			})
+		}
+		flush = func() error { return nil }
+	}
+
+	rows := 0
+	err := app.service.ExportHistory(r.Context(), limit, func(e storage.HistoryEntry) error {
+		if err := write(e); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// Headers are already sent, so the only thing left to do is log and cut the stream short
+		app.logError(r, fmt.Errorf("history export aborted after %d rows: %w", rows, err))
+	}
+}
+
+// formatOptional formats a nullable column for CSV, leaving NULL empty.
+func formatOptional[T int | int64](v *T) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(int64(*v), 10)
+}
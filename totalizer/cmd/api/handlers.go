@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+
+	"github.com/aelhady03/sumflow/totalizer/internal/data"
+	"github.com/aelhady03/sumflow/totalizer/internal/database"
+	"github.com/aelhady03/sumflow/totalizer/internal/storage"
+	"github.com/julienschmidt/httprouter"
 )
 
 // healthcheckHandler returns a simple status message to indicate that the API is running.
@@ -15,22 +23,234 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		},
 	}
 
+	if r.URL.Query().Get("verbose") == "true" {
+		env["schema"] = app.schemaStatus(r.Context())
+	}
+
 	err := app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// getResultHandler returns a simple sum result.
+// readyHandler reports whether the service can do useful work: the database
+// must be reachable and migrated to at least the schema version this binary
+// expects and, if the canary is enabled, the last canary event must have made
+// it through Kafka and the consumer. It fails as soon as graceful shutdown begins.
+func (app *application) readyHandler(w http.ResponseWriter, r *http.Request) {
+	// Liveness (/v1/healthcheck) stays green while draining; only readiness fails
+	if app.shuttingDown.Load() {
+		err := app.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "shutting down"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ready := true
+	checks := envelope{}
+
+	if err := app.pool.Ping(r.Context()); err != nil {
+		ready = false
+		checks["database"] = err.Error()
+	} else {
+		checks["database"] = "ok"
+	}
+
+	schema := app.schemaStatus(r.Context())
+	if schema["up_to_date"] != true {
+		ready = false
+	}
+	checks["schema"] = schema
+
+	if app.canary != nil {
+		healthy, lastErr, lastSeen := app.canary.Status()
+		check := envelope{"healthy": healthy}
+		if !lastSeen.IsZero() {
+			check["last_seen"] = lastSeen
+		}
+		if !healthy {
+			ready = false
+			check["error"] = lastErr.Error()
+		}
+		checks["canary"] = check
+	}
+
+	status := http.StatusOK
+	env := envelope{"status": "ready", "checks": checks}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		env["status"] = "not ready"
+	}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// schemaStatus compares the schema version this binary expects with the one
+// applied to the database.
+func (app *application) schemaStatus(ctx context.Context) envelope {
+	applied, err := database.AppliedSchemaVersion(ctx, app.pool)
+	if err != nil {
+		return envelope{"expected": database.SchemaVersion, "up_to_date": false, "error": err.Error()}
+	}
+	app.metrics.SchemaVersion.Set(float64(applied))
+
+	return envelope{
+		"expected":   database.SchemaVersion,
+		"applied":    applied,
+		"up_to_date": applied >= database.SchemaVersion,
+	}
+}
+
+// getResultHandler returns the sum result and details of the last change.
+// ?fresh=true bypasses the result cache; a bare response is just the total,
+// the envelope's "result".
 func (app *application) getResultHandler(w http.ResponseWriter, r *http.Request) {
+	bare, err := app.bareResponse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	result, ok := app.readResult(w, r)
+	if !ok {
+		return
+	}
+
+	// "result" stays the bare total for existing clients; the details sit alongside it
+	var body any = envelope{"result": result.Total, "details": result}
+	if bare {
+		body = result.Total
+	}
+	err = app.writeJSON(w, http.StatusOK, body, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readResult loads the result, bypassing the result cache for ?fresh=true.
+// On failure it writes the error response and returns false.
+func (app *application) readResult(w http.ResponseWriter, r *http.Request) (*data.Result, bool) {
+	get := app.service.GetResult
+	if r.URL.Query().Get("fresh") == "true" {
+		get = app.service.GetFreshResult
+	}
 
-	total, err := app.service.Get()
+	result, err := get(r.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			app.timeoutResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return result, true
+}
+
+// getTypeTotalHandler returns the total contributed by a single event type.
+// A bare response is just the total.
+func (app *application) getTypeTotalHandler(w http.ResponseWriter, r *http.Request) {
+	eventType := httprouter.ParamsFromContext(r.Context()).ByName("event_type")
+
+	bare, err := app.bareResponse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	total, err := app.service.GetByType(r.Context(), eventType)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNoTypeTotal):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, context.DeadlineExceeded):
+			app.timeoutResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var body any = envelope{"event_type": eventType, "total": total}
+	if bare {
+		body = total
+	}
+	err = app.writeJSON(w, http.StatusOK, body, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// statsHandler reports the total, the number of applied events and the
+// stream checksum, for auditors comparing totalizers fed the same stream.
+func (app *application) statsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.service.GetStats(r.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			app.timeoutResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// maxKeysListed caps how many keys listKeysHandler returns.
+const maxKeysListed = 1000
+
+// listKeysHandler lists the active keyed totals, soonest to expire first, up
+// to ?limit (default and maximum 1000). It is empty unless keys expire.
+func (app *application) listKeysHandler(w http.ResponseWriter, r *http.Request) {
+	limit := maxKeysListed
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			app.badRequestResponse(w, r, errors.New("limit must be a positive integer"))
+			return
+		}
+		limit = min(n, maxKeysListed)
+	}
+
+	keys, err := app.service.GetKeyedTotals(r.Context(), limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			app.timeoutResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"result": total}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"keys": keys, "ttl": app.config.keyTTL.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// metricsSummaryHandler reports consumption over the recent window, including
+// the share of messages the dedup layer rejected as duplicates. A high ratio
+// points at producer re-delivery or consumer offset resets.
+func (app *application) metricsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	sum := app.summary.Summary()
+
+	env := envelope{
+		"window":  sum.Window.String(),
+		"summary": sum,
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
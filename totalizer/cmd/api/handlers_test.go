@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aelhady03/sumflow/pkg/pgtest"
+	"github.com/aelhady03/sumflow/totalizer/internal/database"
+	"github.com/aelhady03/sumflow/totalizer/internal/service"
+	"github.com/aelhady03/sumflow/totalizer/internal/storage"
+)
+
+// newResultApp returns an application whose total has been seeded to total
+// on a migrated test schema.
+func newResultApp(t *testing.T, total int64) *application {
+	t.Helper()
+	ctx := context.Background()
+	pool := pgtest.Pool(t)
+	if err := database.RunMigrations(ctx, pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	store := storage.NewPostgresStorage(pool)
+	if seeded, err := store.SeedTotal(ctx, total); err != nil || !seeded {
+		t.Fatalf("seed: seeded %v, err %v", seeded, err)
+	}
+	return &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		service: service.NewTotalizerService(store),
+	}
+}
+
+func TestGetResultHandler(t *testing.T) {
+	app := newResultApp(t, 42)
+
+	tests := []struct {
+		name          string
+		query         string
+		bareResponses bool
+		bare          bool
+	}{
+		{"enveloped by default", "", false, false},
+		{"bare by default", "", true, true},
+		{"bare on request", "?envelope=false", false, true},
+		{"enveloped on request", "?envelope=true", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.config.bareResponses = tt.bareResponses
+			w := httptest.NewRecorder()
+			app.getResultHandler(w, httptest.NewRequest(http.MethodGet, "/v1/results"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			// Both modes carry the same value: bare is the envelope's "result"
+			var result int64
+			if tt.bare {
+				if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+					t.Fatalf("decode bare body %s: %v", w.Body, err)
+				}
+			} else {
+				var env struct {
+					Result  *int64          `json:"result"`
+					Details json.RawMessage `json:"details"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+					t.Fatalf("decode envelope %s: %v", w.Body, err)
+				}
+				if env.Result == nil || env.Details == nil {
+					t.Fatalf("envelope %s lacks result or details", w.Body)
+				}
+				result = *env.Result
+			}
+			if result != 42 {
+				t.Errorf("result %d, want 42", result)
+			}
+		})
+	}
+}
+
+func TestGetResultHandlerRejectsBadEnvelope(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	w := httptest.NewRecorder()
+	app.getResultHandler(w, httptest.NewRequest(http.MethodGet, "/v1/results?envelope=maybe", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
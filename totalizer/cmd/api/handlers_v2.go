@@ -0,0 +1,17 @@
+package main
+
+import "net/http"
+
+// getResultV2Handler returns the result as a single object under "result".
+// v1 keeps "result" as the bare total with the object alongside as "details".
+func (app *application) getResultV2Handler(w http.ResponseWriter, r *http.Request) {
+	result, ok := app.readResult(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"result": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"maps"
 	"net/http"
+	"strconv"
 )
 
 // envelope is a custom type for a generic JSON object.
@@ -23,3 +25,20 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data any, h
 	w.Write(js)
 	return nil
 }
+
+// bareResponse reports whether the client asked for an un-enveloped response,
+// the server default with -bare-responses, overridden per request by
+// ?envelope=true|false. Only endpoints that call it support bare responses;
+// errors are always enveloped.
+func (app *application) bareResponse(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("envelope")
+	if v == "" {
+		return app.config.bareResponses, nil
+	}
+
+	enveloped, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("envelope must be true or false")
+	}
+	return !enveloped, nil
+}
@@ -9,16 +9,30 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/aelhady03/sumflow/pkg/shutdown"
 	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/aelhady03/sumflow/totalizer/internal/canary"
+	"github.com/aelhady03/sumflow/totalizer/internal/chain"
 	"github.com/aelhady03/sumflow/totalizer/internal/database"
 	"github.com/aelhady03/sumflow/totalizer/internal/dedup"
+	"github.com/aelhady03/sumflow/totalizer/internal/dlq"
+	"github.com/aelhady03/sumflow/totalizer/internal/expiry"
 	"github.com/aelhady03/sumflow/totalizer/internal/kafka"
+	"github.com/aelhady03/sumflow/totalizer/internal/ordering"
+	"github.com/aelhady03/sumflow/totalizer/internal/replay"
 	"github.com/aelhady03/sumflow/totalizer/internal/service"
+	"github.com/aelhady03/sumflow/totalizer/internal/snapshot"
 	"github.com/aelhady03/sumflow/totalizer/internal/storage"
+	"github.com/aelhady03/sumflow/totalizer/internal/summary"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	kafkago "github.com/segmentio/kafka-go"
 )
 
 const version = "1.0.0"
@@ -31,14 +45,104 @@ type config struct {
 	kafkaTopic   string
 	kafkaGroupID string
 	otlpEndpoint string
+	migrate      bool
+	initialTotal int64
+
+	resultCacheTTL  time.Duration
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+	handlerTimeout  time.Duration
+	exportMaxRows   int
+	adminToken      string
+	bareResponses   bool
+	pushgatewayURL  string
+
+	logSampleInterval time.Duration
+	summaryWindow     time.Duration
+
+	processTimeout     time.Duration
+	maxProcessTimeouts int
+	maxInFlight        int
+	fairScheduling     bool
+	fairQuantum        int
+	partitionQueueSize int
+	prefetchSize       int
+	fetchQueueCapacity int
+	fetchMinBytes      int
+	fetchMaxBytes      int
+	fetchMaxWait       time.Duration
+	maxRetries         int
+	retryBackoff       time.Duration
+	deadlockRetries    int
+	isolation          string
+	delivery           string
+	totalMode          string
+	maxEventAge        time.Duration
+	backfill           bool
+
+	serializeAggregates bool
+
+	keyTTL           time.Duration
+	keySweepInterval time.Duration
+
+	coalesceWindow   time.Duration
+	coalesceMaxBatch int
+
+	dedupStore string
+	redisAddr  string
+	dedupTTL   time.Duration
+
+	snapshotInterval time.Duration
+	historyRetention time.Duration
+
+	dlqRetention       time.Duration
+	dlqCleanupInterval time.Duration
+
+	checkOrdering  bool
+	recordOffsets  bool
+	streamChecksum bool
+	strictPayloads bool
+	metricBuckets  string
+
+	canary         bool
+	canaryInterval time.Duration
+	canaryTimeout  time.Duration
+
+	chainTopic     string
+	secondaryFile  string
+	aggregateTypes string
+
+	secondaryFileTimeout time.Duration
+
+	logLevel       string
+	logFormat      string
+	redactPayloads bool
+
+	reloadFile string
 }
 
 type application struct {
-	config   config
-	logger   *slog.Logger
-	service  *service.TotalizerService
-	pool     *pgxpool.Pool
-	consumer *kafka.Consumer
+	config      config
+	logger      *slog.Logger
+	service     *service.TotalizerService
+	pool        *pgxpool.Pool
+	consumer    *kafka.Consumer
+	snapshotter *snapshot.Snapshotter
+	canary      *canary.Prober
+	summary     *summary.Tracker
+	replayer    *replay.Replayer
+	dedup       *dedup.Repository
+	metrics     *telemetry.Metrics
+	logLevel    *slog.LevelVar
+
+	dlq          *dlq.Repository
+	dlqCleaner   *dlq.Cleaner
+	dlqPublisher dlq.Publisher
+	sweeper      *expiry.Sweeper
+
+	// shuttingDown is set when graceful shutdown begins, failing readiness
+	// so load balancers stop routing new requests here.
+	shuttingDown atomic.Bool
 }
 
 func main() {
@@ -51,13 +155,92 @@ func main() {
 	flag.StringVar(&cfg.kafkaTopic, "kafka-topic", "sums", "Kafka topic to consume")
 	flag.StringVar(&cfg.kafkaGroupID, "kafka-group-id", "totalizer-group", "Kafka consumer group ID")
 	flag.StringVar(&cfg.otlpEndpoint, "otlp-endpoint", "otel-collector:4317", "OpenTelemetry Collector endpoint")
+	flag.DurationVar(&cfg.processTimeout, "consumer-process-timeout", 30*time.Second, "Maximum time to process a single Kafka message (0 disables)")
+	flag.IntVar(&cfg.maxProcessTimeouts, "consumer-max-process-timeouts", 0, "Timeouts after which a message is dead-lettered (0 retries forever)")
+	flag.IntVar(&cfg.maxInFlight, "consumer-max-in-flight", 0, "Maximum messages processed concurrently across partitions (0 means one per partition)")
+	flag.BoolVar(&cfg.fairScheduling, "consumer-fair-scheduling", false, "Share consumer-max-in-flight slots round-robin across partitions so a hot partition can't starve the others")
+	flag.IntVar(&cfg.fairQuantum, "consumer-fair-quantum", 1, "Most queued messages a partition processes per turn under consumer-fair-scheduling")
+	flag.IntVar(&cfg.partitionQueueSize, "consumer-partition-queue", 64, "Fetched messages buffered per partition")
+	flag.StringVar(&cfg.dedupStore, "dedup-store", "postgres", "Dedup store checked before the database (postgres|redis)")
+	flag.StringVar(&cfg.redisAddr, "redis-addr", "localhost:6379", "Redis address for the redis dedup store")
+	flag.DurationVar(&cfg.dedupTTL, "dedup-ttl", 24*time.Hour, "How long the redis dedup store remembers processed events")
+	flag.DurationVar(&cfg.snapshotInterval, "snapshot-interval", 0, "Interval between total snapshots, which also truncates snapshotted history past -history-retention (0 disables both)")
+	flag.DurationVar(&cfg.dlqRetention, "dlq-retention", 0, "Delete dead letters older than this (0 keeps them until replayed)")
+	flag.DurationVar(&cfg.dlqCleanupInterval, "dlq-cleanup-interval", time.Hour, "Interval between dead letter retention cleanups")
+	flag.DurationVar(&cfg.historyRetention, "history-retention", 30*24*time.Hour, "Minimum age of snapshotted history rows before they are truncated")
+	flag.BoolVar(&cfg.streamChecksum, "stream-checksum", false, "Chain a SHA-256 checksum over every applied event, exposed at /v1/stats")
+	flag.BoolVar(&cfg.recordOffsets, "history-record-offsets", false, "Store the Kafka partition and offset of each applied message in sum_history")
+	flag.BoolVar(&cfg.checkOrdering, "check-ordering", false, "Track per-aggregate sequence numbers and report out-of-order events")
+	flag.StringVar(&cfg.metricBuckets, "metric-buckets", "", "Histogram bucket overrides, e.g. kafka_delivery_latency_seconds=0.001,0.01,0.1;event_processing_latency_seconds=1,10,60")
+	flag.BoolVar(&cfg.canary, "canary", false, "Periodically publish a canary event and require it to be consumed for readiness")
+	flag.DurationVar(&cfg.canaryInterval, "canary-interval", 30*time.Second, "Interval between canary events")
+	flag.DurationVar(&cfg.canaryTimeout, "canary-timeout", 10*time.Second, "Time a canary event has to be consumed")
+	flag.DurationVar(&cfg.handlerTimeout, "handler-timeout", 3*time.Second, "Maximum time an HTTP handler may spend before failing with 503 (0 disables)")
+	flag.StringVar(&cfg.pushgatewayURL, "pushgateway-url", "", "Push the final metrics to this Prometheus Pushgateway on shutdown (empty disables)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 10*time.Second, "Overall time allowed for graceful shutdown: consumer drain, server shutdown and tracer flush")
+	flag.DurationVar(&cfg.drainDelay, "shutdown-drain-delay", 0, "Time between failing readiness and starting shutdown, so load balancers stop routing here first (set above the readiness probe period)")
+	flag.DurationVar(&cfg.resultCacheTTL, "result-cache-ttl", time.Second, "Serve /v1/results from memory for up to this long between applied events (0 disables)")
+	flag.BoolVar(&cfg.bareResponses, "bare-responses", false, "Return /v1/results and /v1/total/type bodies without the JSON envelope unless ?envelope=true")
+	flag.IntVar(&cfg.exportMaxRows, "history-export-max-rows", 1_000_000, "Maximum rows returned by a single history export")
+	flag.StringVar(&cfg.adminToken, "admin-token", os.Getenv("TOTALIZER_ADMIN_TOKEN"), "Bearer token for /v1/admin endpoints (empty disables them)")
+	flag.BoolVar(&cfg.strictPayloads, "strict-payloads", false, "Dead-letter events whose payload is missing fields instead of treating them as zero")
+	flag.DurationVar(&cfg.logSampleInterval, "log-sample-interval", 10*time.Second, "Log repetitive consumer errors at most once per interval (0 logs every error)")
+	flag.DurationVar(&cfg.summaryWindow, "summary-window", 5*time.Minute, "Window over which /v1/metrics/summary reports consumption ratios")
+	flag.IntVar(&cfg.maxRetries, "consumer-max-retries", 5, "Quick retries for a failing message before it holds its partition and is retried with a longer backoff (invalid payloads are dead-lettered instead)")
+	flag.DurationVar(&cfg.retryBackoff, "consumer-retry-backoff", 500*time.Millisecond, "Initial backoff between message retries, doubled after each attempt")
+	flag.IntVar(&cfg.deadlockRetries, "consumer-deadlock-retries", 5, "Retries for a message whose transaction deadlocked, on top of consumer-max-retries (0 disables)")
+	flag.StringVar(&cfg.isolation, "consumer-isolation", "read-committed", "Isolation level for applying consumed events (read-committed|repeatable-read|serializable)")
+	flag.StringVar(&cfg.delivery, "consumer-delivery", "at-least-once", "Offset commit semantics (at-least-once|at-most-once); at-most-once can lose updates on failure")
+	flag.StringVar(&cfg.totalMode, "consumer-total-mode", "additive", "How results update the totals (additive|materialize-latest); materialize-latest keeps the latest value per message key, for compacted topics")
+	flag.DurationVar(&cfg.maxEventAge, "consumer-max-event-age", 0, "Skip events created longer ago than this; keep it below the dedup retention (0 disables)")
+	flag.BoolVar(&cfg.serializeAggregates, "consumer-serialize-aggregates", false, "Process at most one event per aggregate at a time; unnecessary for additive totals")
+	flag.DurationVar(&cfg.keyTTL, "consumer-key-ttl", 0, "Track each key's contribution to the total and subtract it once the key has seen no event for this long (0 disables; additive mode only)")
+	flag.DurationVar(&cfg.coalesceWindow, "consumer-coalesce-window", 0, "Apply events arriving within this window in one transaction with a single totals update, delaying each by up to the window (0 disables)")
+	flag.IntVar(&cfg.coalesceMaxBatch, "consumer-coalesce-max-batch", 500, "Most messages in a coalesced batch before it is applied early")
+	flag.DurationVar(&cfg.keySweepInterval, "key-sweep-interval", time.Minute, "Interval between sweeps for keys past consumer-key-ttl")
+	flag.BoolVar(&cfg.backfill, "consumer-backfill", false, "Apply events of any age, ignoring consumer-max-event-age, for deliberate backfills")
+	flag.Int64Var(&cfg.initialTotal, "initial-total", 0, "Seed the total with this value at startup if no event has been applied yet, e.g. when migrating from a legacy system (0 disables)")
+	flag.BoolVar(&cfg.migrate, "migrate", true, "Apply pending schema migrations at startup (disable when migrations run separately; /v1/ready fails until they do)")
+	flag.IntVar(&cfg.prefetchSize, "consumer-prefetch", 0, "Fetched messages buffered ahead of dispatch to partition workers")
+	flag.IntVar(&cfg.fetchQueueCapacity, "consumer-fetch-queue", 100, "Kafka reader's internal fetch queue capacity")
+	flag.IntVar(&cfg.fetchMinBytes, "consumer-fetch-min-bytes", 10e3, "Data the broker waits for before answering a fetch")
+	flag.IntVar(&cfg.fetchMaxBytes, "consumer-fetch-max-bytes", 10e6, "Maximum data returned by a single fetch")
+	flag.DurationVar(&cfg.fetchMaxWait, "consumer-fetch-max-wait", 10*time.Second, "Maximum time the broker waits for consumer-fetch-min-bytes before answering a fetch")
+	flag.StringVar(&cfg.aggregateTypes, "aggregate-types", "sum", "Comma-separated aggregate types to accept; events of other types are dead-lettered (empty accepts all)")
+	flag.DurationVar(&cfg.secondaryFileTimeout, "secondary-file-timeout", 5*time.Second, "Give up on a secondary-file read or write after this long, e.g. on a hung filesystem (0 waits indefinitely)")
+	flag.StringVar(&cfg.secondaryFile, "secondary-file", "", "Also write the total to this file after every applied event, best effort, e.g. during a storage migration (empty disables)")
+	flag.StringVar(&cfg.chainTopic, "chain-topic", "", "Publish a total.updated event to this topic after every applied sum, via a durable outbox (empty disables)")
+	flag.StringVar(&cfg.logLevel, "log-level", "info", "Minimum log level (debug|info|warn|error); debug logs every handled event")
+	flag.StringVar(&cfg.logFormat, "log-format", "text", "Log output format (text|json)")
+	flag.BoolVar(&cfg.redactPayloads, "log-redact-payloads", false, "Leave event payloads out of debug logs")
+	flag.StringVar(&cfg.reloadFile, "reload-file", "", "JSON file of settings re-read on SIGHUP: log_level, log_sample_interval (empty ignores SIGHUP)")
 	flag.Parse()
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	level := new(slog.LevelVar)
+	if err := level.UnmarshalText([]byte(cfg.logLevel)); err != nil {
+		log.Fatalf("invalid log level: %v", err)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	var logger *slog.Logger
+	switch cfg.logFormat {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stdout, handlerOpts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, handlerOpts))
+	default:
+		log.Fatalf("unknown log format %q", cfg.logFormat)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	buckets, err := telemetry.ParseBuckets(cfg.metricBuckets)
+	if err != nil {
+		log.Fatalf("invalid -metric-buckets: %v", err)
+	}
+	metrics := telemetry.NewMetrics(prometheus.DefaultRegisterer, telemetry.MetricsOptions{Buckets: buckets})
+
 	// Initialize telemetry
 	shutdownTracer, err := telemetry.InitTracer(ctx, telemetry.Config{
 		ServiceName:    "totalizer",
@@ -77,32 +260,229 @@ func main() {
 	defer pool.Close()
 
 	// Run migrations
-	if err := database.RunMigrations(ctx, pool); err != nil {
-		log.Fatalf("failed to run migrations: %v", err)
+	if cfg.migrate {
+		if err := database.RunMigrations(ctx, pool); err != nil {
+			log.Fatalf("failed to run migrations: %v", err)
+		}
+		// Without -migrate the schema may legitimately lag until migrations run
+		if err := database.CheckSchema(ctx, pool); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// Initialize components
 	pgStorage := storage.NewPostgresStorage(pool)
+
+	if cfg.initialTotal != 0 {
+		seeded, err := pgStorage.SeedTotal(ctx, cfg.initialTotal)
+		if err != nil {
+			log.Fatalf("failed to seed initial total: %v", err)
+		}
+		if seeded {
+			logger.Info("seeded initial total", "total", cfg.initialTotal)
+		} else {
+			logger.Warn("not seeding initial total: the total has already been seeded or had events applied", "initial_total", cfg.initialTotal)
+		}
+	}
 	dedupRepo := dedup.NewRepository(pool)
+	dlqRepo := dlq.NewRepository(pool)
 
 	// Initialize and start Kafka consumer
 	consumerCfg := kafka.ConsumerConfig{
 		Brokers: []string{cfg.kafkaBrokers},
 		Topic:   cfg.kafkaTopic,
 		GroupID: cfg.kafkaGroupID,
+
+		ProcessTimeout:     cfg.processTimeout,
+		MaxProcessTimeouts: cfg.maxProcessTimeouts,
+		MaxInFlight:        cfg.maxInFlight,
+		FairScheduling:     cfg.fairScheduling,
+		FairQuantum:        cfg.fairQuantum,
+		PartitionQueueSize: cfg.partitionQueueSize,
+		PrefetchSize:       cfg.prefetchSize,
+		FetchQueueCapacity: cfg.fetchQueueCapacity,
+		Fetch: kafka.FetchSettings{
+			MinBytes: cfg.fetchMinBytes,
+			MaxBytes: cfg.fetchMaxBytes,
+			MaxWait:  cfg.fetchMaxWait,
+		},
+		StrictPayloads:     cfg.strictPayloads,
+		LogSampleInterval:  cfg.logSampleInterval,
+		MaxRetries:         cfg.maxRetries,
+		RetryBackoff:       cfg.retryBackoff,
+		MaxDeadlockRetries: cfg.deadlockRetries,
+		RecordOffsets:      cfg.recordOffsets,
+		StreamChecksum:     cfg.streamChecksum,
+		Logger:             logger,
+		RedactPayloads:     cfg.redactPayloads,
+
+		SerializeAggregates: cfg.serializeAggregates,
+		KeyTTL:              cfg.keyTTL,
+
+		CoalesceWindow:   cfg.coalesceWindow,
+		CoalesceMaxBatch: cfg.coalesceMaxBatch,
+	}
+
+	if !cfg.backfill {
+		consumerCfg.MaxEventAge = cfg.maxEventAge
+	}
+
+	if cfg.aggregateTypes != "" {
+		consumerCfg.AggregateTypes = strings.Split(cfg.aggregateTypes, ",")
+	}
+
+	consumerCfg.IsoLevel, err = kafka.ParseIsoLevel(cfg.isolation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	consumerCfg.Delivery, err = kafka.ParseDeliverySemantics(cfg.delivery)
+	if err != nil {
+		log.Fatal(err)
+	}
+	consumerCfg.Mode, err = kafka.ParseTotalMode(cfg.totalMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.keyTTL > 0 && consumerCfg.Mode != kafka.Additive {
+		log.Fatal("-consumer-key-ttl requires -consumer-total-mode=additive")
+	}
+	if cfg.coalesceWindow > 0 {
+		switch {
+		case consumerCfg.Delivery != kafka.AtLeastOnce:
+			log.Fatal("-consumer-coalesce-window requires -consumer-delivery=at-least-once")
+		case cfg.streamChecksum:
+			log.Fatal("-consumer-coalesce-window can't be combined with -stream-checksum")
+		case cfg.chainTopic != "":
+			log.Fatal("-consumer-coalesce-window can't be combined with -chain-topic")
+		case cfg.serializeAggregates:
+			log.Fatal("-consumer-coalesce-window can't be combined with -consumer-serialize-aggregates")
+		case cfg.keyTTL > 0:
+			log.Fatal("-consumer-coalesce-window can't be combined with -consumer-key-ttl")
+		}
+	}
+
+	if cfg.secondaryFile != "" {
+		file := storage.NewFileStorage(cfg.secondaryFile, 0)
+		file.Timeout = cfg.secondaryFileTimeout
+		secondary := storage.NewMultiStorage(pgStorage, metrics, storage.Backend{
+			Name:    "file",
+			Storage: file,
+		})
+		// Catch the secondary up with events applied while it wasn't mirrored
+		if err := secondary.Sync(ctx); err != nil {
+			log.Fatalf("failed to sync secondary storage: %v", err)
+		}
+		consumerCfg.Secondary = secondary
+	}
+
+	if cfg.checkOrdering {
+		consumerCfg.Ordering = ordering.NewRepository(pool)
+	}
+
+	switch cfg.dedupStore {
+	case "postgres":
+	case "redis":
+		redisClient := redis.NewClient(&redis.Options{Addr: cfg.redisAddr})
+		defer redisClient.Close()
+		consumerCfg.DedupStore = dedup.NewRedisStore(redisClient, cfg.dedupTTL)
+	default:
+		log.Fatalf("unknown dedup store %q", cfg.dedupStore)
+	}
+
+	var prober *canary.Prober
+	if cfg.canary {
+		canaryWriter := &kafkago.Writer{
+			Addr:  kafkago.TCP(cfg.kafkaBrokers),
+			Topic: cfg.kafkaTopic,
+		}
+		defer canaryWriter.Close()
+
+		canaryCfg := canary.DefaultConfig()
+		canaryCfg.Interval = cfg.canaryInterval
+		canaryCfg.Timeout = cfg.canaryTimeout
+		prober = canary.NewProber(canaryWriter, canaryCfg)
+		consumerCfg.Canary = prober
+	}
+
+	var chainRelay *chain.Relay
+	if cfg.chainTopic != "" {
+		if cfg.chainTopic == cfg.kafkaTopic {
+			log.Fatal("chain topic must not be the topic the totalizer consumes")
+		}
+		chainWriter := &kafkago.Writer{
+			Addr:  kafkago.TCP(cfg.kafkaBrokers),
+			Topic: cfg.chainTopic,
+		}
+		defer chainWriter.Close()
+
+		chainOutbox := chain.NewOutbox(pool)
+		consumerCfg.Chain = chainOutbox
+		chainRelay = chain.NewRelay(chainOutbox, chainWriter, chain.DefaultRelayConfig())
+		chainRelay.Start(ctx)
 	}
-	consumer := kafka.NewConsumer(consumerCfg, pool, dedupRepo, pgStorage)
-	consumer.Start(ctx)
 
 	// Initialize service
 	svc := service.NewTotalizerService(pgStorage)
+	if cfg.resultCacheTTL > 0 {
+		svc.EnableResultCache(cfg.resultCacheTTL)
+		consumerCfg.TotalObserver = svc
+	}
+
+	consumer := kafka.NewConsumer(consumerCfg, pool, dedupRepo, dlqRepo, pgStorage, metrics)
+	consumer.Start(ctx)
+
+	var sweeper *expiry.Sweeper
+	if cfg.keyTTL > 0 {
+		sweeper = expiry.NewSweeper(pgStorage, cfg.keySweepInterval, svc)
+		sweeper.Start(ctx)
+	}
+	if prober != nil {
+		prober.Start(ctx)
+	}
+
+	var snapshotter *snapshot.Snapshotter
+	if cfg.snapshotInterval > 0 {
+		snapshotCfg := snapshot.DefaultConfig()
+		snapshotCfg.Interval = cfg.snapshotInterval
+		snapshotCfg.Retention = cfg.historyRetention
+		snapshotter = snapshot.NewSnapshotter(pgStorage, snapshotCfg)
+		snapshotter.Start(ctx)
+	}
+
+	var dlqCleaner *dlq.Cleaner
+	if cfg.dlqRetention > 0 {
+		dlqCleaner = dlq.NewCleaner(dlqRepo, cfg.dlqCleanupInterval, cfg.dlqRetention)
+		dlqCleaner.Start(ctx)
+	}
+
+	summaryCfg := summary.DefaultConfig()
+	summaryCfg.Window = cfg.summaryWindow
+	tracker := summary.NewTracker(metrics, cfg.kafkaTopic, summaryCfg)
+	tracker.Start(ctx)
+
+	// Replays, of history and dead letters, name their topic per message, so the writer has none
+	replayWriter := &kafkago.Writer{Addr: kafkago.TCP(cfg.kafkaBrokers)}
+	defer replayWriter.Close()
+	replayer := replay.NewReplayer(pool, pgStorage, replayWriter)
 
 	app := &application{
-		config:   cfg,
-		logger:   logger,
-		service:  svc,
-		pool:     pool,
-		consumer: consumer,
+		config:      cfg,
+		logger:      logger,
+		logLevel:    level,
+		service:     svc,
+		pool:        pool,
+		consumer:    consumer,
+		snapshotter: snapshotter,
+		canary:      prober,
+		summary:     tracker,
+		replayer:    replayer,
+		dedup:       dedupRepo,
+		metrics:     metrics,
+
+		dlq:          dlqRepo,
+		dlqCleaner:   dlqCleaner,
+		dlqPublisher: replayWriter,
+		sweeper:      sweeper,
 	}
 
 	srv := &http.Server{
@@ -114,7 +494,10 @@ func main() {
 		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
 
+	go app.reloadOnSignal(ctx)
+
 	// Graceful shutdown
+	shutdownDone := make(chan struct{})
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -122,25 +505,48 @@ func main() {
 
 		logger.Info("shutting down gracefully...")
 
-		cancel()
-		if err := app.consumer.Stop(); err != nil {
-			logger.Error("error stopping consumer", slog.String("error", err.Error()))
+		// Fail readiness, but keep serving, until load balancers have noticed
+		app.shuttingDown.Store(true)
+		if app.config.drainDelay > 0 {
+			logger.Info("draining before shutdown", slog.Duration("delay", app.config.drainDelay))
+			time.Sleep(app.config.drainDelay)
 		}
 
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer shutdownCancel()
-
-		if err := srv.Shutdown(shutdownCtx); err != nil {
-			logger.Error("error shutting down server", slog.String("error", err.Error()))
+		// Stop the consumer before cancelling the root context so in-flight messages can finish
+		steps := []shutdown.Step{
+			{Name: "consumer", Stop: app.consumer.Stop},
+			{Name: "background tasks", Stop: shutdown.Func(func() {
+				if app.snapshotter != nil {
+					app.snapshotter.Stop()
+				}
+				if app.canary != nil {
+					app.canary.Stop()
+				}
+				if app.dlqCleaner != nil {
+					app.dlqCleaner.Stop()
+				}
+				if app.sweeper != nil {
+					app.sweeper.Stop()
+				}
+				app.summary.Stop()
+				app.replayer.Stop()
+				if chainRelay != nil {
+					chainRelay.Stop()
+				}
+				cancel()
+			})},
+			{Name: "http server", Stop: srv.Shutdown},
 		}
-
 		if shutdownTracer != nil {
-			if err := shutdownTracer(shutdownCtx); err != nil {
-				logger.Error("error shutting down tracer", slog.String("error", err.Error()))
-			}
+			steps = append(steps, shutdown.Step{Name: "tracer", Stop: shutdownTracer})
+		}
+		if app.config.pushgatewayURL != "" {
+			steps = append(steps, shutdown.Step{Name: "metrics push", Stop: telemetry.PushMetrics(app.config.pushgatewayURL, "totalizer", prometheus.DefaultGatherer)})
 		}
+		shutdown.Run(app.config.shutdownTimeout, shutdown.Logf(logger.Warn), steps...)
 
 		logger.Info("shutdown complete")
+		close(shutdownDone)
 	}()
 
 	logger.Info("starting server", slog.String("addr", srv.Addr), slog.String("env", app.config.env))
@@ -149,4 +555,7 @@ func main() {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
+	// ListenAndServe returns as soon as the server shuts down; let the rest
+	// of the shutdown, including the tracer flush and metrics push, finish
+	<-shutdownDone
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// reloadable is the -reload-file contents applied on SIGHUP. Only these
+// settings can change without a restart; omitted ones keep their current
+// value. Every other flag, including -log-format, needs a restart.
+type reloadable struct {
+	LogLevel          *string `json:"log_level"`
+	LogSampleInterval *string `json:"log_sample_interval"`
+}
+
+// reloadOnSignal re-reads -reload-file on every SIGHUP until ctx is done.
+func (app *application) reloadOnSignal(ctx context.Context) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigChan:
+			if app.config.reloadFile == "" {
+				app.logger.Warn("ignoring SIGHUP: no -reload-file configured")
+				continue
+			}
+			if err := app.reload(app.config.reloadFile); err != nil {
+				app.logger.Error("config reload failed", slog.String("file", app.config.reloadFile), slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// reload applies the settings in path. Everything is validated before
+// anything is applied, so an invalid file changes nothing.
+func (app *application) reload(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var r reloadable
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	var level slog.Level
+	if r.LogLevel != nil {
+		if err := level.UnmarshalText([]byte(*r.LogLevel)); err != nil {
+			return fmt.Errorf("log_level: %w", err)
+		}
+	}
+	var sampleInterval time.Duration
+	if r.LogSampleInterval != nil {
+		sampleInterval, err = time.ParseDuration(*r.LogSampleInterval)
+		if err != nil {
+			return fmt.Errorf("log_sample_interval: %w", err)
+		}
+	}
+
+	attrs := []any{slog.String("file", path)}
+	if r.LogLevel != nil {
+		app.logLevel.Set(level)
+		attrs = append(attrs, slog.String("log_level", level.String()))
+	}
+	if r.LogSampleInterval != nil {
+		app.consumer.SetLogSampleInterval(sampleInterval)
+		attrs = append(attrs, slog.Duration("log_sample_interval", sampleInterval))
+	}
+	app.logger.Info("config reloaded", attrs...)
+	return nil
+}
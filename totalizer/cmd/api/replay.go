@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aelhady03/sumflow/totalizer/internal/replay"
+	"github.com/julienschmidt/httprouter"
+)
+
+// replayHistoryHandler starts or resumes re-publishing the history applied in
+// a time range to a target topic. Progress is polled with replayProgressHandler.
+func (app *application) replayHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	var req replay.Request
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("body must be a JSON object with id, topic, from and to: %v", err))
+		return
+	}
+
+	switch {
+	case req.ID == "":
+		app.badRequestResponse(w, r, errors.New("id must be provided"))
+		return
+	case req.Topic == "":
+		app.badRequestResponse(w, r, errors.New("topic must be provided"))
+		return
+	case req.Topic == app.config.kafkaTopic:
+		app.badRequestResponse(w, r, errors.New("topic must not be the topic the totalizer consumes"))
+		return
+	case !req.From.Before(req.To):
+		app.badRequestResponse(w, r, errors.New("from must be before to"))
+		return
+	}
+
+	progress, err := app.replayer.Start(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, replay.ErrRunning), errors.Is(err, replay.ErrMismatch):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"replay": progress}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replayProgressHandler reports the progress of a history replay.
+func (app *application) replayProgressHandler(w http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	progress, err := app.replayer.Progress(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, replay.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"replay": progress}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -7,15 +7,69 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// routes sets up the router and the routes for the API.
+// untimedRoutes manage their own deadlines and are exempt from the handler timeout.
+var untimedRoutes = map[string]bool{
+	"/v1/history/export":         true,
+	"/v1/admin/consumer/quiesce": true,
+	"/v1/admin/consumer/seek":    true,
+	"/v1/admin/dedup/cleanup":    true,
+	"/v1/admin/dedup/vacuum":     true,
+	"/v1/admin/dlq/replay":       true,
+}
+
+// routeGroup registers routes under a version prefix, e.g. "/v1".
+type routeGroup struct {
+	router *httprouter.Router
+	prefix string
+}
+
+// handle registers handler for method and the group's prefix plus path,
+// naming its trace spans after the full route.
+func (g routeGroup) handle(method, path string, handler http.HandlerFunc) {
+	route := g.prefix + path
+	g.router.Handler(method, route, traceRoute(route, handler))
+}
+
+// routes sets up the router and the routes for the API. Each API version is a
+// route group: a breaking change is added to the next version's group and the
+// existing groups stay as they are. Routes not changed in a newer version
+// are only served under the version that introduced them.
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/results", app.getResultHandler)
-	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
+	app.routesV1(routeGroup{router: router, prefix: "/v1"})
+	app.routesV2(routeGroup{router: router, prefix: "/v2"})
+
+	unversioned := routeGroup{router: router}
+	unversioned.handle(http.MethodGet, "/metrics", promhttp.Handler().ServeHTTP)
+
+	return app.traceRequests(app.recoverPanic(app.requestTimeout(router)))
+}
+
+// routesV1 registers the v1 API. Its responses must not change incompatibly.
+func (app *application) routesV1(v1 routeGroup) {
+	v1.handle(http.MethodGet, "/healthcheck", app.healthcheckHandler)
+	v1.handle(http.MethodGet, "/ready", app.readyHandler)
+	v1.handle(http.MethodGet, "/results", app.getResultHandler)
+	v1.handle(http.MethodGet, "/total/type/:event_type", app.getTypeTotalHandler)
+	v1.handle(http.MethodGet, "/stats", app.statsHandler)
+	v1.handle(http.MethodGet, "/keys", app.listKeysHandler)
+	v1.handle(http.MethodGet, "/history/export", app.exportHistoryHandler)
+	v1.handle(http.MethodGet, "/admin/config", app.requireAdmin(app.configHandler))
+	v1.handle(http.MethodGet, "/admin/diagnostics", app.requireAdmin(app.diagnosticsHandler))
+	v1.handle(http.MethodPost, "/admin/consumer/quiesce", app.requireAdmin(app.quiesceConsumerHandler))
+	v1.handle(http.MethodPost, "/admin/consumer/seek", app.requireAdmin(app.seekConsumerHandler))
+	v1.handle(http.MethodPost, "/admin/dedup/cleanup", app.requireAdmin(app.dedupCleanupHandler))
+	v1.handle(http.MethodPost, "/admin/dedup/vacuum", app.requireAdmin(app.dedupVacuumHandler))
+	v1.handle(http.MethodPost, "/admin/dlq/replay", app.requireAdmin(app.dlqReplayHandler))
+	v1.handle(http.MethodPost, "/admin/history/replay", app.requireAdmin(app.replayHistoryHandler))
+	v1.handle(http.MethodGet, "/admin/history/replay/:id", app.requireAdmin(app.replayProgressHandler))
+	v1.handle(http.MethodGet, "/metrics/summary", app.metricsSummaryHandler)
+}
 
-	return app.recoverPanic(router)
+// routesV2 registers the v2 API: the routes whose responses changed incompatibly.
+func (app *application) routesV2(v2 routeGroup) {
+	v2.handle(http.MethodGet, "/results", app.getResultV2Handler)
 }
@@ -0,0 +1,159 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// AggregateType tags canary events. The consumer recognises it, reports the
+// event to the Prober and skips applying it, so canaries never touch the total or its stats.
+const AggregateType = "canary"
+
+// Publisher writes messages to the consumed topic. *kafka.Writer satisfies it.
+type Publisher interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+type Config struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval: 30 * time.Second,
+		Timeout:  10 * time.Second,
+	}
+}
+
+// Prober periodically publishes a canary event and waits for the consumer to
+// observe it, giving an end-to-end health signal for readiness checks.
+type Prober struct {
+	publisher Publisher
+	config    Config
+	stopCh    chan struct{}
+
+	mu       sync.Mutex
+	waiting  map[uuid.UUID]chan struct{}
+	healthy  bool
+	lastErr  error
+	lastSeen time.Time
+}
+
+func NewProber(publisher Publisher, config Config) *Prober {
+	return &Prober{
+		publisher: publisher,
+		config:    config,
+		stopCh:    make(chan struct{}),
+		waiting:   make(map[uuid.UUID]chan struct{}),
+		lastErr:   errors.New("no canary has completed yet"),
+	}
+}
+
+// Start begins the canary background loop
+func (p *Prober) Start(ctx context.Context) {
+	go p.run(ctx)
+}
+
+// Stop signals the prober to stop
+func (p *Prober) Stop() {
+	close(p.stopCh)
+}
+
+// Observe is called by the consumer when it receives a canary event.
+func (p *Prober) Observe(eventID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ch, ok := p.waiting[eventID]; ok {
+		close(ch)
+		delete(p.waiting, eventID)
+	}
+}
+
+// Status reports whether the most recent canary made it through the
+// pipeline, the error if not, and when one was last seen.
+func (p *Prober) Status() (healthy bool, lastErr error, lastSeen time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.healthy, p.lastErr, p.lastSeen
+}
+
+func (p *Prober) run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	p.probe(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.probe(ctx)
+		}
+	}
+}
+
+func (p *Prober) probe(ctx context.Context) {
+	err := p.send(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.healthy = err == nil
+	p.lastErr = err
+	if err == nil {
+		p.lastSeen = time.Now()
+	} else {
+		log.Printf("canary failed: %v", err)
+	}
+}
+
+// send publishes one canary event and waits for the consumer to observe it.
+func (p *Prober) send(ctx context.Context) error {
+	eventID := uuid.New()
+	value, err := json.Marshal(map[string]any{
+		"event_id":       eventID,
+		"aggregate_type": AggregateType,
+		"aggregate_id":   eventID.String(),
+		"event_type":     "sum.calculated",
+		"payload":        map[string]int{"x": 0, "y": 0, "result": 0},
+		"created_at":     time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(chan struct{})
+	p.mu.Lock()
+	p.waiting[eventID] = seen
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.waiting, eventID)
+		p.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	if err := p.publisher.WriteMessages(ctx, kafka.Message{Key: []byte(eventID.String()), Value: value}); err != nil {
+		return fmt.Errorf("publish canary: %w", err)
+	}
+
+	select {
+	case <-seen:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("canary %s not consumed within %s", eventID, p.config.Timeout)
+	}
+}
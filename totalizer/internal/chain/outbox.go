@@ -0,0 +1,99 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EventTypeTotalUpdated is emitted downstream after each applied sum.
+const EventTypeTotalUpdated = "total.updated"
+
+// TotalUpdatedPayload describes a change to the total.
+type TotalUpdatedPayload struct {
+	SourceEventID uuid.UUID `json:"source_event_id"`
+	Delta         int64     `json:"delta"`
+	Total         int64     `json:"total"`
+}
+
+// Event is a row of the chain outbox.
+type Event struct {
+	ID            uuid.UUID
+	EventType     string
+	SourceEventID uuid.UUID
+	Payload       json.RawMessage
+	CreatedAt     time.Time
+	RetryCount    int
+}
+
+// Outbox stores downstream events in the same transaction as the apply that
+// produced them, so none is lost if the process dies after the commit.
+type Outbox struct {
+	pool *pgxpool.Pool
+}
+
+func NewOutbox(pool *pgxpool.Pool) *Outbox {
+	return &Outbox{pool: pool}
+}
+
+// RecordTotalUpdatedInTx queues a total.updated event within the apply transaction.
+func (o *Outbox) RecordTotalUpdatedInTx(ctx context.Context, tx pgx.Tx, sourceEventID uuid.UUID, delta, total int64) error {
+	payload, err := json.Marshal(TotalUpdatedPayload{SourceEventID: sourceEventID, Delta: delta, Total: total})
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO chain_outbox (id, event_type, source_event_id, payload)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err = tx.Exec(ctx, query, uuid.New(), EventTypeTotalUpdated, sourceEventID, payload)
+	return err
+}
+
+// FetchUnpublished retrieves unpublished events ordered by creation time
+func (o *Outbox) FetchUnpublished(ctx context.Context, limit int) ([]*Event, error) {
+	query := `
+		SELECT id, event_type, source_event_id, payload, created_at, retry_count
+		FROM chain_outbox
+		WHERE published_at IS NULL
+		ORDER BY created_at ASC
+		LIMIT $1
+	`
+	rows, err := o.pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.EventType, &e.SourceEventID, &e.Payload, &e.CreatedAt, &e.RetryCount); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+// MarkPublished marks events as successfully published
+func (o *Outbox) MarkPublished(ctx context.Context, ids []uuid.UUID) error {
+	_, err := o.pool.Exec(ctx, `UPDATE chain_outbox SET published_at = NOW() WHERE id = ANY($1)`, ids)
+	return err
+}
+
+// MarkFailed records a failed publish attempt for events
+func (o *Outbox) MarkFailed(ctx context.Context, ids []uuid.UUID, errMsg string) error {
+	query := `
+		UPDATE chain_outbox
+		SET retry_count = retry_count + 1, last_error = $1
+		WHERE id = ANY($2)
+	`
+	_, err := o.pool.Exec(ctx, query, errMsg, ids)
+	return err
+}
@@ -0,0 +1,113 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Publisher writes messages to the downstream topic. *kafka.Writer satisfies it.
+type Publisher interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+type RelayConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		PollInterval: 500 * time.Millisecond,
+		BatchSize:    100,
+	}
+}
+
+// Relay publishes the chain outbox downstream in creation order. Delivery is
+// at-least-once: a crash between publishing and marking events published
+// re-sends them, so downstream consumers should deduplicate by event_id.
+type Relay struct {
+	outbox    *Outbox
+	publisher Publisher
+	config    RelayConfig
+	stopCh    chan struct{}
+	done      chan struct{}
+}
+
+func NewRelay(outbox *Outbox, publisher Publisher, config RelayConfig) *Relay {
+	return &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		config:    config,
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins publishing in the background
+func (r *Relay) Start(ctx context.Context) {
+	go r.run(ctx)
+}
+
+// Stop signals the relay to stop and waits for the current batch to finish
+func (r *Relay) Stop() {
+	close(r.stopCh)
+	<-r.done
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			if err := r.publishBatch(ctx); err != nil {
+				log.Printf("chain relay error: %v", err)
+			}
+		}
+	}
+}
+
+func (r *Relay) publishBatch(ctx context.Context) error {
+	events, err := r.outbox.FetchUnpublished(ctx, r.config.BatchSize)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+
+	ids := make([]uuid.UUID, len(events))
+	msgs := make([]kafka.Message, len(events))
+	for i, e := range events {
+		value, err := json.Marshal(map[string]any{
+			"event_id":       e.ID,
+			"aggregate_type": "total",
+			"aggregate_id":   "total",
+			"event_type":     e.EventType,
+			"payload":        e.Payload,
+			"created_at":     e.CreatedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		ids[i] = e.ID
+		msgs[i] = kafka.Message{Key: []byte("total"), Value: value}
+	}
+
+	if err := r.publisher.WriteMessages(ctx, msgs...); err != nil {
+		if markErr := r.outbox.MarkFailed(ctx, ids, err.Error()); markErr != nil {
+			log.Printf("failed to mark chain events as failed: %v", markErr)
+		}
+		return err
+	}
+	return r.outbox.MarkPublished(ctx, ids)
+}
@@ -0,0 +1,17 @@
+package data
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Result is the running total together with the most recent change applied to it.
+type Result struct {
+	Total int64 `json:"total"`
+	// Count is the number of events applied since change tracking was added.
+	Count       int64      `json:"count"`
+	LastEventID *uuid.UUID `json:"last_event_id,omitempty"`
+	LastValue   *int64     `json:"last_value,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
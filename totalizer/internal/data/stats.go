@@ -0,0 +1,10 @@
+package data
+
+// Stats summarizes the applied event stream for auditing.
+type Stats struct {
+	Total int64 `json:"total"`
+	Count int64 `json:"count"`
+	// StreamChecksum is the hex SHA-256 hash chain over the applied events,
+	// or nil if checksumming has never been enabled.
+	StreamChecksum *string `json:"stream_checksum"`
+}
@@ -2,5 +2,5 @@ package data
 
 // Sum represents the sum result.
 type Sum struct {
-	Total int `json:"total"`
+	Total int64 `json:"total"`
 }
@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/aelhady03/sumflow/pkg/schemacheck"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -64,9 +66,218 @@ CREATE TABLE IF NOT EXISTS totals (
 );
 
 INSERT INTO totals (id, total) VALUES (1, 0) ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE totals ADD COLUMN IF NOT EXISTS applied_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE totals ADD COLUMN IF NOT EXISTS last_event_id UUID;
+ALTER TABLE totals ADD COLUMN IF NOT EXISTS last_value BIGINT;
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id          BIGSERIAL PRIMARY KEY,
+    topic       TEXT NOT NULL,
+    partition   INTEGER NOT NULL,
+    "offset"    BIGINT NOT NULL,
+    key         BYTEA,
+    value       BYTEA NOT NULL,
+    reason      TEXT NOT NULL,
+    created_at  TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sum_history (
+    id                BIGSERIAL PRIMARY KEY,
+    event_id          UUID NOT NULL,
+    value             BIGINT NOT NULL,
+    event_created_at  TIMESTAMPTZ NOT NULL,
+    applied_at        TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sum_history_applied_at ON sum_history(applied_at);
+
+CREATE TABLE IF NOT EXISTS total_snapshots (
+    id               BIGSERIAL PRIMARY KEY,
+    total            BIGINT NOT NULL,
+    history_id       BIGINT NOT NULL,
+    max_applied_at   TIMESTAMPTZ,
+    taken_at         TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS aggregate_progress (
+    aggregate_id    TEXT PRIMARY KEY,
+    last_sequence   BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS totals_by_type (
+    event_type  TEXT PRIMARY KEY,
+    total       BIGINT NOT NULL DEFAULT 0,
+    updated_at  TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+`
+
+// migrations are applied in order; migration i brings the schema to version i+1.
+// Append new schema changes as new entries rather than editing applied ones.
+var migrations = []string{
+	TotalizerSchema,
+	historyReplaySchema,
+	historyKafkaSourceSchema,
+	chainOutboxSchema,
+	materializedValuesSchema,
+	deadLetterRetentionSchema,
+	streamChecksumSchema,
+	keyedTotalsSchema,
+	historySourceSchema,
+}
+
+// historySourceSchema records what wrote each history row: an applied event,
+// or an adjustment such as a seed that no event stands behind. Rows written
+// before it are taken to be events.
+const historySourceSchema = `
+ALTER TABLE sum_history ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'event';
+`
+
+// keyedTotalsSchema holds each key's contribution to the total until its TTL
+// passes, when the consumer expires keys.
+const keyedTotalsSchema = `
+CREATE TABLE IF NOT EXISTS keyed_totals (
+    key         TEXT PRIMARY KEY,
+    total       BIGINT NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_keyed_totals_expires_at ON keyed_totals(expires_at);
+`
+
+// streamChecksumSchema holds the hash chain over applied events. It stays
+// null until checksumming is enabled.
+const streamChecksumSchema = `
+ALTER TABLE totals ADD COLUMN IF NOT EXISTS stream_checksum BYTEA;
+`
+
+// deadLetterRetentionSchema indexes dead letters by age for retention cleanup.
+const deadLetterRetentionSchema = `
+CREATE INDEX IF NOT EXISTS idx_dead_letters_created_at ON dead_letters(created_at);
+`
+
+// materializedValuesSchema holds the latest value per key when the consumer
+// materializes a compacted topic instead of summing deltas.
+const materializedValuesSchema = `
+CREATE TABLE IF NOT EXISTS materialized_values (
+    key            TEXT PRIMARY KEY,
+    value          BIGINT NOT NULL,
+    last_event_id  UUID NOT NULL,
+    updated_at     TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
 `
 
+// chainOutboxSchema holds total.updated events written in the apply
+// transaction until the chain relay publishes them downstream.
+const chainOutboxSchema = `
+CREATE TABLE IF NOT EXISTS chain_outbox (
+    id               UUID PRIMARY KEY,
+    event_type       TEXT NOT NULL,
+    source_event_id  UUID NOT NULL,
+    payload          JSONB NOT NULL,
+    created_at       TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    published_at     TIMESTAMPTZ,
+    retry_count      INTEGER DEFAULT 0 NOT NULL,
+    last_error       TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_chain_outbox_unpublished ON chain_outbox(created_at)
+    WHERE published_at IS NULL;
+`
+
+// historyKafkaSourceSchema records the Kafka message behind each history row.
+// The columns are nullable: older rows, and rows written with offset recording
+// disabled, have no source.
+const historyKafkaSourceSchema = `
+ALTER TABLE sum_history ADD COLUMN IF NOT EXISTS kafka_partition INTEGER;
+ALTER TABLE sum_history ADD COLUMN IF NOT EXISTS kafka_offset BIGINT;
+`
+
+// historyReplaySchema tracks history replays so an interrupted one can resume.
+const historyReplaySchema = `
+CREATE TABLE IF NOT EXISTS history_replays (
+    id               TEXT PRIMARY KEY,
+    topic            TEXT NOT NULL,
+    from_time        TIMESTAMPTZ NOT NULL,
+    to_time          TIMESTAMPTZ NOT NULL,
+    last_history_id  BIGINT NOT NULL DEFAULT 0,
+    replayed         BIGINT NOT NULL DEFAULT 0,
+    completed_at     TIMESTAMPTZ,
+    updated_at       TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+`
+
+// SchemaVersion is the schema version this binary expects.
+var SchemaVersion = len(migrations)
+
+// migrationLockID is the advisory lock key that serializes migrations across replicas.
+const migrationLockID = 7461205
+
+// RunMigrations applies every migration newer than the database's recorded
+// schema version, recording each one in schema_migrations.
 func RunMigrations(ctx context.Context, pool *pgxpool.Pool) error {
-	_, err := pool.Exec(ctx, TotalizerSchema)
-	return err
-}
\ No newline at end of file
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version     INTEGER PRIMARY KEY,
+			applied_at  TIMESTAMPTZ DEFAULT NOW() NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	var applied int
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&applied); err != nil {
+		return err
+	}
+
+	for version := applied + 1; version <= len(migrations); version++ {
+		if _, err := tx.Exec(ctx, migrations[version-1]); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// AppliedSchemaVersion returns the latest migration recorded in the database.
+func AppliedSchemaVersion(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	var version int
+	err := pool.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// expectedColumns are the core tables and columns the totalizer reads and writes.
+var expectedColumns = map[string][]string{
+	"processed_events": {"event_id", "aggregate_type", "event_type", "processed_at"},
+	"totals":           {"id", "total", "updated_at", "applied_count", "last_event_id", "last_value", "stream_checksum"},
+	"totals_by_type":   {"event_type", "total", "updated_at"},
+	"dead_letters":     {"id", "topic", "partition", "offset", "key", "value", "reason", "created_at"},
+	"sum_history": {
+		"id", "event_id", "value", "event_created_at", "applied_at",
+		"kafka_partition", "kafka_offset", "source",
+	},
+	"total_snapshots":     {"id", "total", "history_id", "max_applied_at", "taken_at"},
+	"materialized_values": {"key", "value", "last_event_id", "updated_at"},
+	"keyed_totals":        {"key", "total", "expires_at", "updated_at"},
+}
+
+// CheckSchema verifies that the database has every table and column the
+// totalizer expects. Run it after migrations.
+func CheckSchema(ctx context.Context, pool *pgxpool.Pool) error {
+	return schemacheck.Check(ctx, pool, expectedColumns)
+}
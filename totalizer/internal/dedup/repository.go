@@ -22,6 +22,8 @@ func NewRepository(pool *pgxpool.Pool) *Repository {
 // CheckAndMarkInTx checks if an event has been processed and marks it if not.
 // Must be called within a transaction to ensure atomicity.
 // Returns ErrEventAlreadyProcessed if the event was already processed.
+// The insert runs as a prepared statement: pgx's default statement cache
+// prepares it once per connection (see BenchmarkHotQueries in storage).
 func (r *Repository) CheckAndMarkInTx(ctx context.Context, tx pgx.Tx, eventID uuid.UUID, aggregateType, eventType string) error {
 	// Try to insert the event. If it already exists (duplicate key), the event was already processed.
 	query := `
@@ -0,0 +1,47 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Store is a fast, non-transactional dedup check consulted before the
+// transactional CheckAndMarkInTx. It is an optimisation only: Postgres
+// remains the source of truth, so a Store may forget events (e.g. on TTL
+// expiry or eviction) without affecting correctness.
+type Store interface {
+	// Seen reports whether the event is known to have been processed.
+	Seen(ctx context.Context, eventID uuid.UUID) (bool, error)
+	// Mark records the event as processed. It must only be called after the
+	// event's database transaction has committed.
+	Mark(ctx context.Context, eventID uuid.UUID) error
+}
+
+const redisKeyPrefix = "totalizer:processed:"
+
+// RedisStore implements Store using SETNX keys that expire after a TTL.
+type RedisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRedisStore(client *redis.Client, ttl time.Duration) *RedisStore {
+	return &RedisStore{client: client, ttl: ttl}
+}
+
+// Seen checks whether a processed marker exists for the event
+func (s *RedisStore) Seen(ctx context.Context, eventID uuid.UUID) (bool, error) {
+	n, err := s.client.Exists(ctx, redisKeyPrefix+eventID.String()).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Mark sets the processed marker for the event if it isn't already set
+func (s *RedisStore) Mark(ctx context.Context, eventID uuid.UUID) error {
+	return s.client.SetNX(ctx, redisKeyPrefix+eventID.String(), 1, s.ttl).Err()
+}
@@ -0,0 +1,76 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aelhady03/sumflow/pkg/pgtest"
+	"github.com/aelhady03/sumflow/totalizer/internal/database"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisClient returns a client for the server in TEST_REDIS_URL, skipping
+// if it is unset.
+func redisClient(tb testing.TB) *redis.Client {
+	tb.Helper()
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		tb.Skip("TEST_REDIS_URL not set")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		tb.Fatalf("parse TEST_REDIS_URL: %v", err)
+	}
+	client := redis.NewClient(opts)
+	tb.Cleanup(func() { client.Close() })
+	return client
+}
+
+// BenchmarkDuplicateCheck compares the cost of recognising a redelivered
+// event with the Postgres check-and-mark against the Redis store consulted
+// before it. New events pay for both; redeliveries only pay for Redis.
+func BenchmarkDuplicateCheck(b *testing.B) {
+	ctx := context.Background()
+	eventID := uuid.New()
+
+	b.Run("postgres", func(b *testing.B) {
+		pool := pgtest.Pool(b)
+		if err := database.RunMigrations(ctx, pool); err != nil {
+			b.Fatalf("migrate: %v", err)
+		}
+		repo := NewRepository(pool)
+		if _, err := pool.Exec(ctx, `INSERT INTO processed_events (event_id, aggregate_type, event_type) VALUES ($1, 'sum', 'sum.calculated')`, eventID); err != nil {
+			b.Fatalf("mark processed: %v", err)
+		}
+
+		for b.Loop() {
+			tx, err := pool.Begin(ctx)
+			if err != nil {
+				b.Fatalf("begin: %v", err)
+			}
+			err = repo.CheckAndMarkInTx(ctx, tx, eventID, "sum", "sum.calculated")
+			tx.Rollback(ctx)
+			if !errors.Is(err, ErrEventAlreadyProcessed) {
+				b.Fatalf("check = %v, want %v", err, ErrEventAlreadyProcessed)
+			}
+		}
+	})
+
+	b.Run("redis", func(b *testing.B) {
+		store := NewRedisStore(redisClient(b), time.Minute)
+		if err := store.Mark(ctx, eventID); err != nil {
+			b.Fatalf("mark: %v", err)
+		}
+
+		for b.Loop() {
+			seen, err := store.Seen(ctx, eventID)
+			if err != nil || !seen {
+				b.Fatalf("seen = %v, %v, want true", seen, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package dlq
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Cleaner periodically deletes dead letters older than its retention, so
+// the table doesn't grow without bound.
+type Cleaner struct {
+	repo      *Repository
+	interval  time.Duration
+	retention time.Duration
+	stopCh    chan struct{}
+}
+
+func NewCleaner(repo *Repository, interval, retention time.Duration) *Cleaner {
+	return &Cleaner{
+		repo:      repo,
+		interval:  interval,
+		retention: retention,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Start begins the cleanup background loop
+func (c *Cleaner) Start(ctx context.Context) {
+	go c.run(ctx)
+}
+
+// Stop signals the cleaner to stop
+func (c *Cleaner) Stop() {
+	close(c.stopCh)
+}
+
+func (c *Cleaner) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			deleted, err := c.repo.DeleteOlderThan(ctx, c.retention)
+			if err != nil {
+				log.Printf("dead letter cleanup error: %v", err)
+			} else if deleted > 0 {
+				log.Printf("dead letter cleanup: deleted %d entries", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Publisher writes messages to Kafka. A *kafka.Writer without a Topic satisfies it.
+type Publisher interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// ReplayFailure is an entry that could not be replayed.
+type ReplayFailure struct {
+	ID    int64  `json:"id"`
+	Error string `json:"error"`
+}
+
+// ReplayResult reports the outcome of a replay. Replayed entries have been
+// deleted; failed ones stay in the table.
+type ReplayResult struct {
+	Replayed []int64         `json:"replayed"`
+	Failed   []ReplayFailure `json:"failed"`
+	NotFound []int64         `json:"not_found"`
+}
+
+// Replay re-publishes the entries with the given IDs to the topics they were
+// read from, so the consumer processes them again, and deletes each one once
+// published. The events keep their IDs, so dedup skips any that were applied
+// after all. A message that fails again is dead-lettered again as a new entry.
+func (r *Repository) Replay(ctx context.Context, publisher Publisher, ids []int64) (*ReplayResult, error) {
+	entries, err := r.Get(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write everything in one call; a synchronous writer waits out its batch
+	// timeout on every call
+	msgs := make([]kafka.Message, len(entries))
+	for i, e := range entries {
+		msgs[i] = kafka.Message{Topic: e.Topic, Key: e.Key, Value: e.Value}
+	}
+	writeErrs := make([]error, len(entries))
+	if err := publisher.WriteMessages(ctx, msgs...); err != nil {
+		var perMessage kafka.WriteErrors
+		if errors.As(err, &perMessage) && len(perMessage) == len(entries) {
+			copy(writeErrs, perMessage)
+		} else {
+			for i := range writeErrs {
+				writeErrs[i] = err
+			}
+		}
+	}
+
+	result := &ReplayResult{Replayed: []int64{}, Failed: []ReplayFailure{}, NotFound: []int64{}}
+	found := make(map[int64]bool, len(entries))
+	for i, e := range entries {
+		found[e.ID] = true
+
+		if writeErrs[i] != nil {
+			result.Failed = append(result.Failed, ReplayFailure{ID: e.ID, Error: writeErrs[i].Error()})
+			continue
+		}
+		// The message is out again; failing to delete only leaves a stale entry
+		if err := r.Delete(ctx, e.ID); err != nil {
+			result.Failed = append(result.Failed, ReplayFailure{ID: e.ID, Error: "replayed but not deleted: " + err.Error()})
+			continue
+		}
+		result.Replayed = append(result.Replayed, e.ID)
+	}
+
+	for _, id := range ids {
+		if !found[id] {
+			result.NotFound = append(result.NotFound, id)
+		}
+	}
+	return result, nil
+}
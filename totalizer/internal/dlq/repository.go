@@ -0,0 +1,82 @@
+package dlq
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Entry is a Kafka message that could not be processed and was parked in the dead-letter table.
+type Entry struct {
+	ID        int64
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Reason    string
+	CreatedAt time.Time
+}
+
+type Repository struct {
+	pool *pgxpool.Pool
+}
+
+func NewRepository(pool *pgxpool.Pool) *Repository {
+	return &Repository{pool: pool}
+}
+
+// Insert stores a dead-lettered message so the original can be committed and skipped
+func (r *Repository) Insert(ctx context.Context, entry Entry) error {
+	query := `
+		INSERT INTO dead_letters (topic, partition, "offset", key, value, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := r.pool.Exec(ctx, query,
+		entry.Topic,
+		entry.Partition,
+		entry.Offset,
+		entry.Key,
+		entry.Value,
+		entry.Reason,
+	)
+	return err
+}
+
+// Get returns the entries with the given IDs in ID order. IDs with no entry are left out.
+func (r *Repository) Get(ctx context.Context, ids []int64) ([]Entry, error) {
+	query := `
+		SELECT id, topic, partition, "offset", key, value, reason, created_at
+		FROM dead_letters
+		WHERE id = ANY($1)
+		ORDER BY id
+	`
+	rows, err := r.pool.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Entry, error) {
+		var e Entry
+		err := row.Scan(&e.ID, &e.Topic, &e.Partition, &e.Offset, &e.Key, &e.Value, &e.Reason, &e.CreatedAt)
+		return e, err
+	})
+}
+
+// Delete removes an entry, e.g. once it has been replayed.
+func (r *Repository) Delete(ctx context.Context, id int64) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM dead_letters WHERE id = $1`, id)
+	return err
+}
+
+// DeleteOlderThan removes entries dead-lettered more than retention ago and
+// returns how many were deleted.
+func (r *Repository) DeleteOlderThan(ctx context.Context, retention time.Duration) (int64, error) {
+	cutoff := time.Now().UTC().Add(-retention)
+	result, err := r.pool.Exec(ctx, `DELETE FROM dead_letters WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
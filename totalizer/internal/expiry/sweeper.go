@@ -0,0 +1,75 @@
+package expiry
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aelhady03/sumflow/totalizer/internal/storage"
+)
+
+// Observer is told when a sweep has changed the total, e.g. to invalidate a cache.
+type Observer interface {
+	TotalChanged()
+}
+
+// Sweeper periodically removes keyed totals whose TTL has passed and
+// subtracts their contribution from the total, so the total only covers
+// keys that have seen an event within the TTL.
+type Sweeper struct {
+	storage  *storage.PostgresStorage
+	interval time.Duration
+	observer Observer
+	stopCh   chan struct{}
+}
+
+// NewSweeper returns a sweeper that runs every interval. observer may be nil.
+func NewSweeper(storage *storage.PostgresStorage, interval time.Duration, observer Observer) *Sweeper {
+	return &Sweeper{
+		storage:  storage,
+		interval: interval,
+		observer: observer,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins the sweep background loop
+func (s *Sweeper) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+// Stop signals the sweeper to stop
+func (s *Sweeper) Stop() {
+	close(s.stopCh)
+}
+
+func (s *Sweeper) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		}
+	}
+}
+
+func (s *Sweeper) sweep(ctx context.Context) {
+	expired, subtracted, err := s.storage.ExpireKeys(ctx)
+	if err != nil {
+		log.Printf("key expiry error: %v", err)
+		return
+	}
+	if expired == 0 {
+		return
+	}
+	log.Printf("key expiry: expired %d keys, subtracted %d from the total", expired, subtracted)
+	if s.observer != nil {
+		s.observer.TotalChanged()
+	}
+}
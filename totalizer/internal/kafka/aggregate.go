@@ -0,0 +1,68 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+)
+
+// aggregateLocks serializes processing per aggregate. Each lock is a
+// one-slot channel so waiting for it can be cancelled, and it is dropped from
+// the map once nobody holds or waits for it.
+type aggregateLocks struct {
+	mu    sync.Mutex
+	locks map[string]*aggregateLock
+}
+
+type aggregateLock struct {
+	slot chan struct{}
+	refs int
+}
+
+func newAggregateLocks() *aggregateLocks {
+	return &aggregateLocks{locks: make(map[string]*aggregateLock)}
+}
+
+// lock blocks until key's lock is held or ctx is done. contended reports
+// whether it had to wait. On success the caller must call unlock(key).
+func (a *aggregateLocks) lock(ctx context.Context, key string) (contended bool, err error) {
+	a.mu.Lock()
+	l, ok := a.locks[key]
+	if !ok {
+		l = &aggregateLock{slot: make(chan struct{}, 1)}
+		a.locks[key] = l
+	}
+	l.refs++
+	a.mu.Unlock()
+
+	select {
+	case l.slot <- struct{}{}:
+		return false, nil
+	default:
+	}
+
+	select {
+	case l.slot <- struct{}{}:
+		return true, nil
+	case <-ctx.Done():
+		a.release(key, l)
+		return true, ctx.Err()
+	}
+}
+
+func (a *aggregateLocks) unlock(key string) {
+	a.mu.Lock()
+	l := a.locks[key]
+	a.mu.Unlock()
+
+	<-l.slot
+	a.release(key, l)
+}
+
+func (a *aggregateLocks) release(key string, l *aggregateLock) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(a.locks, key)
+	}
+}
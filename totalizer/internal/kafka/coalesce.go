@@ -0,0 +1,280 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/aelhady03/sumflow/totalizer/internal/dedup"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// defaultCoalesceMaxBatch bounds a coalesced batch when CoalesceMaxBatch is unset.
+const defaultCoalesceMaxBatch = 500
+
+// pendingTotal collects an event's contribution to the total while it is
+// applied as part of a coalesced batch, instead of the handler updating the
+// totals row itself.
+type pendingTotal struct {
+	value int64
+	added bool
+}
+
+// coalescedItem is a message waiting for its batch. Messages that need no
+// apply (skipped, duplicates the dedup store caught, dead-lettered) have no
+// event and only wait for their offset commit, so offsets are still committed
+// in order: committing a later offset would implicitly commit queued messages
+// that haven't been applied yet.
+type coalescedItem struct {
+	d         *delivery
+	event     *Event
+	eventType string
+}
+
+// coalescer accumulates applies over CoalesceWindow and runs them in one
+// transaction that moves the totals row once, so concurrent partitions don't
+// serialize on its row lock. Offsets are only committed after the batch
+// transaction commits.
+type coalescer struct {
+	c        *Consumer
+	window   time.Duration
+	maxBatch int
+
+	// flushMu serializes flushes so batches apply and commit in the order
+	// their messages were queued.
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending []coalescedItem
+	timer   *time.Timer
+}
+
+func newCoalescer(c *Consumer, window time.Duration, maxBatch int) *coalescer {
+	if maxBatch <= 0 {
+		maxBatch = defaultCoalesceMaxBatch
+	}
+	return &coalescer{c: c, window: window, maxBatch: maxBatch}
+}
+
+// add queues an item. The first item of a batch starts the window; a full
+// batch is flushed right away by the caller, which holds its partition back
+// until the batch has been applied.
+func (b *coalescer) add(ctx context.Context, item coalescedItem) {
+	b.mu.Lock()
+	b.pending = append(b.pending, item)
+	full := len(b.pending) >= b.maxBatch
+	if len(b.pending) == 1 && !full {
+		b.timer = time.AfterFunc(b.window, func() { b.flush(b.c.runCtx) })
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.flush(ctx)
+	}
+}
+
+// flush applies everything queued so far and commits the offsets.
+func (b *coalescer) flush(ctx context.Context) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	c := b.c
+	c.metrics.KafkaCoalescedBatchSize.WithLabelValues(c.topic).Observe(float64(len(batch)))
+
+	outcomes, err := b.apply(ctx, batch)
+	if err != nil {
+		// Nothing from the batch was committed; apply its messages one at a
+		// time, which retries and dead-letters them like any other message
+		c.processErrLog.Printf("error applying coalesced batch of %d messages, applying them individually: %v", len(batch), err)
+		for _, item := range batch {
+			d := item.d
+			if item.event != nil {
+				var ok bool
+				if d, ok = c.processOrHold(ctx, item.d.msg); !ok {
+					// Stopping; leave it and the rest of the batch uncommitted
+					return
+				}
+			}
+			if err := c.commitOffset(ctx, d); err != nil {
+				c.processErrLog.Printf("error committing message on partition %d: %v", d.msg.Partition, err)
+			}
+		}
+		return
+	}
+
+	applied := false
+	for i, item := range batch {
+		if item.event != nil {
+			switch err := outcomes[i]; {
+			case err == nil:
+				applied = true
+				b.applied(ctx, item)
+			case errors.Is(err, dedup.ErrEventAlreadyProcessed):
+				c.logEventSkipped(ctx, item.event)
+				c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, item.eventType, "duplicate").Inc()
+			default:
+				if errors.Is(err, errMalformedPayload) {
+					c.metrics.KafkaMessagesMalformed.WithLabelValues(c.topic, "payload").Inc()
+				}
+				c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, item.eventType, "invalid").Inc()
+				if err := c.deadLetter(ctx, item.d.msg, err.Error()); err != nil {
+					c.processErrLog.Printf("error processing message on partition %d: %v", item.d.msg.Partition, err)
+					// Reprocess it on its own, which dead-letters it or holds its partition
+					d, ok := c.processOrHold(ctx, item.d.msg)
+					if !ok {
+						return
+					}
+					item.d = d
+				}
+			}
+		}
+		if err := c.commitOffset(ctx, item.d); err != nil {
+			c.processErrLog.Printf("error committing message on partition %d: %v", item.d.msg.Partition, err)
+		}
+	}
+
+	if !applied {
+		return
+	}
+	if c.config.TotalObserver != nil {
+		c.config.TotalObserver.TotalChanged()
+	}
+	if c.config.Secondary != nil {
+		if err := c.config.Secondary.Sync(ctx); err != nil {
+			c.processErrLog.Printf("failed to sync total to secondary storage: %v", err)
+		}
+	}
+}
+
+// apply runs every queued event's middlewares and handler in one
+// transaction that holds the totals row lock throughout, each under its own savepoint so a duplicate or invalid event
+// leaves no trace, then adds their contributions to the totals row in a
+// single update. It returns each item's outcome: nil, ErrEventAlreadyProcessed
+// or an ErrInvalidPayload. Any other error rolls back the whole batch.
+func (b *coalescer) apply(ctx context.Context, batch []coalescedItem) ([]error, error) {
+	c := b.c
+	if c.config.ProcessTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.ProcessTimeout)
+		defer cancel()
+	}
+
+	tx, err := c.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: c.config.IsoLevel})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Take the totals row lock first, as a lone event's AddToTotalInTx does,
+	// so the handlers' history rows are written under it
+	if err := c.storage.LockTotalInTx(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	var (
+		sum, lastValue int64
+		count          int
+		lastEventID    uuid.UUID
+	)
+	outcomes := make([]error, len(batch))
+	for i, item := range batch {
+		if item.event == nil {
+			continue
+		}
+
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pending := &pendingTotal{}
+		item.event.pendingTotal = pending
+		err = c.handler(ctx, savepoint, item.event)
+		if errors.Is(err, dedup.ErrEventAlreadyProcessed) || errors.Is(err, ErrInvalidPayload) {
+			outcomes[i] = err
+			if err := savepoint.Rollback(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := savepoint.Commit(ctx); err != nil {
+			return nil, err
+		}
+
+		if pending.added {
+			sum += pending.value
+			count++
+			lastEventID, lastValue = item.event.EventID, pending.value
+		}
+	}
+
+	if count > 0 {
+		if err := c.storage.AddBatchToTotalInTx(ctx, tx, lastEventID, lastValue, sum, count); err != nil {
+			return nil, err
+		}
+	}
+	return outcomes, tx.Commit(ctx)
+}
+
+// applied finishes an event whose batch has committed, as processMessage
+// does for an event applied on its own.
+func (b *coalescer) applied(ctx context.Context, item coalescedItem) {
+	c := b.c
+	c.logger.LogAttrs(ctx, slog.LevelInfo, "event applied",
+		slog.String(telemetry.EventIDKey, item.event.EventID.String()),
+		slog.String("event_type", item.event.EventType),
+		slog.Int("partition", item.d.msg.Partition),
+		slog.Int64("offset", item.d.msg.Offset),
+		slog.Bool("coalesced", true),
+	)
+
+	if c.config.DedupStore != nil {
+		if err := c.config.DedupStore.Mark(ctx, item.event.EventID); err != nil {
+			c.dedupErrLog.Printf("failed to mark event %s in dedup store: %v", item.event.EventID, err)
+		}
+	}
+
+	c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, item.eventType, "success").Inc()
+}
+
+// coalesce prepares msg like processMessage and queues it for the next
+// batch. Messages processMessage fails on are flushed behind the queued ones
+// and then processed on their own, with the usual retries. It returns false
+// if msg was left uncommitted because the consumer is stopping.
+func (c *Consumer) coalesce(ctx context.Context, msg kafka.Message) bool {
+	d := newDelivery(msg)
+	d.coalesce = true
+	if err := c.processMessage(ctx, d); err != nil {
+		c.coalescer.flush(ctx)
+		d, ok := c.processOrHold(ctx, msg)
+		if !ok {
+			return false
+		}
+		c.coalescer.add(ctx, coalescedItem{d: d})
+		return true
+	}
+	if !d.queued {
+		c.coalescer.add(ctx, coalescedItem{d: d})
+	}
+	return true
+}
@@ -4,14 +4,25 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aelhady03/sumflow/pkg/logsample"
 	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/aelhady03/sumflow/totalizer/internal/canary"
 	"github.com/aelhady03/sumflow/totalizer/internal/dedup"
+	"github.com/aelhady03/sumflow/totalizer/internal/dlq"
+	"github.com/aelhady03/sumflow/totalizer/internal/ordering"
 	"github.com/aelhady03/sumflow/totalizer/internal/storage"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	kafka "github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
@@ -21,6 +32,9 @@ import (
 
 var tracer = otel.Tracer("kafka-consumer")
 
+// commitInterval is how often the reader flushes committed offsets to Kafka.
+const commitInterval = time.Second
+
 // kafkaHeaderCarrier implements propagation.TextMapCarrier for Kafka headers
 type kafkaHeaderCarrier []kafka.Header
 
@@ -51,96 +65,836 @@ type Event struct {
 	AggregateType string          `json:"aggregate_type"`
 	AggregateID   string          `json:"aggregate_id"`
 	EventType     string          `json:"event_type"`
-	Payload       json.RawMessage `json:"payload"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	ContentType   string          `json:"content_type,omitempty"`
+	Data          []byte          `json:"data,omitempty"`
 	CreatedAt     time.Time       `json:"created_at"`
 	PublishedAt   *time.Time      `json:"published_at,omitempty"`
+	Sequence      int64           `json:"sequence,omitempty"`
+
+	// Partition and Offset locate the Kafka message the event was read from.
+	Partition int   `json:"-"`
+	Offset    int64 `json:"-"`
+	// Key is the Kafka message key.
+	Key string `json:"-"`
+
+	// pendingTotal is set while the event is applied in a coalesced batch.
+	pendingTotal *pendingTotal
+}
+
+// totalKey is the key an event's result is tracked under: the Kafka message
+// key, or the aggregate ID for messages without one.
+func (e *Event) totalKey() string {
+	if e.Key != "" {
+		return e.Key
+	}
+	return e.AggregateID
+}
+
+// contentTypeJSON is the content type of events whose payload is in Payload;
+// events with any other content type carry raw bytes in Data.
+const contentTypeJSON = "application/json"
+
+// IsJSON reports whether the event's payload is JSON.
+func (e *Event) IsJSON() bool {
+	return e.ContentType == "" || e.ContentType == contentTypeJSON
+}
+
+// eventTypeLabel returns the metric label for an event type. Only the event
+// types the consumer handles are used as labels; event types come from
+// message content, so anything else is reported as "other" to keep label
+// cardinality bounded.
+func (c *Consumer) eventTypeLabel(eventType string) string {
+	if _, ok := c.handlers[eventType]; ok {
+		return eventType
+	}
+	return "other"
 }
 
-// SumCalculatedPayload represents the payload for sum.calculated events
+// SumCalculatedPayload represents the payload for sum.calculated events. A
+// sum of two numbers carries them as X and Y, any other sum as Operands.
 type SumCalculatedPayload struct {
-	X      int `json:"x"`
-	Y      int `json:"y"`
-	Result int `json:"result"`
+	X        int64   `json:"x"`
+	Y        int64   `json:"y"`
+	Operands []int64 `json:"operands,omitempty"`
+	Result   int64   `json:"result"`
 }
 
+// strictSumCalculatedPayload decodes a sum.calculated payload so absent fields
+// can be told apart from zeros.
+type strictSumCalculatedPayload struct {
+	X        *int64  `json:"x"`
+	Y        *int64  `json:"y"`
+	Operands []int64 `json:"operands"`
+	Result   *int64  `json:"result"`
+}
+
+// ErrInvalidPayload marks an event whose payload can never be applied. Such
+// events are moved to the dead-letter table instead of being retried.
+var ErrInvalidPayload = errors.New("invalid event payload")
+
+// errMalformedPayload marks an invalid payload that is not even valid JSON for
+// its event type, as opposed to one that decoded but failed validation.
+var errMalformedPayload = fmt.Errorf("%w: malformed", ErrInvalidPayload)
+
 type ConsumerConfig struct {
 	Brokers []string
 	Topic   string
 	GroupID string
+	// ProcessTimeout bounds how long a single message may take to process.
+	// Zero disables the timeout.
+	ProcessTimeout time.Duration
+	// MaxProcessTimeouts is the number of consecutive timeouts after which a
+	// message is moved to the dead-letter table and committed. A timeout is
+	// usually transient, so zero, the default, retries it forever.
+	MaxProcessTimeouts int
+	// MaxInFlight bounds how many messages are processed concurrently across
+	// all partitions. Zero means one in flight per partition.
+	MaxInFlight int
+	// FairScheduling hands MaxInFlight slots to partitions round-robin, in
+	// the order they started waiting, and lets each partition process at
+	// most FairQuantum queued messages per turn, so a hot partition can't
+	// starve lagging ones. It only applies with MaxInFlight set.
+	FairScheduling bool
+	FairQuantum    int
+	// PartitionQueueSize is the number of fetched messages buffered per partition.
+	PartitionQueueSize int
+	// PrefetchSize is the number of fetched messages buffered ahead of
+	// dispatch, so fetching continues while a partition queue is full.
+	// Zero hands each message over as it is fetched.
+	PrefetchSize int
+	// FetchQueueCapacity is the reader's internal message queue size. Zero uses
+	// the kafka-go default of 100.
+	FetchQueueCapacity int
+	// Fetch tunes the reader's fetch requests. Zero fields use the defaults
+	// below; TopicFetch overrides them for individual topics.
+	Fetch FetchSettings
+	// TopicFetch holds per-topic fetch settings, keyed by topic. Zero fields
+	// fall back to Fetch.
+	TopicFetch map[string]FetchSettings
+	// DedupStore, if set, is checked before opening a transaction so known
+	// duplicates skip the database entirely. Postgres dedup still runs for
+	// every message that gets past it.
+	DedupStore dedup.Store
+	// Ordering, if set, tracks per-aggregate sequence numbers and reports
+	// sequenced events that are applied out of order. Events are still applied.
+	Ordering *ordering.Repository
+	// Middlewares run inside the transaction after the built-in dedup and
+	// ordering middlewares, in the order given, before the event handler.
+	Middlewares []MessageMiddleware
+	// PayloadTypes registers handlers for event types beyond sum.calculated,
+	// keyed by event type. Events of unregistered types are dead-lettered.
+	PayloadTypes map[string]PayloadType
+	// Canary, if set, is notified of canary events, which are otherwise
+	// skipped without touching the database or the consumed-message metrics.
+	Canary CanaryObserver
+	// StrictPayloads requires every payload field to be present. Incomplete
+	// payloads are dead-lettered instead of having missing fields read as zero.
+	StrictPayloads bool
+	// LogSampleInterval limits repetitive error logs (fetch, processing, dedup
+	// store failures) to one per interval with a suppressed count. Zero logs every error.
+	LogSampleInterval time.Duration
+	// MaxRetries is how many times a message that fails processing is retried
+	// in-process, waiting RetryBackoff (doubling each time) between attempts.
+	// A message that still fails holds up its partition and keeps being
+	// retried with a longer backoff, since its failure (a lost connection, a
+	// serialization failure, a timeout) may well be transient. Only events
+	// that can never be applied, wrapping ErrInvalidPayload, are dead-lettered.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// MaxDeadlockRetries is how many times a message whose transaction was
+	// chosen as a deadlock victim is retried, on top of MaxRetries. Zero
+	// treats deadlocks like any other failure.
+	MaxDeadlockRetries int
+	// IsoLevel is the isolation level of the transaction that applies each
+	// message. The default, read committed, is sufficient: the dedup insert is
+	// guarded by its primary key and the totals update is a single row-locking
+	// UPDATE, so concurrent applies to the same total serialize on the row lock
+	// and each re-reads the committed value. Stricter levels make concurrent
+	// applies fail with serialization errors, which are retried like any other
+	// processing error (see MaxRetries).
+	IsoLevel pgx.TxIsoLevel
+	// Delivery selects when offsets are committed. Defaults to AtLeastOnce.
+	Delivery DeliverySemantics
+	// ResultTransform converts each sum.calculated result before it is added
+	// to the totals and history, e.g. to scale units. Nil leaves results
+	// unchanged. Changing it only affects events applied afterwards; totals
+	// already accumulated are not rewritten.
+	ResultTransform func(int64) int64
+	// Logger receives a debug-level record for every handled event with its
+	// ID, type and payload. Defaults to slog.Default().
+	Logger *slog.Logger
+	// RedactPayloads leaves payloads out of the debug records, for events
+	// whose contents must not reach the logs.
+	RedactPayloads bool
+	// TotalObserver, if set, is notified after each applied event commits.
+	TotalObserver TotalObserver
+	// RecordOffsets stores the Kafka partition and offset of each applied
+	// message in its history row.
+	RecordOffsets bool
+	// StreamChecksum chains a checksum over every applied event into the
+	// totals row (see storage.ChainChecksumInTx), so gaps or tampering show
+	// up as a mismatch. Two totalizers only agree if they apply the same
+	// events in the same order, i.e. on a single-partition topic.
+	StreamChecksum bool
+	// AggregateTypes, if set, lists the aggregate types the consumer accepts.
+	// Events of any other aggregate type are dead-lettered before they are
+	// marked processed. Canary events are always accepted.
+	AggregateTypes []string
+	// Chain, if set, records a total.updated event for every applied sum in
+	// the same transaction, for a relay to publish downstream.
+	Chain ChainWriter
+	// Secondary, if set, copies the total to secondary storage backends after
+	// each applied event commits. Failures there never fail the apply.
+	Secondary *storage.MultiStorage
+	// Mode selects how results are applied to the totals. Defaults to Additive.
+	Mode TotalMode
+	// MaxEventAge, if set, skips and commits events whose created_at is older
+	// than this, so a stale topic or misconfigured backfill can't re-apply
+	// ancient events. Dedup only remembers events for its retention (the
+	// processed_events cleanup and the redis TTL), and older events are not
+	// recognised as duplicates, so keep MaxEventAge below that retention.
+	// Leave it zero for deliberate backfills.
+	MaxEventAge time.Duration
+	// SerializeAggregates processes at most one event per aggregate at a
+	// time, from the transaction's start to its commit, when messages are
+	// processed concurrently. Additive totals don't need it; order-sensitive
+	// handlers do.
+	SerializeAggregates bool
+	// KeyTTL, if set, also tracks each key's contribution to the total (keys
+	// as in MaterializeLatest) and pushes the key's expiry back to KeyTTL
+	// after every event, for an expiry.Sweeper to subtract keys that have
+	// gone quiet. The total then covers only recently active keys; the
+	// per-type totals are not windowed. Additive mode only.
+	KeyTTL time.Duration
+	// CoalesceWindow, if set, applies sum.calculated events in batches: the
+	// events that arrive within the window are applied in one transaction
+	// that records each event's dedup mark and history row but moves the
+	// totals row with a single update, so concurrent partitions don't queue
+	// on its row lock. Each event is delayed by up to the window before it is
+	// applied and visible in the total. Offsets are only committed once the
+	// batch has committed, in fetch order, so a crash loses nothing: the
+	// uncommitted batch is redelivered and applied again. A batch that fails
+	// is rolled back and its events applied one at a time. Requires
+	// at-least-once delivery and is incompatible with Chain, StreamChecksum
+	// and SerializeAggregates, which need each event's total as it is applied,
+	// and with KeyTTL.
+	CoalesceWindow time.Duration
+	// CoalesceMaxBatch flushes a batch early once it holds this many
+	// messages. Defaults to 500.
+	CoalesceMaxBatch int
+}
+
+// TotalMode selects how the consumer turns sum.calculated results into totals.
+type TotalMode int
+
+const (
+	// Additive adds every result to the totals: each event is a delta.
+	Additive TotalMode = iota
+	// MaterializeLatest treats each result as the latest value for its message
+	// key, as on a compacted topic, and stores it in materialized_values. The
+	// totals move by the change from the key's previous value, so they equal
+	// the sum of the latest values, and history records that change.
+	// Messages without a key are keyed by their aggregate ID. Tombstones
+	// (messages with no value) are skipped as malformed, so a deleted key
+	// keeps its last value.
+	MaterializeLatest
+)
+
+// ParseTotalMode parses a total mode name: additive or materialize-latest.
+func ParseTotalMode(name string) (TotalMode, error) {
+	switch name {
+	case "", "additive":
+		return Additive, nil
+	case "materialize-latest":
+		return MaterializeLatest, nil
+	}
+	return Additive, fmt.Errorf("unknown total mode %q", name)
+}
+
+// DeliverySemantics selects whether the consumer commits a message's offset
+// after or before applying it.
+type DeliverySemantics int
+
+const (
+	// AtLeastOnce commits an offset only after the message's transaction has
+	// committed. A crash can redeliver a message, which deduplication absorbs.
+	AtLeastOnce DeliverySemantics = iota
+	// AtMostOnce commits an offset before the message is applied, so a message
+	// is never reprocessed. A crash, or a processing failure, loses the update
+	// for good: totals can drift below the true value. Only use it for
+	// approximate aggregates where latency matters more than accuracy.
+	AtMostOnce
+)
+
+// ParseDeliverySemantics parses a delivery mode name: at-least-once or at-most-once.
+func ParseDeliverySemantics(name string) (DeliverySemantics, error) {
+	switch name {
+	case "", "at-least-once":
+		return AtLeastOnce, nil
+	case "at-most-once":
+		return AtMostOnce, nil
+	}
+	return AtLeastOnce, fmt.Errorf("unknown delivery semantics %q", name)
+}
+
+// ParseIsoLevel parses an isolation level name: read-committed,
+// repeatable-read or serializable. An empty name selects the database default.
+func ParseIsoLevel(name string) (pgx.TxIsoLevel, error) {
+	switch name {
+	case "":
+		return "", nil
+	case "read-committed":
+		return pgx.ReadCommitted, nil
+	case "repeatable-read":
+		return pgx.RepeatableRead, nil
+	case "serializable":
+		return pgx.Serializable, nil
+	}
+	return "", fmt.Errorf("unknown isolation level %q", name)
+}
+
+// ChainWriter queues a downstream event within the apply transaction.
+// *chain.Outbox implements it.
+type ChainWriter interface {
+	RecordTotalUpdatedInTx(ctx context.Context, tx pgx.Tx, sourceEventID uuid.UUID, delta, total int64) error
+}
+
+// TotalObserver is told when an applied event has changed the total, e.g. to
+// invalidate a cached read of it.
+type TotalObserver interface {
+	TotalChanged()
+}
+
+// CanaryObserver receives the IDs of canary events seen by the consumer.
+type CanaryObserver interface {
+	Observe(eventID uuid.UUID)
 }
 
 type Consumer struct {
-	reader    *kafka.Reader
+	reader    atomic.Pointer[kafka.Reader]
 	pool      *pgxpool.Pool
 	dedupRepo *dedup.Repository
+	dlqRepo   *dlq.Repository
 	storage   *storage.PostgresStorage
+	metrics   *telemetry.Metrics
 	stopCh    chan struct{}
 	topic     string
+	config    ConsumerConfig
+	handler   Handler
+
+	workers       map[int]chan kafka.Message
+	offsets       offsetTracker
+	inFlight      chan struct{}
+	fair          *fairSlots
+	inFlightCount atomic.Int64
+	wg            sync.WaitGroup
+	cancelFetch   context.CancelFunc
+	done          chan struct{}
+	quiesced      atomic.Bool
+
+	// lifecycle serializes Stop, Quiesce and Seek, which replaces the reader
+	// and restarts the consume loop.
+	lifecycle sync.Mutex
+	runCtx    context.Context
+	stopped   bool // set by the first Stop; guarded by lifecycle
+
+	fetchErrLog   *logsample.Sampler
+	processErrLog *logsample.Sampler
+	dedupErrLog   *logsample.Sampler
+	staleLog      *logsample.Sampler
+	logger        *slog.Logger
+
+	// handlers maps each event type the consumer applies to its handler.
+	handlers map[string]Handler
+
+	// aggregates is set when SerializeAggregates is enabled.
+	aggregates *aggregateLocks
+
+	// coalescer is set when CoalesceWindow is.
+	coalescer *coalescer
+}
+
+// FetchSettings tune how a reader batches fetches: a high-volume topic
+// benefits from large batches, a low-latency one from a short MaxWait.
+type FetchSettings struct {
+	// MinBytes is how much data the broker waits for before answering a fetch.
+	MinBytes int
+	// MaxBytes bounds the data returned by a single fetch.
+	MaxBytes int
+	// MaxWait is how long the broker waits for MinBytes before answering anyway.
+	MaxWait time.Duration
+}
+
+// defaultFetch is used for fields left zero in both TopicFetch and Fetch.
+var defaultFetch = FetchSettings{
+	MinBytes: 10e3, // 10KB
+	MaxBytes: 10e6, // 10MB
+	MaxWait:  10 * time.Second,
+}
+
+// or returns s with its zero fields taken from fallback.
+func (s FetchSettings) or(fallback FetchSettings) FetchSettings {
+	if s.MinBytes == 0 {
+		s.MinBytes = fallback.MinBytes
+	}
+	if s.MaxBytes == 0 {
+		s.MaxBytes = fallback.MaxBytes
+	}
+	if s.MaxWait == 0 {
+		s.MaxWait = fallback.MaxWait
+	}
+	return s
 }
 
-func NewConsumer(cfg ConsumerConfig, pool *pgxpool.Pool, dedupRepo *dedup.Repository, storage *storage.PostgresStorage) *Consumer {
-	reader := kafka.NewReader(kafka.ReaderConfig{
+// fetchSettings returns the fetch settings for topic.
+func (cfg ConsumerConfig) fetchSettings(topic string) FetchSettings {
+	return cfg.TopicFetch[topic].or(cfg.Fetch.or(defaultFetch))
+}
+
+// newReader creates the consumer group reader for cfg.
+func newReader(cfg ConsumerConfig) *kafka.Reader {
+	// At-most-once must commit synchronously: a commit that is still queued
+	// when the process dies would let the message be redelivered.
+	interval := commitInterval
+	if cfg.Delivery == AtMostOnce {
+		interval = 0
+	}
+
+	fetch := cfg.fetchSettings(cfg.Topic)
+	return kafka.NewReader(kafka.ReaderConfig{
 		Brokers:        cfg.Brokers,
 		Topic:          cfg.Topic,
 		GroupID:        cfg.GroupID,
-		MinBytes:       10e3, // 10KB
-		MaxBytes:       10e6, // 10MB
-		CommitInterval: time.Second,
+		MinBytes:       fetch.MinBytes,
+		MaxBytes:       fetch.MaxBytes,
+		MaxWait:        fetch.MaxWait,
+		CommitInterval: interval,
+		QueueCapacity:  cfg.FetchQueueCapacity,
 		StartOffset:    kafka.FirstOffset,
 	})
+}
+
+func NewConsumer(cfg ConsumerConfig, pool *pgxpool.Pool, dedupRepo *dedup.Repository, dlqRepo *dlq.Repository, storage *storage.PostgresStorage, metrics *telemetry.Metrics) *Consumer {
+	var inFlight chan struct{}
+	var fair *fairSlots
+	if cfg.MaxInFlight > 0 {
+		if cfg.FairScheduling {
+			fair = newFairSlots(cfg.MaxInFlight)
+			cfg.FairQuantum = max(cfg.FairQuantum, 1)
+		} else {
+			inFlight = make(chan struct{}, cfg.MaxInFlight)
+		}
+	}
 
-	return &Consumer{
-		reader:    reader,
+	c := &Consumer{
 		pool:      pool,
 		dedupRepo: dedupRepo,
+		dlqRepo:   dlqRepo,
 		storage:   storage,
+		metrics:   metrics,
 		stopCh:    make(chan struct{}),
 		topic:     cfg.Topic,
+		config:    cfg,
+		workers:   make(map[int]chan kafka.Message),
+		inFlight:  inFlight,
+		fair:      fair,
+		done:      make(chan struct{}),
+
+		fetchErrLog:   logsample.New(cfg.LogSampleInterval),
+		processErrLog: logsample.New(cfg.LogSampleInterval),
+		dedupErrLog:   logsample.New(cfg.LogSampleInterval),
+		staleLog:      logsample.New(cfg.LogSampleInterval),
+		logger:        cfg.Logger,
+	}
+	if c.logger == nil {
+		c.logger = slog.Default()
+	}
+	if cfg.SerializeAggregates {
+		c.aggregates = newAggregateLocks()
+	}
+	if cfg.CoalesceWindow > 0 {
+		c.coalescer = newCoalescer(c, cfg.CoalesceWindow, cfg.CoalesceMaxBatch)
+	}
+	c.handlers = c.eventHandlers()
+	c.reader.Store(newReader(cfg))
+
+	middlewares := []MessageMiddleware{DedupMiddleware(dedupRepo)}
+	if cfg.Ordering != nil {
+		middlewares = append(middlewares, OrderingMiddleware(cfg.Ordering, metrics, cfg.Topic))
+	}
+	middlewares = append(middlewares, cfg.Middlewares...)
+	c.handler = chain(c.handleEvent, middlewares...)
+
+	return c
+}
+
+// SetLogSampleInterval changes LogSampleInterval while the consumer runs.
+func (c *Consumer) SetLogSampleInterval(interval time.Duration) {
+	for _, s := range []*logsample.Sampler{c.fetchErrLog, c.processErrLog, c.dedupErrLog, c.staleLog} {
+		s.SetInterval(interval)
 	}
 }
 
 // Start begins consuming messages
 func (c *Consumer) Start(ctx context.Context) {
-	go c.consumeLoop(ctx)
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	c.runCtx = ctx
+	c.start()
+}
+
+// start runs the consume loop on the current reader. The caller holds lifecycle.
+func (c *Consumer) start() {
+	c.offsets.reset()
+	fetchCtx, cancelFetch := context.WithCancel(c.runCtx)
+	c.cancelFetch = cancelFetch
+	go c.consumeLoop(c.runCtx, fetchCtx, c.reader.Load())
 }
 
-// Stop signals the consumer to stop
-func (c *Consumer) Stop() error {
+// Stop stops fetching new messages and waits for in-flight messages to finish
+// and commit before closing the reader. If ctx expires first the drain is
+// reported as incomplete and any unfinished messages are redelivered later.
+// Calling Stop again is a no-op.
+func (c *Consumer) Stop(ctx context.Context) error {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	if c.stopped {
+		return nil
+	}
+	c.stopped = true
+
 	close(c.stopCh)
-	return c.reader.Close()
+	if c.cancelFetch != nil {
+		c.cancelFetch()
+	}
+
+	inFlight := c.inFlightCount.Load()
+	c.metrics.ConsumerInflightAtShutdown.WithLabelValues(c.topic).Set(float64(inFlight))
+	log.Printf("consumer draining: %d messages in flight", inFlight)
+
+	select {
+	case <-c.done:
+		c.metrics.ConsumerDrainCompleted.WithLabelValues(c.topic).Set(1)
+		log.Printf("consumer drain completed")
+	case <-ctx.Done():
+		c.metrics.ConsumerDrainCompleted.WithLabelValues(c.topic).Set(0)
+		log.Printf("consumer drain incomplete: %d messages still in flight", c.inFlightCount.Load())
+	}
+
+	return c.reader.Load().Close()
+}
+
+// Quiesce stops fetching, waits for every fetched message to be processed and
+// its offset committed, then leaves the consumer idle with the reader still
+// open, so the process keeps serving reads while consumption is paused (e.g.
+// during a topic or group migration). A quiesced consumer is not restarted;
+// Stop must still be called on shutdown.
+func (c *Consumer) Quiesce(ctx context.Context) error {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	return c.quiesce(ctx)
+}
+
+// quiesce implements Quiesce. The caller holds lifecycle.
+func (c *Consumer) quiesce(ctx context.Context) error {
+	if c.cancelFetch != nil {
+		c.cancelFetch()
+	}
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return fmt.Errorf("consumer quiesce: %d messages still in flight: %w", c.inFlightCount.Load(), ctx.Err())
+	}
+
+	// Offsets are committed asynchronously every commitInterval; wait for the
+	// reader to flush the last ones before reporting the consumer as quiesced
+	select {
+	case <-time.After(commitInterval):
+	case <-ctx.Done():
+		return fmt.Errorf("consumer quiesce: waiting for offset commit: %w", ctx.Err())
+	}
+
+	if !c.quiesced.Swap(true) {
+		log.Printf("consumer quiesced")
+	}
+	return nil
 }
 
-func (c *Consumer) consumeLoop(ctx context.Context) {
+// ConsumerStatus is a point-in-time view of the consumer for diagnostics.
+type ConsumerStatus struct {
+	Topic    string `json:"topic"`
+	GroupID  string `json:"group_id"`
+	Stopping bool   `json:"stopping"`
+	Quiesced bool   `json:"quiesced"`
+	InFlight int64  `json:"in_flight"`
+	Offset   int64  `json:"offset"`
+	Lag      int64  `json:"lag"`
+	Queued   int64  `json:"queued"`
+}
+
+// Status reports the consumer's current state and the reader's lag as of its last fetch.
+func (c *Consumer) Status() ConsumerStatus {
+	stats := c.reader.Load().Stats()
+
+	stopping := false
+	select {
+	case <-c.stopCh:
+		stopping = true
+	default:
+	}
+
+	return ConsumerStatus{
+		Topic:    c.topic,
+		GroupID:  c.config.GroupID,
+		Stopping: stopping,
+		Quiesced: c.quiesced.Load(),
+		InFlight: c.inFlightCount.Load(),
+		Offset:   stats.Offset,
+		Lag:      stats.Lag,
+		Queued:   stats.QueueLength,
+	}
+}
+
+// consumeLoop hands each fetched message to the worker that owns its
+// partition, so a slow message only delays its own partition.
+func (c *Consumer) consumeLoop(ctx, fetchCtx context.Context, reader *kafka.Reader) {
+	defer close(c.done)
+	if c.coalescer != nil {
+		// Runs after the workers stop, so everything they queued is applied
+		defer c.coalescer.flush(ctx)
+	}
+	defer c.stopPartitionWorkers()
+
+	messages := c.prefetch(fetchCtx, reader)
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-c.stopCh:
 			return
-		default:
-			msg, err := c.reader.FetchMessage(ctx)
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			if !c.dispatch(ctx, msg) {
+				return
+			}
+		}
+	}
+}
+
+// fetcher is the part of *kafka.Reader that prefetch uses.
+type fetcher interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+}
+
+// prefetch fetches messages in its own goroutine, buffering up to
+// PrefetchSize of them so fetch latency overlaps with dispatch and processing.
+// Messages are delivered in fetch order, so each partition's offsets are still
+// committed in order. The channel is closed once ctx is cancelled.
+func (c *Consumer) prefetch(ctx context.Context, reader fetcher) <-chan kafka.Message {
+	messages := make(chan kafka.Message, c.config.PrefetchSize)
+
+	go func() {
+		defer close(messages)
+		for {
+			msg, err := reader.FetchMessage(ctx)
 			if err != nil {
-				if errors.Is(err, context.Canceled) {
+				if ctx.Err() != nil {
 					return
 				}
-				log.Printf("error fetching message: %v", err)
+				c.fetchErrLog.Printf("error fetching message: %v", err)
 				continue
 			}
 
-			if err := c.processMessage(ctx, msg); err != nil {
-				log.Printf("error processing message: %v", err)
-				// Continue processing - don't commit the message so it will be retried
-				continue
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
 			}
+		}
+	}()
+
+	return messages
+}
 
-			if err := c.reader.CommitMessages(ctx, msg); err != nil {
-				log.Printf("error committing message: %v", err)
+// processWithRetry runs processWithTimeout, retrying failures with backoff up
+// to MaxRetries times before returning the last error.
+// Deadlocks are transient, so they are retried separately, up to
+// MaxDeadlockRetries times, without counting towards MaxRetries.
+func (c *Consumer) processWithRetry(ctx context.Context, msg kafka.Message) (*delivery, error) {
+	backoff := c.config.RetryBackoff
+	deadlockBackoff := c.config.RetryBackoff
+	if deadlockBackoff <= 0 {
+		deadlockBackoff = defaultDeadlockBackoff
+	}
+
+	deadlocks := 0
+	for retries := 0; ; {
+		d, err := c.processWithTimeout(ctx, msg)
+
+		if isDeadlock(err) && deadlocks < c.config.MaxDeadlockRetries && ctx.Err() == nil {
+			deadlocks++
+			c.metrics.ConsumerDeadlockRetries.WithLabelValues(c.topic).Inc()
+			c.processErrLog.Printf("deadlock processing message at partition %d offset %d (retry %d of %d in %s)",
+				msg.Partition, msg.Offset, deadlocks, c.config.MaxDeadlockRetries, deadlockBackoff)
+
+			select {
+			case <-time.After(deadlockBackoff):
+			case <-ctx.Done():
+				return d, ctx.Err()
 			}
+			deadlockBackoff *= 2
+			continue
+		}
+
+		if err == nil || ctx.Err() != nil || c.config.MaxRetries <= 0 {
+			c.metrics.ConsumerMessageRetries.WithLabelValues(c.topic).Observe(float64(retries))
+			return d, err
+		}
+
+		if retries >= c.config.MaxRetries {
+			c.metrics.ConsumerMessageRetries.WithLabelValues(c.topic).Observe(float64(retries))
+			return d, fmt.Errorf("processing failed after %d retries: %w", retries, err)
 		}
+
+		c.processErrLog.Printf("processing message at partition %d offset %d failed (retry %d of %d in %s): %v",
+			msg.Partition, msg.Offset, retries+1, c.config.MaxRetries, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return d, ctx.Err()
+		}
+		backoff *= 2
+		retries++
+	}
+}
+
+const (
+	// defaultHoldBackoff is the initial wait before a held message is retried
+	// when RetryBackoff is unset.
+	defaultHoldBackoff = time.Second
+	// maxHoldBackoff caps the wait between retries of a held message.
+	maxHoldBackoff = 30 * time.Second
+)
+
+// processOrHold processes msg with processWithRetry and returns the delivery
+// to commit. A message that still fails holds its partition, retrying with
+// backoff until it succeeds: committing any later offset on the partition
+// would skip it. Invalid payloads never get here, as processMessage
+// dead-letters them. It returns false, leaving msg uncommitted, only once the
+// consumer is stopping.
+func (c *Consumer) processOrHold(ctx context.Context, msg kafka.Message) (*delivery, bool) {
+	backoff := c.config.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultHoldBackoff
+	}
+
+	for {
+		d, err := c.processWithRetry(ctx, msg)
+		if err == nil {
+			return d, true
+		}
+		if c.stopping(ctx) {
+			c.processErrLog.Printf("error processing message at partition %d offset %d, leaving it uncommitted: %v",
+				msg.Partition, msg.Offset, err)
+			return nil, false
+		}
+
+		c.processErrLog.Printf("holding partition %d at offset %d (retry in %s): %v",
+			msg.Partition, msg.Offset, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, false
+		case <-c.stopCh:
+			return nil, false
+		}
+		backoff = min(backoff*2, maxHoldBackoff)
+	}
+}
+
+// stopping reports whether ctx is done or the consumer is stopping.
+func (c *Consumer) stopping(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	select {
+	case <-c.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// defaultDeadlockBackoff is the initial deadlock retry backoff when RetryBackoff is unset.
+const defaultDeadlockBackoff = 50 * time.Millisecond
+
+// isDeadlock reports whether err is a PostgreSQL deadlock (SQLSTATE 40P01).
+func isDeadlock(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "40P01"
+}
+
+// processWithTimeout runs processMessage under the configured per-message timeout.
+// A timed-out attempt rolls back its transaction and is retried; once the message
+// has timed out MaxProcessTimeouts times it is dead-lettered so the partition can move on.
+func (c *Consumer) processWithTimeout(ctx context.Context, msg kafka.Message) (*delivery, error) {
+	if c.config.ProcessTimeout <= 0 {
+		d := newDelivery(msg)
+		return d, c.processMessage(ctx, d)
 	}
+
+	for attempt := 1; ; attempt++ {
+		d := newDelivery(msg)
+		attemptCtx, cancel := context.WithTimeout(ctx, c.config.ProcessTimeout)
+		err := c.processMessage(attemptCtx, d)
+		cancel()
+
+		// Only treat the error as a timeout if our own deadline fired, not the parent context
+		if !errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+			return d, err
+		}
+
+		c.metrics.ConsumerProcessTimeouts.WithLabelValues(c.topic).Inc()
+		log.Printf("processing message at partition %d offset %d timed out after %s (attempt %d)",
+			msg.Partition, msg.Offset, c.config.ProcessTimeout, attempt)
+
+		if c.config.MaxProcessTimeouts > 0 && attempt >= c.config.MaxProcessTimeouts {
+			return newDelivery(msg), c.deadLetter(ctx, msg, fmt.Sprintf("processing timed out %d times", attempt))
+		}
+	}
+}
+
+// deadLetter parks a message in the dead-letter table. A nil return means the
+// message is safe to commit.
+func (c *Consumer) deadLetter(ctx context.Context, msg kafka.Message, reason string) error {
+	err := c.dlqRepo.Insert(ctx, dlq.Entry{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Reason:    reason,
+	})
+	if err != nil {
+		return fmt.Errorf("dead-letter message at partition %d offset %d: %w", msg.Partition, msg.Offset, err)
+	}
+
+	log.Printf("message at partition %d offset %d moved to dead-letter table: %s", msg.Partition, msg.Offset, reason)
+	return nil
 }
 
-func (c *Consumer) processMessage(ctx context.Context, msg kafka.Message) error {
+func (c *Consumer) processMessage(ctx context.Context, d *delivery) error {
+	msg := d.msg
+
 	// Extract trace context from headers
 	carrier := kafkaHeaderCarrier(msg.Headers)
 	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
@@ -158,22 +912,46 @@ func (c *Consumer) processMessage(ctx context.Context, msg kafka.Message) error
 	var event Event
 	if err := json.Unmarshal(msg.Value, &event); err != nil {
 		log.Printf("error unmarshaling event: %v", err)
-		telemetry.KafkaMessagesConsumed.WithLabelValues(c.topic, "unknown", "error").Inc()
+		c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, "unknown", "malformed").Inc()
+		c.metrics.KafkaMessagesMalformed.WithLabelValues(c.topic, "envelope").Inc()
 		span.RecordError(err)
 		return nil // Skip malformed messages
 	}
+	event.Partition = msg.Partition
+	event.Offset = msg.Offset
+	event.Key = string(msg.Key)
+
+	if event.AggregateType == canary.AggregateType {
+		if c.config.Canary != nil {
+			c.config.Canary.Observe(event.EventID)
+		}
+		return nil
+	}
+
+	eventType := c.eventTypeLabel(event.EventType)
+
+	if len(c.config.AggregateTypes) > 0 && !slices.Contains(c.config.AggregateTypes, event.AggregateType) {
+		c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "unexpected_aggregate").Inc()
+		return c.deadLetter(ctx, msg, fmt.Sprintf("unexpected aggregate type %q", event.AggregateType))
+	}
 
 	// Record latency metrics
 	now := time.Now()
 
+	if c.config.MaxEventAge > 0 && now.Sub(event.CreatedAt) > c.config.MaxEventAge {
+		c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "stale").Inc()
+		c.staleLog.Printf("skipping event %s created at %s: older than max event age %s", event.EventID, event.CreatedAt.Format(time.RFC3339), c.config.MaxEventAge)
+		return nil
+	}
+
 	// Event processing latency (full lifecycle: created_at → now)
 	eventLatency := now.Sub(event.CreatedAt).Seconds()
-	telemetry.EventProcessingLatency.WithLabelValues(c.topic, event.EventType).Observe(eventLatency)
+	c.metrics.EventProcessingLatency.WithLabelValues(c.topic, eventType).Observe(eventLatency)
 
 	// Kafka delivery latency (Kafka only: published_at → now)
 	if event.PublishedAt != nil {
 		kafkaLatency := now.Sub(*event.PublishedAt).Seconds()
-		telemetry.KafkaDeliveryLatency.WithLabelValues(c.topic, event.EventType).Observe(kafkaLatency)
+		c.metrics.KafkaDeliveryLatency.WithLabelValues(c.topic, eventType).Observe(kafkaLatency)
 	}
 
 	span.SetAttributes(
@@ -181,62 +959,242 @@ func (c *Consumer) processMessage(ctx context.Context, msg kafka.Message) error
 		attribute.String("event.type", event.EventType),
 	)
 
+	// Fast-path duplicate check; on error fall back to the transactional check
+	if c.config.DedupStore != nil {
+		seen, err := c.config.DedupStore.Seen(ctx, event.EventID)
+		if err != nil {
+			c.dedupErrLog.Printf("dedup store lookup failed for event %s, falling back to database: %v", event.EventID, err)
+		} else if seen {
+			c.logEventSkipped(ctx, &event)
+			c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "duplicate").Inc()
+			return nil
+		}
+	}
+
+	if d.coalesce {
+		c.coalescer.add(ctx, coalescedItem{d: d, event: &event, eventType: eventType})
+		d.queued = true
+		return nil
+	}
+
+	if c.aggregates != nil {
+		key := event.AggregateType + "/" + event.AggregateID
+		contended, err := c.aggregates.lock(ctx, key)
+		if contended {
+			c.metrics.KafkaAggregateLockContention.WithLabelValues(c.topic).Inc()
+		}
+		if err != nil {
+			c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "error").Inc()
+			return err
+		}
+		defer c.aggregates.unlock(key)
+	}
+
 	// Start transaction
-	tx, err := c.pool.Begin(ctx)
+	tx, err := d.begin(ctx, c.pool, pgx.TxOptions{IsoLevel: c.config.IsoLevel})
 	if err != nil {
-		telemetry.KafkaMessagesConsumed.WithLabelValues(c.topic, event.EventType, "error").Inc()
+		c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "error").Inc()
 		span.RecordError(err)
 		return err
 	}
-	defer tx.Rollback(ctx)
+	defer d.rollback(ctx)
 
-	// Check idempotency and mark as processed
-	err = c.dedupRepo.CheckAndMarkInTx(ctx, tx, event.EventID, event.AggregateType, event.EventType)
+	// Run the middleware chain (dedup, ordering, custom) and the event handler
+	err = c.handler(ctx, tx, &event)
 	if errors.Is(err, dedup.ErrEventAlreadyProcessed) {
-		log.Printf("event %s already processed, skipping", event.EventID)
-		telemetry.KafkaMessagesConsumed.WithLabelValues(c.topic, event.EventType, "duplicate").Inc()
+		c.logEventSkipped(ctx, &event)
+		c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "duplicate").Inc()
+		d.discard(ctx)
 		return nil // Already processed, skip
 	}
+	if errors.Is(err, ErrInvalidPayload) {
+		if errors.Is(err, errMalformedPayload) {
+			c.metrics.KafkaMessagesMalformed.WithLabelValues(c.topic, "payload").Inc()
+		}
+		c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "invalid").Inc()
+		span.RecordError(err)
+		d.discard(ctx)
+		return c.deadLetter(ctx, msg, err.Error())
+	}
 	if err != nil {
-		telemetry.KafkaMessagesConsumed.WithLabelValues(c.topic, event.EventType, "error").Inc()
+		c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "error").Inc()
 		span.RecordError(err)
 		return err
 	}
 
-	// Process the event based on type
-	if err := c.handleEvent(ctx, tx, &event); err != nil {
-		telemetry.KafkaMessagesConsumed.WithLabelValues(c.topic, event.EventType, "error").Inc()
+	if err := d.commit(ctx); err != nil {
+		c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "error").Inc()
 		span.RecordError(err)
 		return err
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		telemetry.KafkaMessagesConsumed.WithLabelValues(c.topic, event.EventType, "error").Inc()
-		span.RecordError(err)
-		return err
+	c.logger.LogAttrs(ctx, slog.LevelInfo, "event applied",
+		slog.String(telemetry.EventIDKey, event.EventID.String()),
+		slog.String("event_type", event.EventType),
+		slog.Int("partition", msg.Partition),
+		slog.Int64("offset", msg.Offset),
+		telemetry.TraceAttr(ctx),
+	)
+
+	if c.config.TotalObserver != nil {
+		c.config.TotalObserver.TotalChanged()
+	}
+
+	if c.config.Secondary != nil {
+		if err := c.config.Secondary.Sync(ctx); err != nil {
+			c.processErrLog.Printf("failed to sync total to secondary storage: %v", err)
+		}
+	}
+
+	if c.config.DedupStore != nil {
+		if err := c.config.DedupStore.Mark(ctx, event.EventID); err != nil {
+			c.dedupErrLog.Printf("failed to mark event %s in dedup store: %v", event.EventID, err)
+		}
 	}
 
-	telemetry.KafkaMessagesConsumed.WithLabelValues(c.topic, event.EventType, "success").Inc()
+	c.metrics.KafkaMessagesConsumed.WithLabelValues(c.topic, eventType, "success").Inc()
 	return nil
 }
 
 func (c *Consumer) handleEvent(ctx context.Context, tx pgx.Tx, event *Event) error {
-	switch event.EventType {
-	case "sum.calculated":
-		return c.handleSumCalculated(ctx, tx, event)
+	c.logEvent(ctx, event)
+
+	handle, ok := c.handlers[event.EventType]
+	if !ok {
+		return fmt.Errorf("%w: unknown event type %q", ErrInvalidPayload, event.EventType)
+	}
+	return handle(ctx, tx, event)
+}
+
+// logEventSkipped records an event skipped because it was already applied.
+func (c *Consumer) logEventSkipped(ctx context.Context, event *Event) {
+	c.logger.LogAttrs(ctx, slog.LevelInfo, "event already applied, skipping",
+		slog.String(telemetry.EventIDKey, event.EventID.String()),
+		slog.String("event_type", event.EventType),
+		telemetry.TraceAttr(ctx),
+	)
+}
+
+// logEvent writes a debug record for an event about to be handled.
+func (c *Consumer) logEvent(ctx context.Context, event *Event) {
+	if !c.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String(telemetry.EventIDKey, event.EventID.String()),
+		slog.String("event_type", event.EventType),
+		slog.String("aggregate_id", event.AggregateID),
+	}
+	switch {
+	case c.config.RedactPayloads:
+		attrs = append(attrs, slog.String("payload", "[redacted]"))
+	case event.IsJSON():
+		attrs = append(attrs, slog.Any("payload", event.Payload))
 	default:
-		log.Printf("unknown event type: %s", event.EventType)
-		return nil
+		attrs = append(attrs, slog.String("content_type", event.ContentType), slog.Int("payload_bytes", len(event.Data)))
 	}
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "handling event", attrs...)
 }
 
 func (c *Consumer) handleSumCalculated(ctx context.Context, tx pgx.Tx, event *Event) error {
-	var payload SumCalculatedPayload
-	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+	if !event.IsJSON() {
+		return fmt.Errorf("%w: sum.calculated with unsupported content type %q", ErrInvalidPayload, event.ContentType)
+	}
+
+	payload, err := c.decodeSumCalculated(event.Payload)
+	if err != nil {
 		return err
 	}
 
-	log.Printf("processing sum.calculated event: %d + %d = %d", payload.X, payload.Y, payload.Result)
+	result := payload.Result
+	if c.config.ResultTransform != nil {
+		result = c.config.ResultTransform(result)
+	}
 
-	return c.storage.AddToTotalInTx(ctx, tx, payload.Result)
+	if c.config.Mode == MaterializeLatest {
+		result, err = c.storage.MaterializeInTx(ctx, tx, event.totalKey(), event.EventID, result)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A coalesced batch already holds the totals row lock and adds its
+	// events' results in one update once they are all handled
+	if event.pendingTotal != nil {
+		event.pendingTotal.value += result
+		event.pendingTotal.added = true
+	} else if err := c.storage.AddToTotalInTx(ctx, tx, event.EventID, result); err != nil {
+		return err
+	}
+	if c.config.KeyTTL > 0 {
+		if err := c.storage.AddToKeyedTotalInTx(ctx, tx, event.totalKey(), result, c.config.KeyTTL); err != nil {
+			return err
+		}
+	}
+	if c.config.StreamChecksum {
+		if err := c.storage.ChainChecksumInTx(ctx, tx, event.EventID, result); err != nil {
+			return err
+		}
+	}
+	if err := c.storage.AddToTypeTotalInTx(ctx, tx, event.EventType, result); err != nil {
+		return err
+	}
+	entry := storage.HistoryEntry{
+		EventID:        event.EventID,
+		Value:          result,
+		EventCreatedAt: event.CreatedAt,
+	}
+	if c.config.RecordOffsets {
+		entry.KafkaPartition = &event.Partition
+		entry.KafkaOffset = &event.Offset
+	}
+	if err := c.storage.RecordHistoryInTx(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	if c.config.Chain != nil {
+		total, err := c.storage.LoadTotalInTx(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return c.config.Chain.RecordTotalUpdatedInTx(ctx, tx, event.EventID, result, total)
+	}
+	return nil
+}
+
+// decodeSumCalculated decodes a sum.calculated payload. In strict mode every
+// field must be present; in lenient mode missing fields default to zero.
+func (c *Consumer) decodeSumCalculated(raw json.RawMessage) (SumCalculatedPayload, error) {
+	if !c.config.StrictPayloads {
+		var payload SumCalculatedPayload
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return SumCalculatedPayload{}, fmt.Errorf("%w: %v", errMalformedPayload, err)
+		}
+		return payload, nil
+	}
+
+	var strict strictSumCalculatedPayload
+	if err := json.Unmarshal(raw, &strict); err != nil {
+		return SumCalculatedPayload{}, fmt.Errorf("%w: %v", errMalformedPayload, err)
+	}
+
+	var missing []string
+	if strict.X == nil && strict.Operands == nil {
+		missing = append(missing, "x")
+	}
+	if strict.Y == nil && strict.Operands == nil {
+		missing = append(missing, "y")
+	}
+	if strict.Result == nil {
+		missing = append(missing, "result")
+	}
+	if len(missing) > 0 {
+		return SumCalculatedPayload{}, fmt.Errorf("%w: sum.calculated payload missing %s", ErrInvalidPayload, strings.Join(missing, ", "))
+	}
+
+	if strict.Operands != nil {
+		return SumCalculatedPayload{Operands: strict.Operands, Result: *strict.Result}, nil
+	}
+	return SumCalculatedPayload{X: *strict.X, Y: *strict.Y, Result: *strict.Result}, nil
 }
@@ -0,0 +1,279 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aelhady03/sumflow/pkg/logsample"
+	"github.com/aelhady03/sumflow/pkg/pgtest"
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/aelhady03/sumflow/totalizer/internal/database"
+	"github.com/aelhady03/sumflow/totalizer/internal/dedup"
+	"github.com/aelhady03/sumflow/totalizer/internal/dlq"
+	"github.com/aelhady03/sumflow/totalizer/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// bigSumPayload is a sum.calculated payload, as the adder writes it, whose
+// result doesn't fit in 32 bits.
+const bigSumPayload = `{"x":2500000000,"y":2500000000,"result":5000000000}`
+
+// newTestConsumer returns a consumer with cfg on a migrated test schema. Its
+// reader has no consumer group and is never started, so no broker is needed.
+func newTestConsumer(t *testing.T, cfg ConsumerConfig) (*Consumer, *pgxpool.Pool) {
+	t.Helper()
+	pool := pgtest.Pool(t)
+	if err := database.RunMigrations(context.Background(), pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	cfg.Brokers = []string{"localhost:9092"}
+	cfg.Topic = "sums"
+	metrics := telemetry.NewMetrics(prometheus.NewRegistry(), telemetry.MetricsOptions{})
+	c := NewConsumer(cfg, pool, dedup.NewRepository(pool), dlq.NewRepository(pool), storage.NewPostgresStorage(pool), metrics)
+	t.Cleanup(func() { c.reader.Load().Close() })
+	return c, pool
+}
+
+// sumMessage returns a Kafka message carrying a sum.calculated event for
+// result, as the adder publishes it.
+func sumMessage(t *testing.T, offset int64, result int64) kafka.Message {
+	t.Helper()
+	value, err := json.Marshal(map[string]any{
+		"event_id":       uuid.New(),
+		"aggregate_type": "sum",
+		"aggregate_id":   uuid.NewString(),
+		"event_type":     "sum.calculated",
+		"payload":        map[string]int64{"x": result, "y": 0, "result": result},
+		"created_at":     time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kafka.Message{Topic: "sums", Offset: offset, Value: value}
+}
+
+// applySumCalculated handles a sum.calculated event with payload in its own
+// committed transaction.
+func applySumCalculated(t *testing.T, c *Consumer, pool *pgxpool.Pool, payload string) {
+	t.Helper()
+	ctx := context.Background()
+	event := &Event{
+		EventID:   uuid.New(),
+		EventType: "sum.calculated",
+		Payload:   json.RawMessage(payload),
+		CreatedAt: time.Now(),
+	}
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := c.handleSumCalculated(ctx, tx, event); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+}
+
+func TestDecodeSumCalculatedKeepsResultsBeyondInt32(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		c := &Consumer{config: ConsumerConfig{StrictPayloads: strict}}
+		payload, err := c.decodeSumCalculated(json.RawMessage(bigSumPayload))
+		if err != nil {
+			t.Fatalf("strict=%v: decode: %v", strict, err)
+		}
+		if payload.Result != 5_000_000_000 {
+			t.Errorf("strict=%v: result %d, want 5000000000", strict, payload.Result)
+		}
+	}
+}
+
+func TestHandleSumCalculatedAppliesResultsBeyondInt32(t *testing.T) {
+	c, pool := newTestConsumer(t, ConsumerConfig{})
+	applySumCalculated(t, c, pool, bigSumPayload)
+
+	total, err := c.storage.LoadContext(context.Background())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if total != 5_000_000_000 {
+		t.Errorf("total %d, want 5000000000", total)
+	}
+}
+
+func TestHandleSumCalculatedAppliesResultTransform(t *testing.T) {
+	c, pool := newTestConsumer(t, ConsumerConfig{
+		ResultTransform: func(v int64) int64 { return v * 1000 },
+	})
+	applySumCalculated(t, c, pool, bigSumPayload)
+
+	total, err := c.storage.LoadContext(context.Background())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if total != 5_000_000_000_000 {
+		t.Errorf("total %d, want the transformed 5000000000000", total)
+	}
+}
+
+func TestEventDecodesBinaryPayload(t *testing.T) {
+	// The adder's envelope for a binary event: Data is base64 in JSON
+	value := `{"event_id":"` + uuid.NewString() + `","aggregate_type":"sum","aggregate_id":"binary",` +
+		`"event_type":"sum.calculated","content_type":"application/x-protobuf","data":"AAH+/3s=",` +
+		`"created_at":"2026-01-02T03:04:05Z"}`
+
+	var event Event
+	if err := json.Unmarshal([]byte(value), &event); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if event.IsJSON() {
+		t.Error("binary event reported as JSON")
+	}
+	if want := []byte{0x00, 0x01, 0xfe, 0xff, '{'}; !bytes.Equal(event.Data, want) {
+		t.Errorf("data %x, want %x", event.Data, want)
+	}
+}
+
+func TestConcurrentAppliesToTheSameTotal(t *testing.T) {
+	for _, level := range []pgx.TxIsoLevel{pgx.ReadCommitted, pgx.RepeatableRead, pgx.Serializable} {
+		t.Run(string(level), func(t *testing.T) {
+			// Stricter levels fail concurrent updates of the totals row with
+			// serialization errors, which must be retried, not lost
+			c, pool := newTestConsumer(t, ConsumerConfig{
+				IsoLevel:     level,
+				MaxRetries:   20,
+				RetryBackoff: time.Millisecond,
+			})
+			ctx := context.Background()
+
+			const workers, perWorker = 8, 10
+			var wg sync.WaitGroup
+			errs := make(chan error, workers*perWorker)
+			for w := range workers {
+				msgs := make([]kafka.Message, perWorker)
+				for i := range msgs {
+					msgs[i] = sumMessage(t, int64(w*perWorker+i), 1)
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for _, msg := range msgs {
+						if _, err := c.processWithRetry(ctx, msg); err != nil {
+							errs <- err
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Errorf("apply: %v", err)
+			}
+
+			result, err := c.storage.LoadResult(ctx)
+			if err != nil {
+				t.Fatalf("load result: %v", err)
+			}
+			if result.Total != workers*perWorker || result.Count != workers*perWorker {
+				t.Errorf("total %d from %d events, want %d from %d", result.Total, result.Count, workers*perWorker, workers*perWorker)
+			}
+			var dead int
+			if err := pool.QueryRow(ctx, `SELECT count(*) FROM dead_letters`).Scan(&dead); err != nil {
+				t.Fatalf("count dead letters: %v", err)
+			}
+			if dead != 0 {
+				t.Errorf("%d events dead-lettered, want none", dead)
+			}
+		})
+	}
+}
+
+// burstyFetcher hands out messages instantly, except that every refillEvery
+// fetches it waits refillDelay, like a reader whose queue has drained and
+// gone back to the broker.
+type burstyFetcher struct {
+	fetched     int64
+	refillEvery int64
+	refillDelay time.Duration
+}
+
+func (f *burstyFetcher) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	f.fetched++
+	if f.fetched%f.refillEvery == 0 {
+		select {
+		case <-time.After(f.refillDelay):
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+	return kafka.Message{Offset: f.fetched}, nil
+}
+
+// spin stands in for applying a message, busy-waiting since sleeps this
+// short are imprecise.
+func spin(d time.Duration) {
+	for start := time.Now(); time.Since(start) < d; {
+	}
+}
+
+// BenchmarkPrefetch compares fetching each message just before processing
+// it with prefetching into buffers of several sizes. Fetches average as long
+// as processing, but arrive in bursts; a large enough buffer hides the
+// stalls between them, roughly halving the time per message.
+func BenchmarkPrefetch(b *testing.B) {
+	const process = 50 * time.Microsecond
+	newFetcher := func() *burstyFetcher {
+		return &burstyFetcher{refillEvery: 100, refillDelay: 100 * process}
+	}
+
+	b.Run("inline", func(b *testing.B) {
+		f := newFetcher()
+		for b.Loop() {
+			if _, err := f.FetchMessage(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+			spin(process)
+		}
+	})
+
+	for _, size := range []int{1, 64, 256} {
+		b.Run(fmt.Sprintf("prefetch=%d", size), func(b *testing.B) {
+			c := &Consumer{config: ConsumerConfig{PrefetchSize: size}, fetchErrLog: logsample.New(0)}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			messages := c.prefetch(ctx, newFetcher())
+
+			for b.Loop() {
+				<-messages
+				spin(process)
+			}
+		})
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	metrics := telemetry.NewMetrics(prometheus.NewRegistry(), telemetry.MetricsOptions{})
+	c := NewConsumer(ConsumerConfig{Brokers: []string{"localhost:9092"}, Topic: "sums"},
+		nil, dedup.NewRepository(nil), dlq.NewRepository(nil), storage.NewPostgresStorage(nil), metrics)
+
+	// The consume loop never ran, so the drain can't complete; a done context
+	// makes Stop give up on it straight away.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("first Stop: %v", err)
+	}
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("second Stop: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// errOffsetBeforeTxCommit is returned when an offset commit is attempted for a
+// message whose database transaction has not committed. Committing the offset
+// in that state would lose the message if the process crashed.
+var errOffsetBeforeTxCommit = errors.New("offset commit attempted before database transaction committed")
+
+// errOffsetOutOfOrder is returned when an offset commit would pass over an
+// earlier message on the same partition that was never committed. Kafka
+// offsets are cumulative, so committing it would silently skip that message.
+var errOffsetOutOfOrder = errors.New("offset commit would skip an earlier uncommitted message")
+
+// delivery ties a Kafka message to the database transaction that applies it.
+// The consumer only commits offsets through commitOffset, which refuses to
+// commit while the transaction is still pending, so the at-least-once
+// guarantee can't be broken by reordering calls in processMessage.
+type delivery struct {
+	msg     kafka.Message
+	tx      pgx.Tx
+	pending bool
+
+	// coalesce has processMessage queue the event for a coalesced batch
+	// instead of applying it, and queued reports that it did. A queued
+	// message's offset is committed by the coalescer once its batch commits.
+	coalesce bool
+	queued   bool
+}
+
+func newDelivery(msg kafka.Message) *delivery {
+	return &delivery{msg: msg}
+}
+
+// begin opens the transaction that applies the message.
+func (d *delivery) begin(ctx context.Context, pool *pgxpool.Pool, opts pgx.TxOptions) (pgx.Tx, error) {
+	tx, err := pool.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	d.tx = tx
+	d.pending = true
+	return tx, nil
+}
+
+// commit commits the transaction. The message's offset becomes committable
+// only once this returns nil.
+func (d *delivery) commit(ctx context.Context) error {
+	if err := d.tx.Commit(ctx); err != nil {
+		return err
+	}
+	d.pending = false
+	return nil
+}
+
+// discard rolls back the transaction for a message that needs no database
+// changes (e.g. a duplicate), making its offset committable.
+func (d *delivery) discard(ctx context.Context) {
+	d.tx.Rollback(ctx)
+	d.pending = false
+}
+
+// rollback releases the transaction if it is still open. Safe to defer.
+func (d *delivery) rollback(ctx context.Context) {
+	if d.tx != nil {
+		d.tx.Rollback(ctx)
+	}
+}
+
+// offsetTracker records, per partition, the offsets of messages handed to the
+// partition workers that have not been committed yet, oldest first. Offsets
+// must be committed in the order they were dispatched.
+type offsetTracker struct {
+	mu      sync.Mutex
+	pending map[int][]int64
+}
+
+// dispatched records that the message at offset was handed to its partition worker.
+func (t *offsetTracker) dispatched(partition int, offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[int][]int64)
+	}
+	t.pending[partition] = append(t.pending[partition], offset)
+}
+
+// settle records that the message at offset is being committed. It fails,
+// leaving the offset pending, if an earlier dispatched offset on the
+// partition has not been settled. Offsets that were never dispatched are
+// let through.
+func (t *offsetTracker) settle(partition int, offset int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending[partition]
+	i := 0
+	for i < len(pending) && pending[i] != offset {
+		i++
+	}
+	if i == len(pending) {
+		return nil
+	}
+	if i > 0 {
+		return fmt.Errorf("partition %d offset %d: %w at offset %d", partition, offset, errOffsetOutOfOrder, pending[0])
+	}
+	t.pending[partition] = pending[1:]
+	return nil
+}
+
+// reset forgets every pending offset, for a consume loop starting afresh.
+func (t *offsetTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = nil
+}
+
+const (
+	// commitRetries is how many times a failed offset commit is retried.
+	commitRetries = 3
+	// commitRetryBackoff is the initial wait between commit attempts, doubled after each one.
+	commitRetryBackoff = 100 * time.Millisecond
+)
+
+// commitOffset commits the delivery's Kafka offset, enforcing that any
+// transaction opened for the message has committed first and that no earlier
+// message on the partition is left uncommitted. Failed commits are retried
+// with backoff up to commitRetries times.
+//
+// A commit that still fails loses no data: the message has already been
+// applied, and the partition worker moves on. Kafka offsets are cumulative,
+// so the next successful commit on the partition covers this one. If none
+// succeeds before a restart or rebalance, the message is redelivered and
+// reprocessed, and dedup skips it.
+func (c *Consumer) commitOffset(ctx context.Context, d *delivery) error {
+	if d.pending {
+		return fmt.Errorf("partition %d offset %d: %w", d.msg.Partition, d.msg.Offset, errOffsetBeforeTxCommit)
+	}
+	if err := c.offsets.settle(d.msg.Partition, d.msg.Offset); err != nil {
+		return err
+	}
+
+	backoff := commitRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.reader.Load().CommitMessages(ctx, d.msg)
+		if err == nil {
+			return nil
+		}
+		c.metrics.KafkaCommitFailures.WithLabelValues(c.topic).Inc()
+
+		// A closed reader won't accept commits, however long we wait
+		if attempt == commitRetries || ctx.Err() != nil || errors.Is(err, io.ErrClosedPipe) {
+			return err
+		}
+		c.processErrLog.Printf("error committing offset %d on partition %d (retry %d of %d in %s): %v",
+			d.msg.Offset, d.msg.Partition, attempt+1, commitRetries, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
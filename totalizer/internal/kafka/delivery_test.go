@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+func TestOffsetTrackerDetectsOutOfOrderCommit(t *testing.T) {
+	var tracker offsetTracker
+	for _, offset := range []int64{10, 11, 12} {
+		tracker.dispatched(0, offset)
+	}
+	tracker.dispatched(1, 5)
+
+	steps := []struct {
+		partition int
+		offset    int64
+		wantErr   error
+	}{
+		{0, 10, nil},
+		// 11 failed and was left uncommitted; 12 must not skip it
+		{0, 12, errOffsetOutOfOrder},
+		// Other partitions are unaffected
+		{1, 5, nil},
+		{0, 11, nil},
+		{0, 12, nil},
+		// Offsets that were never dispatched are let through
+		{0, 99, nil},
+	}
+	for _, step := range steps {
+		err := tracker.settle(step.partition, step.offset)
+		if !errors.Is(err, step.wantErr) {
+			t.Errorf("settle(%d, %d) = %v, want %v", step.partition, step.offset, err, step.wantErr)
+		}
+	}
+}
+
+func TestCommitOffsetRefusesToSkipAnUncommittedMessage(t *testing.T) {
+	c := &Consumer{}
+	c.offsets.dispatched(0, 1)
+	c.offsets.dispatched(0, 2)
+
+	// Offset 1 is never committed, as when it fails processing. The check
+	// fires before the reader is touched, so none is needed.
+	err := c.commitOffset(context.Background(), newDelivery(kafka.Message{Partition: 0, Offset: 2}))
+	if !errors.Is(err, errOffsetOutOfOrder) {
+		t.Fatalf("commitOffset = %v, want %v", err, errOffsetOutOfOrder)
+	}
+}
+
+func TestCommitOffsetRefusesPendingTransaction(t *testing.T) {
+	c := &Consumer{}
+	d := newDelivery(kafka.Message{Partition: 0, Offset: 1})
+	d.pending = true
+
+	err := c.commitOffset(context.Background(), d)
+	if !errors.Is(err, errOffsetBeforeTxCommit) {
+		t.Fatalf("commitOffset = %v, want %v", err, errOffsetBeforeTxCommit)
+	}
+}
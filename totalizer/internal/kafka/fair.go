@@ -0,0 +1,68 @@
+package kafka
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// fairSlots is a MaxInFlight semaphore that hands freed slots to waiting
+// partition workers strictly in the order they started waiting. A worker
+// that releases its slot and asks again queues behind every partition
+// already waiting, so a hot partition can't keep winning the race for slots
+// while lagging partitions wait.
+type fairSlots struct {
+	mu      sync.Mutex
+	free    int
+	waiters []chan struct{}
+}
+
+func newFairSlots(n int) *fairSlots {
+	return &fairSlots{free: n}
+}
+
+// acquire waits for a slot. It returns false without one if ctx is done or
+// stop is closed first.
+func (f *fairSlots) acquire(ctx context.Context, stop <-chan struct{}) bool {
+	f.mu.Lock()
+	if f.free > 0 && len(f.waiters) == 0 {
+		f.free--
+		f.mu.Unlock()
+		return true
+	}
+	// Buffered so release can grant the slot without blocking
+	grant := make(chan struct{}, 1)
+	f.waiters = append(f.waiters, grant)
+	f.mu.Unlock()
+
+	select {
+	case <-grant:
+		return true
+	case <-ctx.Done():
+	case <-stop:
+	}
+
+	f.mu.Lock()
+	if i := slices.Index(f.waiters, grant); i >= 0 {
+		f.waiters = slices.Delete(f.waiters, i, i+1)
+		f.mu.Unlock()
+		return false
+	}
+	f.mu.Unlock()
+	// The slot was granted as we gave up; pass it on
+	f.release()
+	return false
+}
+
+// release hands the slot to the longest-waiting worker, or frees it.
+func (f *fairSlots) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.waiters) == 0 {
+		f.free++
+		return
+	}
+	grant := f.waiters[0]
+	f.waiters = f.waiters[1:]
+	grant <- struct{}{}
+}
@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"context"
+	"log"
+
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+	"github.com/aelhady03/sumflow/totalizer/internal/dedup"
+	"github.com/aelhady03/sumflow/totalizer/internal/ordering"
+	"github.com/jackc/pgx/v5"
+)
+
+// Handler applies a decoded event inside the consumer's transaction.
+type Handler func(ctx context.Context, tx pgx.Tx, event *Event) error
+
+// MessageMiddleware wraps a Handler with cross-cutting behaviour such as
+// validation or bookkeeping. A middleware may stop the chain by returning
+// without calling next; returning an error rolls the transaction back.
+type MessageMiddleware func(next Handler) Handler
+
+// chain wraps h so that mws[0] runs first.
+func chain(h Handler, mws ...MessageMiddleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// DedupMiddleware marks the event as processed, stopping the chain with
+// dedup.ErrEventAlreadyProcessed if it already was.
+func DedupMiddleware(repo *dedup.Repository) MessageMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, tx pgx.Tx, event *Event) error {
+			if err := repo.CheckAndMarkInTx(ctx, tx, event.EventID, event.AggregateType, event.EventType); err != nil {
+				return err
+			}
+			return next(ctx, tx, event)
+		}
+	}
+}
+
+// OrderingMiddleware tracks per-aggregate sequence numbers and reports
+// sequenced events applied out of order. It never stops the chain for ordering alone.
+func OrderingMiddleware(repo *ordering.Repository, metrics *telemetry.Metrics, topic string) MessageMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, tx pgx.Tx, event *Event) error {
+			if event.Sequence <= 0 {
+				return next(ctx, tx, event)
+			}
+
+			result, last, err := repo.TrackInTx(ctx, tx, event.AggregateID, event.Sequence)
+			if err != nil {
+				return err
+			}
+			if result != ordering.InOrder {
+				metrics.EventsOutOfOrder.WithLabelValues(topic, result.String()).Inc()
+				log.Printf("event %s for aggregate %s applied out of order (%s): sequence %d after %d",
+					event.EventID, event.AggregateID, result, event.Sequence, last)
+			}
+			return next(ctx, tx, event)
+		}
+	}
+}
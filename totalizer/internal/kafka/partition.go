@@ -0,0 +1,160 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+const defaultPartitionQueueSize = 64
+
+// dispatch queues a message on its partition's worker, starting the worker on
+// first sight of the partition. It returns false if the consumer is stopping.
+func (c *Consumer) dispatch(ctx context.Context, msg kafka.Message) bool {
+	queue, ok := c.workers[msg.Partition]
+	if !ok {
+		size := c.config.PartitionQueueSize
+		if size <= 0 {
+			size = defaultPartitionQueueSize
+		}
+		queue = make(chan kafka.Message, size)
+		c.workers[msg.Partition] = queue
+
+		c.wg.Add(1)
+		go c.runPartition(ctx, msg.Partition, queue)
+	}
+
+	c.offsets.dispatched(msg.Partition, msg.Offset)
+	select {
+	case queue <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-c.stopCh:
+		return false
+	}
+}
+
+// runPartition processes one partition's messages in order and commits each
+// one independently of the other partitions.
+func (c *Consumer) runPartition(ctx context.Context, partition int, queue <-chan kafka.Message) {
+	defer c.wg.Done()
+
+	for msg := range queue {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		default:
+		}
+
+		if !c.acquire(ctx) {
+			return
+		}
+		open := c.runTurn(ctx, partition, msg, queue)
+		c.release()
+		if !open {
+			return
+		}
+	}
+}
+
+// runTurn handles msg and, under fair scheduling, up to FairQuantum-1 more
+// messages already queued, while holding one in-flight slot. It returns
+// false once the queue is closed or a message was left uncommitted.
+func (c *Consumer) runTurn(ctx context.Context, partition int, msg kafka.Message, queue <-chan kafka.Message) bool {
+	if !c.handle(ctx, partition, msg) {
+		return false
+	}
+	if c.fair == nil {
+		return true
+	}
+
+	for range c.config.FairQuantum - 1 {
+		select {
+		case next, ok := <-queue:
+			if !ok || !c.handle(ctx, partition, next) {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// handle processes a single message and commits it on success, or commits it
+// first and then processes it under at-most-once delivery. A message that
+// can't be processed holds up the partition, so a later offset is never
+// committed past it. It returns false if the message was left uncommitted
+// because the consumer is stopping; the partition must not move on after
+// that.
+func (c *Consumer) handle(ctx context.Context, partition int, msg kafka.Message) bool {
+	c.inFlightCount.Add(1)
+	defer c.inFlightCount.Add(-1)
+	c.metrics.KafkaPartitionMessagesProcessed.WithLabelValues(c.topic, strconv.Itoa(partition)).Inc()
+
+	if c.config.Delivery == AtMostOnce {
+		if err := c.commitOffset(ctx, newDelivery(msg)); err != nil {
+			// Not committed, so processing now could apply the message twice
+			c.processErrLog.Printf("error committing message on partition %d: %v", partition, err)
+			return true
+		}
+		if _, err := c.processWithRetry(ctx, msg); err != nil {
+			c.processErrLog.Printf("error processing message on partition %d, dropping it (at-most-once): %v", partition, err)
+		}
+		return true
+	}
+
+	if c.coalescer != nil {
+		return c.coalesce(ctx, msg)
+	}
+
+	d, ok := c.processOrHold(ctx, msg)
+	if !ok {
+		return false
+	}
+	if err := c.commitOffset(ctx, d); err != nil {
+		c.processErrLog.Printf("error committing message on partition %d: %v", partition, err)
+	}
+	return true
+}
+
+// stopPartitionWorkers closes every partition queue and waits for the workers to exit.
+func (c *Consumer) stopPartitionWorkers() {
+	for partition, queue := range c.workers {
+		close(queue)
+		delete(c.workers, partition)
+	}
+	c.wg.Wait()
+}
+
+// acquire takes a slot from the MaxInFlight semaphore, if one is configured.
+func (c *Consumer) acquire(ctx context.Context) bool {
+	if c.fair != nil {
+		return c.fair.acquire(ctx, c.stopCh)
+	}
+	if c.inFlight == nil {
+		return true
+	}
+	select {
+	case c.inFlight <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-c.stopCh:
+		return false
+	}
+}
+
+func (c *Consumer) release() {
+	if c.fair != nil {
+		c.fair.release()
+		return
+	}
+	if c.inFlight != nil {
+		<-c.inFlight
+	}
+}
@@ -0,0 +1,46 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PayloadType registers an event type with the consumer: its payload is
+// decoded into a value from New and passed to Handle, so each event type
+// gets a typed payload without its own unmarshalling code.
+type PayloadType struct {
+	// New returns a pointer to a zero payload for the event's JSON to be decoded into.
+	New func() any
+	// Handle applies the decoded payload inside the consumer's transaction.
+	// Returning an error wrapping ErrInvalidPayload dead-letters the event.
+	Handle func(ctx context.Context, tx pgx.Tx, event *Event, payload any) error
+}
+
+// handler returns a Handler that decodes the event's payload and passes it to Handle.
+func (p PayloadType) handler(eventType string) Handler {
+	return func(ctx context.Context, tx pgx.Tx, event *Event) error {
+		if !event.IsJSON() {
+			return fmt.Errorf("%w: %s with unsupported content type %q", ErrInvalidPayload, eventType, event.ContentType)
+		}
+		payload := p.New()
+		if err := json.Unmarshal(event.Payload, payload); err != nil {
+			return fmt.Errorf("%w: %v", errMalformedPayload, err)
+		}
+		return p.Handle(ctx, tx, event, payload)
+	}
+}
+
+// eventHandlers returns the handler for each event type the consumer
+// applies. sum.calculated keeps its own decoding, which honours
+// StrictPayloads; registered types can't replace it.
+func (c *Consumer) eventHandlers() map[string]Handler {
+	handlers := make(map[string]Handler, len(c.config.PayloadTypes)+1)
+	for eventType, p := range c.config.PayloadTypes {
+		handlers[eventType] = p.handler(eventType)
+	}
+	handlers["sum.calculated"] = c.handleSumCalculated
+	return handlers
+}
@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// refundPayload is a custom event type's payload.
+type refundPayload struct {
+	OrderID string `json:"order_id"`
+	Amount  int64  `json:"amount"`
+}
+
+// newPayloadConsumer returns a consumer whose handlers include types.
+func newPayloadConsumer(types map[string]PayloadType) *Consumer {
+	c := &Consumer{config: ConsumerConfig{PayloadTypes: types}, logger: slog.Default()}
+	c.handlers = c.eventHandlers()
+	return c
+}
+
+func TestRegisteredPayloadTypeIsDecodedAndHandled(t *testing.T) {
+	var got *refundPayload
+	c := newPayloadConsumer(map[string]PayloadType{
+		"refund.issued": {
+			New: func() any { return &refundPayload{} },
+			Handle: func(ctx context.Context, tx pgx.Tx, event *Event, payload any) error {
+				got = payload.(*refundPayload)
+				return nil
+			},
+		},
+	})
+
+	event := &Event{EventType: "refund.issued", Payload: json.RawMessage(`{"order_id":"o-1","amount":42}`)}
+	if err := c.handleEvent(context.Background(), nil, event); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if got == nil || *got != (refundPayload{OrderID: "o-1", Amount: 42}) {
+		t.Errorf("handled payload %+v, want order o-1 for 42", got)
+	}
+}
+
+func TestRegisteredPayloadTypeRejectsBadPayloads(t *testing.T) {
+	c := newPayloadConsumer(map[string]PayloadType{
+		"refund.issued": {
+			New: func() any { return &refundPayload{} },
+			Handle: func(ctx context.Context, tx pgx.Tx, event *Event, payload any) error {
+				t.Error("handler called for a bad payload")
+				return nil
+			},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		event   *Event
+		wantErr error
+	}{
+		{"malformed", &Event{EventType: "refund.issued", Payload: json.RawMessage(`{"amount":"lots"}`)}, errMalformedPayload},
+		{"binary", &Event{EventType: "refund.issued", ContentType: "application/x-protobuf", Data: []byte{1}}, ErrInvalidPayload},
+		{"unregistered", &Event{EventType: "refund.voided", Payload: json.RawMessage(`{}`)}, ErrInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.handleEvent(context.Background(), nil, tt.event); !errors.Is(err, tt.wantErr) {
+				t.Errorf("handle = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisteredPayloadTypeCannotReplaceSumCalculated(t *testing.T) {
+	c := newPayloadConsumer(map[string]PayloadType{
+		"sum.calculated": {
+			New: func() any { return &refundPayload{} },
+			Handle: func(ctx context.Context, tx pgx.Tx, event *Event, payload any) error {
+				t.Error("registered handler replaced sum.calculated")
+				return nil
+			},
+		},
+	})
+
+	// The built-in handler rejects the binary event before touching the transaction
+	event := &Event{EventType: "sum.calculated", ContentType: "application/x-protobuf", Data: []byte{1}}
+	if err := c.handleEvent(context.Background(), nil, event); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("handle = %v, want %v from the built-in handler", err, ErrInvalidPayload)
+	}
+}
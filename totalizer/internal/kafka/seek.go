@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// ErrConsumerStopping is returned by Seek once the consumer is shutting down.
+var ErrConsumerStopping = errors.New("consumer is stopping")
+
+// SeekResult reports a partition's committed offset before and after a seek.
+// Before is -1 if the group had no committed offset for the partition.
+type SeekResult struct {
+	Partition int   `json:"partition"`
+	Before    int64 `json:"before"`
+	After     int64 `json:"after"`
+}
+
+// Seek moves the consumer group's position on a partition to offset, or, if
+// at is non-zero, to the first message at or after that time. Messages
+// skipped over are never applied, and messages sought back to are applied
+// again unless dedup still remembers them.
+//
+// The consumer group's offsets can only be committed through a group member,
+// so Seek quiesces the consumer, commits offset-1 through the current reader,
+// and replaces the reader with a new one that rejoins the group and resumes
+// from the committed offset. A consumer that was already quiesced stays
+// paused with the new reader. Another group member that owns the partition
+// may commit over the seek; run it with a single replica, or quiesce the others first.
+func (c *Consumer) Seek(ctx context.Context, partition int, offset int64, at time.Time) (*SeekResult, error) {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	select {
+	case <-c.stopCh:
+		return nil, ErrConsumerStopping
+	default:
+	}
+
+	client := &kafka.Client{Addr: kafka.TCP(c.config.Brokers...)}
+
+	if !at.IsZero() {
+		var err error
+		offset, err = c.offsetAt(ctx, client, partition, at)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wasQuiesced := c.quiesced.Load()
+	if err := c.quiesce(ctx); err != nil {
+		return nil, err
+	}
+
+	before, err := c.committedOffset(ctx, client, partition)
+	if err != nil {
+		return nil, c.resume(wasQuiesced, err)
+	}
+
+	reader := c.reader.Load()
+	seek := kafka.Message{Topic: c.topic, Partition: partition, Offset: offset - 1}
+	if err := reader.CommitMessages(ctx, seek); err != nil {
+		return nil, c.resume(wasQuiesced, fmt.Errorf("commit offset %d on partition %d: %w", offset, partition, err))
+	}
+
+	// Closing the reader flushes the commit and leaves the group
+	if err := reader.Close(); err != nil {
+		log.Printf("error closing reader after seek: %v", err)
+	}
+	c.reader.Store(newReader(c.config))
+	log.Printf("consumer seeked partition %d from offset %d to %d", partition, before, offset)
+
+	return &SeekResult{Partition: partition, Before: before, After: offset}, c.resume(wasQuiesced, nil)
+}
+
+// resume restarts the consume loop after a seek unless the consumer was
+// quiesced beforehand, and passes err through. The caller holds lifecycle.
+func (c *Consumer) resume(wasQuiesced bool, err error) error {
+	if wasQuiesced {
+		return err
+	}
+	c.done = make(chan struct{})
+	c.quiesced.Store(false)
+	c.start()
+	return err
+}
+
+// offsetAt returns the offset of the first message on partition at or after t.
+func (c *Consumer) offsetAt(ctx context.Context, client *kafka.Client, partition int, t time.Time) (int64, error) {
+	resp, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{c.topic: {kafka.TimeOffsetOf(partition, t)}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range resp.Topics[c.topic] {
+		if p.Error != nil {
+			return 0, p.Error
+		}
+		for offset := range p.Offsets {
+			return offset, nil
+		}
+	}
+	return 0, fmt.Errorf("no message on partition %d at or after %s", partition, t.Format(time.RFC3339))
+}
+
+// committedOffset returns the group's committed offset for partition.
+func (c *Consumer) committedOffset(ctx context.Context, client *kafka.Client, partition int) (int64, error) {
+	resp, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: c.config.GroupID,
+		Topics:  map[string][]int{c.topic: {partition}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != nil {
+		return 0, resp.Error
+	}
+
+	for _, p := range resp.Topics[c.topic] {
+		if p.Error != nil {
+			return 0, p.Error
+		}
+		return p.CommittedOffset, nil
+	}
+	return -1, nil
+}
@@ -0,0 +1,70 @@
+package ordering
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Result describes how an event's sequence relates to what was already applied for its aggregate.
+type Result int
+
+const (
+	InOrder Result = iota
+	// Late means an event with a higher sequence was already applied.
+	Late
+	// Gap means one or more earlier sequences have not been applied yet.
+	Gap
+)
+
+func (r Result) String() string {
+	switch r {
+	case Late:
+		return "late"
+	case Gap:
+		return "gap"
+	default:
+		return "in_order"
+	}
+}
+
+type Repository struct {
+	pool *pgxpool.Pool
+}
+
+func NewRepository(pool *pgxpool.Pool) *Repository {
+	return &Repository{pool: pool}
+}
+
+// TrackInTx records that sequence seq was applied for an aggregate and reports
+// whether it arrived in order. Must be called within the apply transaction so
+// the recorded progress rolls back with it.
+func (r *Repository) TrackInTx(ctx context.Context, tx pgx.Tx, aggregateID string, seq int64) (Result, int64, error) {
+	var last int64
+	query := `SELECT last_sequence FROM aggregate_progress WHERE aggregate_id = $1 FOR UPDATE`
+	err := tx.QueryRow(ctx, query, aggregateID).Scan(&last)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return InOrder, 0, err
+	}
+
+	upsert := `
+		INSERT INTO aggregate_progress (aggregate_id, last_sequence)
+		VALUES ($1, $2)
+		ON CONFLICT (aggregate_id) DO UPDATE
+		SET last_sequence = GREATEST(aggregate_progress.last_sequence, EXCLUDED.last_sequence)
+	`
+	if _, err := tx.Exec(ctx, upsert, aggregateID, seq); err != nil {
+		return InOrder, 0, err
+	}
+
+	switch {
+	case seq <= last:
+		return Late, last, nil
+	case seq > last+1:
+		return Gap, last, nil
+	default:
+		return InOrder, last, nil
+	}
+}
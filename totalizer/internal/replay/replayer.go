@@ -0,0 +1,242 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aelhady03/sumflow/totalizer/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// HeaderReplayID is the Kafka header carrying the ID of the replay that
+// re-emitted an event, so downstream consumers can tell replays from live events.
+const HeaderReplayID = "replay-id"
+
+// batchSize is how many history rows are published between progress saves.
+const batchSize = 500
+
+var (
+	ErrNotFound = errors.New("replay not found")
+	ErrRunning  = errors.New("replay is already running")
+	ErrMismatch = errors.New("replay exists with a different topic or time range")
+)
+
+// Publisher writes messages to Kafka. A *kafka.Writer without a Topic satisfies it.
+type Publisher interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Request describes a replay of the history applied in [From, To) to Topic.
+// Starting a request with the ID of an earlier one resumes it.
+type Request struct {
+	ID    string    `json:"id"`
+	Topic string    `json:"topic"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+}
+
+// Progress is the persisted state of a replay.
+type Progress struct {
+	ID            string     `json:"id"`
+	Topic         string     `json:"topic"`
+	From          time.Time  `json:"from"`
+	To            time.Time  `json:"to"`
+	LastHistoryID int64      `json:"last_history_id"`
+	Replayed      int64      `json:"replayed"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Running       bool       `json:"running"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// Replayer re-publishes the sum_history rows of applied events as
+// sum.calculated events so downstream systems can be backfilled from the
+// totalizer's audit log.
+// Replayed events keep their original event IDs, so consumers that
+// deduplicate by ID absorb rows published twice after a resume.
+type Replayer struct {
+	pool      *pgxpool.Pool
+	storage   *storage.PostgresStorage
+	publisher Publisher
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]bool
+	lastErr map[string]error
+}
+
+func NewReplayer(pool *pgxpool.Pool, storage *storage.PostgresStorage, publisher Publisher) *Replayer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Replayer{
+		pool:      pool,
+		storage:   storage,
+		publisher: publisher,
+		ctx:       ctx,
+		cancel:    cancel,
+		running:   make(map[string]bool),
+		lastErr:   make(map[string]error),
+	}
+}
+
+// Start begins or resumes a replay in the background and returns its progress.
+// A completed replay is returned as is.
+func (r *Replayer) Start(ctx context.Context, req Request) (*Progress, error) {
+	query := `
+		INSERT INTO history_replays (id, topic, from_time, to_time)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING
+	`
+	if _, err := r.pool.Exec(ctx, query, req.ID, req.Topic, req.From, req.To); err != nil {
+		return nil, err
+	}
+
+	progress, err := r.Progress(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if progress.Topic != req.Topic || !progress.From.Equal(req.From) || !progress.To.Equal(req.To) {
+		return nil, ErrMismatch
+	}
+	if progress.CompletedAt != nil {
+		return progress, nil
+	}
+
+	r.mu.Lock()
+	if r.running[req.ID] {
+		r.mu.Unlock()
+		return nil, ErrRunning
+	}
+	r.running[req.ID] = true
+	delete(r.lastErr, req.ID)
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go r.run(*progress)
+
+	progress.Running = true
+	progress.Error = ""
+	return progress, nil
+}
+
+// Progress returns the state of a replay.
+func (r *Replayer) Progress(ctx context.Context, id string) (*Progress, error) {
+	query := `
+		SELECT id, topic, from_time, to_time, last_history_id, replayed, completed_at, updated_at
+		FROM history_replays
+		WHERE id = $1
+	`
+	var p Progress
+	err := r.pool.QueryRow(ctx, query, id).Scan(&p.ID, &p.Topic, &p.From, &p.To, &p.LastHistoryID, &p.Replayed, &p.CompletedAt, &p.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	p.Running = r.running[id]
+	if err := r.lastErr[id]; err != nil {
+		p.Error = err.Error()
+	}
+	r.mu.Unlock()
+	return &p, nil
+}
+
+// Stop cancels running replays and waits for them to save their progress.
+func (r *Replayer) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *Replayer) run(p Progress) {
+	defer r.wg.Done()
+
+	err := r.replay(r.ctx, &p)
+	if err != nil {
+		log.Printf("history replay %s stopped after %d events: %v", p.ID, p.Replayed, err)
+	} else {
+		log.Printf("history replay %s completed: %d events to %s", p.ID, p.Replayed, p.Topic)
+	}
+
+	r.mu.Lock()
+	delete(r.running, p.ID)
+	if err != nil {
+		r.lastErr[p.ID] = err
+	}
+	r.mu.Unlock()
+}
+
+// replay publishes the remaining rows batch by batch, saving progress after
+// each batch is written. Only rows of applied events are published: seeds
+// and expirations carry generated event IDs that downstream dedup can't
+// recognize, so republishing them would apply them twice.
+func (r *Replayer) replay(ctx context.Context, p *Progress) error {
+	for {
+		entries, err := r.storage.HistoryRange(ctx, p.From, p.To, p.LastHistoryID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			_, err := r.pool.Exec(ctx, `UPDATE history_replays SET completed_at = NOW(), updated_at = NOW() WHERE id = $1`, p.ID)
+			return err
+		}
+
+		msgs := make([]kafka.Message, 0, len(entries))
+		for _, e := range entries {
+			msg, err := r.message(p, e)
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, msg)
+		}
+		if err := r.publisher.WriteMessages(ctx, msgs...); err != nil {
+			return fmt.Errorf("publish replayed events: %w", err)
+		}
+
+		p.LastHistoryID = entries[len(entries)-1].ID
+		p.Replayed += int64(len(entries))
+		query := `
+			UPDATE history_replays
+			SET last_history_id = $1, replayed = $2, updated_at = NOW()
+			WHERE id = $3
+		`
+		if _, err := r.pool.Exec(ctx, query, p.LastHistoryID, p.Replayed, p.ID); err != nil {
+			return err
+		}
+	}
+}
+
+// message builds the sum.calculated event for a history row. History only
+// records the applied value, so the payload carries the result without its operands.
+func (r *Replayer) message(p *Progress, e storage.HistoryEntry) (kafka.Message, error) {
+	value, err := json.Marshal(map[string]any{
+		"event_id":       e.EventID,
+		"aggregate_type": "sum",
+		"aggregate_id":   e.EventID.String(),
+		"event_type":     "sum.calculated",
+		"payload":        map[string]int64{"result": e.Value},
+		"created_at":     e.EventCreatedAt.UTC(),
+		"replay_id":      p.ID,
+	})
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	return kafka.Message{
+		Topic:   p.Topic,
+		Key:     []byte(e.EventID.String()),
+		Value:   value,
+		Headers: []kafka.Header{{Key: HeaderReplayID, Value: []byte(p.ID)}},
+	}, nil
+}
@@ -1,11 +1,24 @@
 package service
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aelhady03/sumflow/totalizer/internal/data"
 	"github.com/aelhady03/sumflow/totalizer/internal/storage"
 )
 
 type TotalizerService struct {
 	storage *storage.PostgresStorage
+
+	cacheTTL time.Duration
+	cacheMu  sync.Mutex
+	cached   *data.Result
+	expires  time.Time
+	// changes is bumped by TotalChanged so a load that raced with an apply
+	// doesn't cache a result older than the invalidation.
+	changes uint64
 }
 
 func NewTotalizerService(storage *storage.PostgresStorage) *TotalizerService {
@@ -14,6 +27,90 @@ func NewTotalizerService(storage *storage.PostgresStorage) *TotalizerService {
 	}
 }
 
-func (t *TotalizerService) Get() (int, error) {
+func (t *TotalizerService) Get() (int64, error) {
 	return t.storage.Load()
 }
+
+func (t *TotalizerService) GetContext(ctx context.Context) (int64, error) {
+	return t.storage.LoadContext(ctx)
+}
+
+// EnableResultCache serves GetResult from memory for up to ttl between loads.
+// The consumer invalidates the cache through TotalChanged on every applied
+// event; changes applied by other replicas are picked up once ttl expires.
+func (t *TotalizerService) EnableResultCache(ttl time.Duration) {
+	t.cacheTTL = ttl
+}
+
+// GetResult returns the total along with the most recent change applied to
+// it, from the result cache when it is enabled and fresh.
+func (t *TotalizerService) GetResult(ctx context.Context) (*data.Result, error) {
+	if t.cacheTTL <= 0 {
+		return t.storage.LoadResult(ctx)
+	}
+
+	t.cacheMu.Lock()
+	if t.cached != nil && time.Now().Before(t.expires) {
+		result := *t.cached
+		t.cacheMu.Unlock()
+		return &result, nil
+	}
+	changes := t.changes
+	t.cacheMu.Unlock()
+
+	return t.load(ctx, changes)
+}
+
+// GetFreshResult reads the result from the database, bypassing and refreshing the cache.
+func (t *TotalizerService) GetFreshResult(ctx context.Context) (*data.Result, error) {
+	t.cacheMu.Lock()
+	changes := t.changes
+	t.cacheMu.Unlock()
+
+	return t.load(ctx, changes)
+}
+
+func (t *TotalizerService) load(ctx context.Context, changes uint64) (*data.Result, error) {
+	result, err := t.storage.LoadResult(ctx)
+	if err != nil || t.cacheTTL <= 0 {
+		return result, err
+	}
+
+	t.cacheMu.Lock()
+	defer t.cacheMu.Unlock()
+	if t.changes == changes {
+		cached := *result
+		t.cached = &cached
+		t.expires = time.Now().Add(t.cacheTTL)
+	}
+	return result, nil
+}
+
+// TotalChanged invalidates the result cache. The consumer calls it after each
+// applied event commits.
+func (t *TotalizerService) TotalChanged() {
+	t.cacheMu.Lock()
+	defer t.cacheMu.Unlock()
+	t.changes++
+	t.cached = nil
+}
+
+// GetByType returns the total contributed by events of a single type.
+func (t *TotalizerService) GetByType(ctx context.Context, eventType string) (int64, error) {
+	return t.storage.LoadTypeTotal(ctx, eventType)
+}
+
+// GetStats returns the total, applied event count and stream checksum.
+func (t *TotalizerService) GetStats(ctx context.Context) (*data.Stats, error) {
+	return t.storage.LoadStats(ctx)
+}
+
+// GetKeyedTotals returns up to limit active keys and their totals.
+func (t *TotalizerService) GetKeyedTotals(ctx context.Context, limit int) ([]storage.KeyedTotal, error) {
+	return t.storage.LoadKeyedTotals(ctx, limit)
+}
+
+// ExportHistory streams up to limit history entries to fn in the order they were applied.
+func (t *TotalizerService) ExportHistory(ctx context.Context, limit int, fn func(storage.HistoryEntry) error) error {
+	return t.storage.StreamHistory(ctx, limit, fn)
+}
@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aelhady03/sumflow/totalizer/internal/storage"
+)
+
+type Config struct {
+	Interval  time.Duration
+	Retention time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:  time.Hour,
+		Retention: 30 * 24 * time.Hour, // 30 days
+	}
+}
+
+// Snapshotter periodically checkpoints the total and truncates history the
+// checkpoint covers, so sum_history stays bounded while the total can still
+// be rebuilt as latest snapshot + history since.
+type Snapshotter struct {
+	storage *storage.PostgresStorage
+	config  Config
+	stopCh  chan struct{}
+}
+
+func NewSnapshotter(storage *storage.PostgresStorage, config Config) *Snapshotter {
+	return &Snapshotter{
+		storage: storage,
+		config:  config,
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start begins the snapshot background loop
+func (s *Snapshotter) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+// Stop signals the snapshotter to stop
+func (s *Snapshotter) Stop() {
+	close(s.stopCh)
+}
+
+func (s *Snapshotter) run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.snapshotAndTruncate(ctx)
+		}
+	}
+}
+
+func (s *Snapshotter) snapshotAndTruncate(ctx context.Context) {
+	snap, err := s.storage.TakeSnapshot(ctx)
+	if err != nil {
+		log.Printf("snapshot error: %v", err)
+		return
+	}
+	log.Printf("snapshot %d: total %d through history id %d", snap.ID, snap.Total, snap.HistoryID)
+
+	deleted, err := s.storage.TruncateHistory(ctx, s.config.Retention)
+	if err != nil {
+		log.Printf("history truncation error: %v", err)
+	} else if deleted > 0 {
+		log.Printf("history truncation: deleted %d rows", deleted)
+	}
+}
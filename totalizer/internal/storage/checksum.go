@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aelhady03/sumflow/totalizer/internal/data"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ChainChecksumInTx extends the stream checksum with an applied event:
+//
+//	checksum = sha256(previous || event_id || value)
+//
+// where previous is empty for the first event, event_id is the 16 raw UUID
+// bytes and value is the applied value as an 8-byte big-endian integer. Call
+// it after AddToTotalInTx, which creates the totals row.
+func (p *PostgresStorage) ChainChecksumInTx(ctx context.Context, tx pgx.Tx, eventID uuid.UUID, value int64) error {
+	link := make([]byte, 0, 24)
+	link = append(link, eventID[:]...)
+	link = binary.BigEndian.AppendUint64(link, uint64(value))
+
+	query := `UPDATE totals SET stream_checksum = sha256(COALESCE(stream_checksum, ''::bytea) || $1) WHERE id = 1`
+	_, err := tx.Exec(ctx, query, link)
+	return err
+}
+
+// LoadStats returns the total, the number of applied events and the stream checksum.
+func (p *PostgresStorage) LoadStats(ctx context.Context) (*data.Stats, error) {
+	var stats data.Stats
+	var checksum []byte
+	query := `SELECT total, applied_count, stream_checksum FROM totals WHERE id = 1`
+	err := p.pool.QueryRow(ctx, query).Scan(&stats.Total, &stats.Count, &checksum)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if checksum != nil {
+		s := hex.EncodeToString(checksum)
+		stats.StreamChecksum = &s
+	}
+	return &stats, nil
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Snapshot is a checkpoint of the total covering every sum_history row up to HistoryID.
+type Snapshot struct {
+	ID           int64
+	Total        int64
+	HistoryID    int64
+	MaxAppliedAt *time.Time
+	TakenAt      time.Time
+}
+
+// History row sources. Only rows from events have an event behind them;
+// the others adjust the total directly and carry a generated event ID.
+const (
+	HistorySourceEvent  = "event"
+	HistorySourceSeed   = "seed"
+	HistorySourceExpiry = "expiry"
+)
+
+// HistoryEntry is a single value applied to the total.
+type HistoryEntry struct {
+	ID             int64
+	EventID        uuid.UUID
+	Value          int64
+	EventCreatedAt time.Time
+	AppliedAt      time.Time
+	// KafkaPartition and KafkaOffset locate the message that produced the
+	// entry, when recorded.
+	KafkaPartition *int
+	KafkaOffset    *int64
+	// Source is what wrote the entry, one of the HistorySource constants.
+	// Empty means HistorySourceEvent.
+	Source string
+}
+
+// RecordHistoryInTx appends an applied value to sum_history within a transaction.
+// It must run after AddToTotalInTx in the same transaction: holding the totals
+// row lock while inserting is what lets TakeSnapshot see a consistent history boundary.
+// ID and AppliedAt are assigned by the database.
+func (p *PostgresStorage) RecordHistoryInTx(ctx context.Context, tx pgx.Tx, entry HistoryEntry) error {
+	source := entry.Source
+	if source == "" {
+		source = HistorySourceEvent
+	}
+	query := `
+		INSERT INTO sum_history (event_id, value, event_created_at, kafka_partition, kafka_offset, source)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := tx.Exec(ctx, query, entry.EventID, entry.Value, entry.EventCreatedAt, entry.KafkaPartition, entry.KafkaOffset, source)
+	return err
+}
+
+// TakeSnapshot records the current total together with the last history row it covers.
+func (p *PostgresStorage) TakeSnapshot(ctx context.Context) (*Snapshot, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the totals row so no apply can commit between reading the total
+	// and the history boundary. A deleted row is recreated at zero, as the
+	// next apply would, so there is always a row to lock.
+	if err := p.LockTotalInTx(ctx, tx); err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	err = tx.QueryRow(ctx, `SELECT total FROM totals WHERE id = 1`).Scan(&snap.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0), MAX(applied_at) FROM sum_history`).Scan(&snap.HistoryID, &snap.MaxAppliedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO total_snapshots (total, history_id, max_applied_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, taken_at
+	`
+	err = tx.QueryRow(ctx, query, snap.Total, snap.HistoryID, snap.MaxAppliedAt).Scan(&snap.ID, &snap.TakenAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// LatestSnapshot returns the most recent snapshot, or nil if none has been taken.
+func (p *PostgresStorage) LatestSnapshot(ctx context.Context) (*Snapshot, error) {
+	query := `
+		SELECT id, total, history_id, max_applied_at, taken_at
+		FROM total_snapshots
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	var snap Snapshot
+	err := p.pool.QueryRow(ctx, query).Scan(&snap.ID, &snap.Total, &snap.HistoryID, &snap.MaxAppliedAt, &snap.TakenAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// RebuildTotalFromHistory recomputes the total as the latest snapshot plus all history recorded after it.
+func (p *PostgresStorage) RebuildTotalFromHistory(ctx context.Context) (int64, error) {
+	snap, err := p.LatestSnapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var base int64
+	var after int64
+	if snap != nil {
+		base = snap.Total
+		after = snap.HistoryID
+	}
+
+	var sum int64
+	query := `SELECT COALESCE(SUM(value), 0) FROM sum_history WHERE id > $1`
+	if err := p.pool.QueryRow(ctx, query, after).Scan(&sum); err != nil {
+		return 0, err
+	}
+	return base + sum, nil
+}
+
+// StreamHistory calls fn for up to limit history rows in id order, reading them
+// from the server as fn consumes them rather than loading the whole table.
+// An error from fn stops the stream and is returned.
+func (p *PostgresStorage) StreamHistory(ctx context.Context, limit int, fn func(HistoryEntry) error) error {
+	query := `
+		SELECT id, event_id, value, event_created_at, applied_at, kafka_partition, kafka_offset, source
+		FROM sum_history
+		ORDER BY id ASC
+		LIMIT $1
+	`
+	rows, err := p.pool.Query(ctx, query, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.ID, &e.EventID, &e.Value, &e.EventCreatedAt, &e.AppliedAt, &e.KafkaPartition, &e.KafkaOffset, &e.Source); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// HistoryRange returns up to limit history rows of applied events in
+// [from, to) with an id above afterID, in id order, for paging through a time
+// range. Seeds, expirations and other rows no event stands behind are left
+// out.
+func (p *PostgresStorage) HistoryRange(ctx context.Context, from, to time.Time, afterID int64, limit int) ([]HistoryEntry, error) {
+	query := `
+		SELECT id, event_id, value, event_created_at, applied_at, kafka_partition, kafka_offset, source
+		FROM sum_history
+		WHERE applied_at >= $1 AND applied_at < $2 AND id > $3 AND source = 'event'
+		ORDER BY id ASC
+		LIMIT $4
+	`
+	rows, err := p.pool.Query(ctx, query, from, to, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.ID, &e.EventID, &e.Value, &e.EventCreatedAt, &e.AppliedAt, &e.KafkaPartition, &e.KafkaOffset, &e.Source); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// TruncateHistory deletes history rows that are covered by the latest snapshot
+// and older than the retention period. Rows not yet covered by a snapshot are never deleted.
+func (p *PostgresStorage) TruncateHistory(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `
+		DELETE FROM sum_history
+		WHERE id <= (SELECT COALESCE(MAX(history_id), 0) FROM total_snapshots)
+		AND applied_at < $1
+	`
+	cutoff := time.Now().UTC().Add(-retention)
+	result, err := p.pool.Exec(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// SeedTotal sets the total to seed if nothing has been applied yet: the
+// total is zero and sum_history is empty. The seed is also recorded as a
+// history row marked HistorySourceSeed, so history rebuilds and verify-total
+// account for it but replays don't publish it as an event. It
+// reports whether the total was seeded; an active total is never overwritten.
+func (p *PostgresStorage) SeedTotal(ctx context.Context, seed int64) (bool, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the totals row so no apply can commit while we check and seed
+	var total int64
+	var applied int64
+	err = tx.QueryRow(ctx, `SELECT total, applied_count FROM totals WHERE id = 1 FOR UPDATE`).Scan(&total, &applied)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, err
+	}
+
+	var hasHistory bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sum_history)`).Scan(&hasHistory); err != nil {
+		return false, err
+	}
+	if total != 0 || applied != 0 || hasHistory {
+		return false, nil
+	}
+
+	query := `
+		INSERT INTO totals (id, total) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET total = EXCLUDED.total, updated_at = NOW()
+	`
+	if _, err := tx.Exec(ctx, query, seed); err != nil {
+		return false, err
+	}
+	entry := HistoryEntry{EventID: uuid.New(), Value: seed, EventCreatedAt: time.Now(), Source: HistorySourceSeed}
+	if err := p.RecordHistoryInTx(ctx, tx, entry); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// KeyedTotal is one key's contribution to the total while it is active.
+type KeyedTotal struct {
+	Key       string    `json:"key"`
+	Total     int64     `json:"total"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AddToKeyedTotalInTx adds value to key's total within a transaction and
+// pushes the key's expiry back to ttl from now. Call it after AddToTotalInTx,
+// so it locks the totals row before the key's row like ExpireKeys does.
+func (p *PostgresStorage) AddToKeyedTotalInTx(ctx context.Context, tx pgx.Tx, key string, value int64, ttl time.Duration) error {
+	query := `
+		INSERT INTO keyed_totals (key, total, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE
+		SET total = keyed_totals.total + EXCLUDED.total,
+			expires_at = EXCLUDED.expires_at,
+			updated_at = NOW()
+	`
+	_, err := tx.Exec(ctx, query, key, value, time.Now().Add(ttl))
+	return err
+}
+
+// ExpireKeys removes the keys whose TTL has passed and subtracts their totals
+// from the total, recording the subtraction as a history row marked
+// HistorySourceExpiry so history rebuilds and verify-total account for it but
+// replays don't publish it as an event. It returns how many keys expired and
+// the amount subtracted.
+func (p *PostgresStorage) ExpireKeys(ctx context.Context) (int, int64, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the totals row first, in the same order as the consumer's applies
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM totals WHERE id = 1 FOR UPDATE`); err != nil {
+		return 0, 0, err
+	}
+
+	var expired int
+	var sum int64
+	query := `
+		WITH expired AS (
+			DELETE FROM keyed_totals WHERE expires_at <= $1 RETURNING total
+		)
+		SELECT COUNT(*), COALESCE(SUM(total), 0) FROM expired
+	`
+	if err := tx.QueryRow(ctx, query, time.Now()).Scan(&expired, &sum); err != nil {
+		return 0, 0, err
+	}
+	if expired == 0 {
+		return 0, 0, nil
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE totals SET total = total - $1, updated_at = NOW() WHERE id = 1`, sum); err != nil {
+		return 0, 0, err
+	}
+	entry := HistoryEntry{EventID: uuid.New(), Value: -sum, EventCreatedAt: time.Now(), Source: HistorySourceExpiry}
+	if err := p.RecordHistoryInTx(ctx, tx, entry); err != nil {
+		return 0, 0, err
+	}
+
+	return expired, sum, tx.Commit(ctx)
+}
+
+// LoadKeyedTotals returns up to limit active keys, soonest to expire first.
+func (p *PostgresStorage) LoadKeyedTotals(ctx context.Context, limit int) ([]KeyedTotal, error) {
+	query := `
+		SELECT key, total, expires_at, updated_at
+		FROM keyed_totals
+		WHERE expires_at > NOW()
+		ORDER BY expires_at, key
+		LIMIT $1
+	`
+	rows, err := p.pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (KeyedTotal, error) {
+		var k KeyedTotal
+		err := row.Scan(&k.Key, &k.Total, &k.ExpiresAt, &k.UpdatedAt)
+		return k, err
+	})
+}
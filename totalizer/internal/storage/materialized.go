@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaterializeInTx stores value as the latest value for key within a
+// transaction and returns the change from the key's previous value (or from
+// zero for a new key), so the caller can apply it to the running totals.
+func (p *PostgresStorage) MaterializeInTx(ctx context.Context, tx pgx.Tx, key string, eventID uuid.UUID, value int64) (int64, error) {
+	var previous int64
+	err := tx.QueryRow(ctx, `SELECT value FROM materialized_values WHERE key = $1 FOR UPDATE`, key).Scan(&previous)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	query := `
+		INSERT INTO materialized_values (key, value, last_event_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE
+		SET value = EXCLUDED.value,
+			last_event_id = EXCLUDED.last_event_id,
+			updated_at = NOW()
+	`
+	if _, err := tx.Exec(ctx, query, key, value, eventID); err != nil {
+		return 0, err
+	}
+	return value - previous, nil
+}
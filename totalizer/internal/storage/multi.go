@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+)
+
+// Backend is a named secondary store for the total.
+type Backend struct {
+	Name    string
+	Storage Storage
+}
+
+// MultiStorage mirrors the Postgres total to secondary backends, e.g. during
+// a storage migration. Postgres stays the primary: events are applied in its
+// transaction, and only once that commits is the total copied to the
+// secondaries. Secondary writes are best effort; a failure is logged and
+// counted but never fails the apply. Each sync writes the absolute total
+// rather than a delta, so a secondary that missed a write is caught up by the next one.
+type MultiStorage struct {
+	primary     *PostgresStorage
+	secondaries []Backend
+	metrics     *telemetry.Metrics
+
+	// mu orders syncs so a secondary never ends up with an older total than
+	// one already written to it.
+	mu sync.Mutex
+}
+
+func NewMultiStorage(primary *PostgresStorage, metrics *telemetry.Metrics, secondaries ...Backend) *MultiStorage {
+	return &MultiStorage{
+		primary:     primary,
+		secondaries: secondaries,
+		metrics:     metrics,
+	}
+}
+
+// Save sets the total in the primary and then the secondaries.
+func (m *MultiStorage) Save(total int64) error {
+	return m.SaveContext(context.Background(), total)
+}
+
+func (m *MultiStorage) SaveContext(ctx context.Context, total int64) error {
+	if err := m.primary.SaveContext(ctx, total); err != nil {
+		return err
+	}
+	return m.Sync(ctx)
+}
+
+// Load returns the primary's total.
+func (m *MultiStorage) Load() (int64, error) {
+	return m.primary.Load()
+}
+
+func (m *MultiStorage) LoadContext(ctx context.Context) (int64, error) {
+	return m.primary.LoadContext(ctx)
+}
+
+// Sync copies the primary's committed total to every secondary. It only
+// fails if the primary can't be read.
+func (m *MultiStorage) Sync(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	total, err := m.primary.LoadContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range m.secondaries {
+		if err := b.Storage.SaveContext(ctx, total); err != nil {
+			m.metrics.StorageSecondaryFailures.WithLabelValues(b.Name).Inc()
+			log.Printf("failed to write total to secondary storage %s: %v", b.Name, err)
+		}
+	}
+	return nil
+}
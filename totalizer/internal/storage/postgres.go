@@ -2,11 +2,17 @@ package storage
 
 import (
 	"context"
+	"errors"
 
+	"github.com/aelhady03/sumflow/totalizer/internal/data"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrNoTypeTotal is returned when no events of the requested type have been applied.
+var ErrNoTypeTotal = errors.New("no total for event type")
+
 type PostgresStorage struct {
 	pool *pgxpool.Pool
 }
@@ -15,37 +21,134 @@ func NewPostgresStorage(pool *pgxpool.Pool) *PostgresStorage {
 	return &PostgresStorage{pool: pool}
 }
 
-func (p *PostgresStorage) Save(total int) error {
+func (p *PostgresStorage) Save(total int64) error {
 	return p.SaveContext(context.Background(), total)
 }
 
-func (p *PostgresStorage) SaveContext(ctx context.Context, total int) error {
+func (p *PostgresStorage) SaveContext(ctx context.Context, total int64) error {
 	query := `UPDATE totals SET total = $1, updated_at = NOW() WHERE id = 1`
 	_, err := p.pool.Exec(ctx, query, total)
 	return err
 }
 
-func (p *PostgresStorage) Load() (int, error) {
+func (p *PostgresStorage) Load() (int64, error) {
 	return p.LoadContext(context.Background())
 }
 
-func (p *PostgresStorage) LoadContext(ctx context.Context) (int, error) {
-	var total int
+func (p *PostgresStorage) LoadContext(ctx context.Context) (int64, error) {
+	var total int64
 	query := `SELECT total FROM totals WHERE id = 1`
 	err := p.pool.QueryRow(ctx, query).Scan(&total)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The row is recreated by the next applied event
+		return 0, nil
+	}
 	if err != nil {
 		return 0, err
 	}
 	return total, nil
 }
 
-// AddToTotalInTx atomically adds a value to the total within a transaction
-func (p *PostgresStorage) AddToTotalInTx(ctx context.Context, tx pgx.Tx, value int) error {
-	query := `UPDATE totals SET total = total + $1, updated_at = NOW() WHERE id = 1`
-	_, err := tx.Exec(ctx, query, value)
+// AddToTotalInTx atomically adds a value to the total within a transaction,
+// recording it as the most recent change. Like the dedup insert it is
+// prepared once per connection by pgx's statement cache.
+func (p *PostgresStorage) AddToTotalInTx(ctx context.Context, tx pgx.Tx, eventID uuid.UUID, value int64) error {
+	// Upsert so a deleted totals row is recreated from zero instead of failing every apply
+	query := `
+		INSERT INTO totals (id, total, applied_count, last_event_id, last_value)
+		VALUES (1, $1, 1, $2, $1)
+		ON CONFLICT (id) DO UPDATE
+		SET total = totals.total + EXCLUDED.total,
+			applied_count = totals.applied_count + 1,
+			last_event_id = EXCLUDED.last_event_id,
+			last_value = EXCLUDED.last_value,
+			updated_at = NOW()
+	`
+	_, err := tx.Exec(ctx, query, value, eventID)
+	return err
+}
+
+// LockTotalInTx locks the totals row for the rest of the transaction,
+// recreating it at zero if it was deleted. A coalesced batch calls it before
+// applying its events, so their keyed totals and history rows are written
+// under the lock AddToTotalInTx would otherwise take.
+func (p *PostgresStorage) LockTotalInTx(ctx context.Context, tx pgx.Tx) error {
+	query := `
+		INSERT INTO totals (id, total) VALUES (1, 0)
+		ON CONFLICT (id) DO UPDATE SET total = totals.total
+	`
+	_, err := tx.Exec(ctx, query)
+	return err
+}
+
+// AddBatchToTotalInTx adds the sum of count applied values to the total in a
+// single update, recording lastEventID and lastValue as the most recent
+// change. It is AddToTotalInTx for a coalesced batch of events.
+func (p *PostgresStorage) AddBatchToTotalInTx(ctx context.Context, tx pgx.Tx, lastEventID uuid.UUID, lastValue, sum int64, count int) error {
+	query := `
+		INSERT INTO totals (id, total, applied_count, last_event_id, last_value)
+		VALUES (1, $1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE
+		SET total = totals.total + EXCLUDED.total,
+			applied_count = totals.applied_count + EXCLUDED.applied_count,
+			last_event_id = EXCLUDED.last_event_id,
+			last_value = EXCLUDED.last_value,
+			updated_at = NOW()
+	`
+	_, err := tx.Exec(ctx, query, sum, count, lastEventID, lastValue)
+	return err
+}
+
+// LoadTotalInTx returns the total as seen by a transaction, including its own
+// uncommitted changes.
+func (p *PostgresStorage) LoadTotalInTx(ctx context.Context, tx pgx.Tx) (int64, error) {
+	var total int64
+	err := tx.QueryRow(ctx, `SELECT total FROM totals WHERE id = 1`).Scan(&total)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return total, err
+}
+
+// LoadResult returns the total with details of the most recent change
+func (p *PostgresStorage) LoadResult(ctx context.Context) (*data.Result, error) {
+	var result data.Result
+	query := `SELECT total, applied_count, last_event_id, last_value, updated_at FROM totals WHERE id = 1`
+	err := p.pool.QueryRow(ctx, query).Scan(&result.Total, &result.Count, &result.LastEventID, &result.LastValue, &result.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &data.Result{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AddToTypeTotalInTx atomically adds a value to the per-event-type total within a transaction
+func (p *PostgresStorage) AddToTypeTotalInTx(ctx context.Context, tx pgx.Tx, eventType string, value int64) error {
+	query := `
+		INSERT INTO totals_by_type (event_type, total)
+		VALUES ($1, $2)
+		ON CONFLICT (event_type) DO UPDATE SET total = totals_by_type.total + EXCLUDED.total, updated_at = NOW()
+	`
+	_, err := tx.Exec(ctx, query, eventType, value)
 	return err
 }
 
+// LoadTypeTotal returns the running total for a single event type
+func (p *PostgresStorage) LoadTypeTotal(ctx context.Context, eventType string) (int64, error) {
+	var total int64
+	query := `SELECT total FROM totals_by_type WHERE event_type = $1`
+	err := p.pool.QueryRow(ctx, query, eventType).Scan(&total)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNoTypeTotal
+	}
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
 // GetPool returns the underlying connection pool for transaction management
 func (p *PostgresStorage) GetPool() *pgxpool.Pool {
 	return p.pool
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aelhady03/sumflow/pkg/pgtest"
+	"github.com/aelhady03/sumflow/totalizer/internal/database"
+	"github.com/aelhady03/sumflow/totalizer/internal/dedup"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestStorage returns a PostgresStorage on a migrated test schema.
+func newTestStorage(t *testing.T) (*PostgresStorage, *pgxpool.Pool) {
+	t.Helper()
+	pool := pgtest.Pool(t)
+	if err := database.RunMigrations(context.Background(), pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewPostgresStorage(pool), pool
+}
+
+func TestAddToTotalInTxKeepsTotalsBeyondInt32(t *testing.T) {
+	s, pool := newTestStorage(t)
+	ctx := context.Background()
+
+	const value = 5_000_000_000
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	eventID := uuid.New()
+	if err := s.AddToTotalInTx(ctx, tx, eventID, value); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := s.AddToTypeTotalInTx(ctx, tx, "sum.calculated", value); err != nil {
+		t.Fatalf("add type total: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	total, err := s.LoadContext(ctx)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if total != value {
+		t.Errorf("total %d, want %d", total, int64(value))
+	}
+
+	result, err := s.LoadResult(ctx)
+	if err != nil {
+		t.Fatalf("load result: %v", err)
+	}
+	if result.Total != value || result.LastValue == nil || *result.LastValue != value {
+		t.Errorf("result total %d, last value %v, want both %d", result.Total, result.LastValue, int64(value))
+	}
+
+	typeTotal, err := s.LoadTypeTotal(ctx, "sum.calculated")
+	if err != nil {
+		t.Fatalf("load type total: %v", err)
+	}
+	if typeTotal != value {
+		t.Errorf("type total %d, want %d", typeTotal, int64(value))
+	}
+}
+
+func TestAddToTotalInTxRecreatesDeletedRow(t *testing.T) {
+	s, pool := newTestStorage(t)
+	ctx := context.Background()
+
+	if _, err := pool.Exec(ctx, `DELETE FROM totals`); err != nil {
+		t.Fatalf("delete totals row: %v", err)
+	}
+	if total, err := s.LoadContext(ctx); err != nil || total != 0 {
+		t.Fatalf("load without a row = %d, %v, want 0", total, err)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := s.AddToTotalInTx(ctx, tx, uuid.New(), 7); err != nil {
+		t.Fatalf("add after the row was deleted: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	result, err := s.LoadResult(ctx)
+	if err != nil {
+		t.Fatalf("load result: %v", err)
+	}
+	if result.Total != 7 || result.Count != 1 {
+		t.Errorf("total %d from %d events, want 7 from 1", result.Total, result.Count)
+	}
+}
+
+func TestLockTotalInTxHoldsTheTotalsRow(t *testing.T) {
+	s, pool := newTestStorage(t)
+	ctx := context.Background()
+
+	if _, err := pool.Exec(ctx, `DELETE FROM totals`); err != nil {
+		t.Fatalf("delete totals row: %v", err)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := s.LockTotalInTx(ctx, tx); err != nil {
+		t.Fatalf("lock after the row was deleted: %v", err)
+	}
+
+	// An apply or snapshot running meanwhile must wait for the batch
+	other, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer other.Rollback(ctx)
+	if _, err := other.Exec(ctx, `SET LOCAL lock_timeout = '100ms'`); err != nil {
+		t.Fatalf("set lock timeout: %v", err)
+	}
+	if _, err := other.Exec(ctx, `SELECT 1 FROM totals WHERE id = 1 FOR UPDATE`); err == nil {
+		t.Fatal("totals row locked by a second transaction while the first held it")
+	}
+
+	if err := s.AddBatchToTotalInTx(ctx, tx, uuid.New(), 4, 9, 2); err != nil {
+		t.Fatalf("add batch: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	result, err := s.LoadResult(ctx)
+	if err != nil {
+		t.Fatalf("load result: %v", err)
+	}
+	if result.Total != 9 || result.Count != 2 {
+		t.Errorf("total %d from %d events, want 9 from 2", result.Total, result.Count)
+	}
+}
+
+func TestSeedTotalMarksItsHistoryRow(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+
+	seeded, err := s.SeedTotal(ctx, 100)
+	if err != nil || !seeded {
+		t.Fatalf("seed = %v, %v, want true", seeded, err)
+	}
+
+	var sources []string
+	err = s.StreamHistory(ctx, 10, func(e HistoryEntry) error {
+		sources = append(sources, e.Source)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream history: %v", err)
+	}
+	if len(sources) != 1 || sources[0] != HistorySourceSeed {
+		t.Errorf("history sources %q, want one %q", sources, HistorySourceSeed)
+	}
+}
+
+func TestExpireKeysMarksItsHistoryRow(t *testing.T) {
+	s, pool := newTestStorage(t)
+	ctx := context.Background()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := s.AddToTotalInTx(ctx, tx, uuid.New(), 5); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := s.AddToKeyedTotalInTx(ctx, tx, "account-1", 5, time.Millisecond); err != nil {
+		t.Fatalf("add keyed: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	expired, sum, err := s.ExpireKeys(ctx)
+	if err != nil || expired != 1 || sum != 5 {
+		t.Fatalf("expire = %d keys, %d, %v, want 1 key, 5", expired, sum, err)
+	}
+
+	var sources []string
+	err = s.StreamHistory(ctx, 10, func(e HistoryEntry) error {
+		sources = append(sources, e.Source)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream history: %v", err)
+	}
+	if len(sources) != 1 || sources[0] != HistorySourceExpiry {
+		t.Errorf("history sources %q, want one %q", sources, HistorySourceExpiry)
+	}
+}
+
+func TestHistoryRangeSkipsRowsWithoutAnEvent(t *testing.T) {
+	s, pool := newTestStorage(t)
+	ctx := context.Background()
+
+	if seeded, err := s.SeedTotal(ctx, 100); err != nil || !seeded {
+		t.Fatalf("seed = %v, %v, want true", seeded, err)
+	}
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	event := HistoryEntry{EventID: uuid.New(), Value: 7, EventCreatedAt: time.Now()}
+	if err := s.AddToTotalInTx(ctx, tx, event.EventID, event.Value); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := s.RecordHistoryInTx(ctx, tx, event); err != nil {
+		t.Fatalf("record history: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	entries, err := s.HistoryRange(ctx, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), 0, 10)
+	if err != nil {
+		t.Fatalf("history range: %v", err)
+	}
+	if len(entries) != 1 || entries[0].EventID != event.EventID {
+		t.Errorf("history range returned %d rows, want only event %s", len(entries), event.EventID)
+	}
+}
+
+func TestTakeSnapshotWithoutATotalsRow(t *testing.T) {
+	s, pool := newTestStorage(t)
+	ctx := context.Background()
+
+	if _, err := pool.Exec(ctx, `DELETE FROM totals`); err != nil {
+		t.Fatalf("delete totals row: %v", err)
+	}
+
+	snap, err := s.TakeSnapshot(ctx)
+	if err != nil {
+		t.Fatalf("snapshot without a totals row: %v", err)
+	}
+	if snap.Total != 0 {
+		t.Errorf("snapshot total %d, want 0", snap.Total)
+	}
+}
+
+// BenchmarkHotQueries measures the consumer's hottest queries, the dedup
+// insert and the totals upsert, applied together as for each event. The
+// prepared case is pgx's default, which prepares each query once per
+// connection; the others send it unprepared, as the plain and simple
+// protocols do.
+func BenchmarkHotQueries(b *testing.B) {
+	ctx := context.Background()
+	pool := pgtest.Pool(b)
+	if err := database.RunMigrations(ctx, pool); err != nil {
+		b.Fatalf("migrate: %v", err)
+	}
+
+	modes := []struct {
+		name string
+		mode pgx.QueryExecMode
+	}{
+		{"prepared", pgx.QueryExecModeCacheStatement},
+		{"unprepared", pgx.QueryExecModeExec},
+		{"simple_protocol", pgx.QueryExecModeSimpleProtocol},
+	}
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			cfg := pool.Config()
+			cfg.ConnConfig.DefaultQueryExecMode = m.mode
+			modePool, err := pgxpool.NewWithConfig(ctx, cfg)
+			if err != nil {
+				b.Fatalf("open pool: %v", err)
+			}
+			defer modePool.Close()
+			s := NewPostgresStorage(modePool)
+			repo := dedup.NewRepository(modePool)
+
+			for b.Loop() {
+				tx, err := modePool.Begin(ctx)
+				if err != nil {
+					b.Fatalf("begin: %v", err)
+				}
+				eventID := uuid.New()
+				if err := repo.CheckAndMarkInTx(ctx, tx, eventID, "sum", "sum.calculated"); err != nil {
+					b.Fatalf("check and mark: %v", err)
+				}
+				if err := s.AddToTotalInTx(ctx, tx, eventID, 1); err != nil {
+					b.Fatalf("add: %v", err)
+				}
+				if err := tx.Commit(ctx); err != nil {
+					b.Fatalf("commit: %v", err)
+				}
+			}
+		})
+	}
+}
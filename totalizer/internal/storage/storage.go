@@ -1,49 +1,99 @@
 package storage
 
 import (
+	"context"
 	"os"
 	"strconv"
-	"sync"
+	"time"
 )
 
 type Storage interface {
-	Save(total int) error
-	Load() (int, error)
+	Save(total int64) error
+	Load() (int64, error)
+	SaveContext(ctx context.Context, total int64) error
+	LoadContext(ctx context.Context) (int64, error)
 }
 
 type FileStorage struct {
 	Filename string
-	mu       sync.Mutex
+	// Timeout, if set, bounds each operation, including waiting for the
+	// previous one, so a hung filesystem can't block callers indefinitely.
+	Timeout time.Duration
+
+	// lock holds a token while an operation's I/O runs. It is a channel
+	// rather than a mutex so that waiting for it can be abandoned.
+	lock chan struct{}
 }
 
-func NewFileStorage(filename string) *FileStorage {
+// NewFileStorage returns a FileStorage backed by filename, seeding the file
+// with initial if it doesn't exist yet.
+func NewFileStorage(filename string, initial int64) *FileStorage {
 	if _, err := os.Stat(filename); err != nil {
-		_ = os.WriteFile(filename, []byte("10"), 0644)
+		_ = os.WriteFile(filename, []byte(strconv.FormatInt(initial, 10)), 0644)
 	}
 	return &FileStorage{
 		Filename: filename,
+		lock:     make(chan struct{}, 1),
 	}
 }
 
-func (f *FileStorage) Save(total int) error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	return os.WriteFile(f.Filename, []byte(strconv.Itoa(total)), 0644)
+func (f *FileStorage) Save(total int64) error {
+	return f.SaveContext(context.Background(), total)
 }
 
-func (f *FileStorage) Load() (int, error) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
+func (f *FileStorage) SaveContext(ctx context.Context, total int64) error {
+	_, err := f.do(ctx, func() (int64, error) {
+		return 0, os.WriteFile(f.Filename, []byte(strconv.FormatInt(total, 10)), 0644)
+	})
+	return err
+}
 
-	data, err := os.ReadFile(f.Filename)
-	if err != nil {
-		return 0, err
+func (f *FileStorage) Load() (int64, error) {
+	return f.LoadContext(context.Background())
+}
+
+func (f *FileStorage) LoadContext(ctx context.Context) (int64, error) {
+	return f.do(ctx, func() (int64, error) {
+		data, err := os.ReadFile(f.Filename)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(string(data), 10, 64)
+	})
+}
+
+// do runs op under the lock, giving up with ctx's error if the lock or op
+// takes longer than ctx or Timeout allows. An abandoned op keeps running in
+// the background and holds the lock until it finishes, so operations never
+// overlap on the file.
+func (f *FileStorage) do(ctx context.Context, op func() (int64, error)) (int64, error) {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	select {
+	case f.lock <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
 	}
 
-	value, err := strconv.Atoi(string(data))
-	if err != nil {
-		return 0, err
+	type result struct {
+		value int64
+		err   error
 	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { <-f.lock }()
+		value, err := op()
+		done <- result{value, err}
+	}()
 
-	return value, nil
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
 }
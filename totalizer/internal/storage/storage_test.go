@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorageRoundTripsTotalsBeyondInt32(t *testing.T) {
+	f := NewFileStorage(filepath.Join(t.TempDir(), "total"), 0)
+
+	const total = 5_000_000_000
+	if err := f.Save(total); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	got, err := f.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got != total {
+		t.Errorf("loaded %d, want %d", got, int64(total))
+	}
+}
+
+func TestFileStorageTimesOutSlowWrite(t *testing.T) {
+	f := NewFileStorage(filepath.Join(t.TempDir(), "total"), 0)
+	f.Timeout = 20 * time.Millisecond
+
+	// Stand in for a write stuck on a hung filesystem
+	release := make(chan struct{})
+	slowWrite := func() (int64, error) {
+		<-release
+		return 0, nil
+	}
+
+	start := time.Now()
+	if _, err := f.do(context.Background(), slowWrite); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow write = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("slow write returned after %s, want about %s", waited, f.Timeout)
+	}
+
+	// The abandoned write still holds the lock, so callers time out instead of queueing behind it
+	if err := f.Save(5); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("save behind the slow write = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	f.Timeout = time.Second
+	if err := f.Save(5); err != nil {
+		t.Fatalf("save after the slow write finished: %v", err)
+	}
+	if got, err := f.Load(); err != nil || got != 5 {
+		t.Errorf("load = %d, %v, want 5", got, err)
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Drift is a stretch of history whose applied values don't add up to the
+// total recorded at its end. From and To bound when the history was applied;
+// a nil From is the start of history and a nil To is the present.
+type Drift struct {
+	AfterHistoryID int64
+	ToHistoryID    int64
+	From           *time.Time
+	To             *time.Time
+	Expected       int64
+	Actual         int64
+}
+
+// Verification is the result of checking the stored total against history.
+type Verification struct {
+	Stored   int64
+	Expected int64
+	// Segments is how many snapshot-to-snapshot stretches were checked;
+	// Skipped is how many could not be because their history was truncated.
+	Segments int
+	Skipped  int
+	Drifts   []Drift
+}
+
+// VerifyTotal checks the stored total against the latest snapshot plus the
+// history recorded after it, and each snapshot against the one before it plus
+// the history between them, so drift baked into a later snapshot is still
+// located. Stretches whose history has been truncated are skipped. Everything
+// is read in one repeatable-read transaction, so concurrent applies can't
+// cause false drift.
+func (p *PostgresStorage) VerifyTotal(ctx context.Context) (*Verification, error) {
+	tx, err := p.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var v Verification
+	err = tx.QueryRow(ctx, `SELECT total FROM totals WHERE id = 1`).Scan(&v.Stored)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	var minHistoryID *int64
+	if err := tx.QueryRow(ctx, `SELECT MIN(id) FROM sum_history`).Scan(&minHistoryID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id, total, history_id, max_applied_at, taken_at FROM total_snapshots ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Snapshot, error) {
+		var s Snapshot
+		err := row.Scan(&s.ID, &s.Total, &s.HistoryID, &s.MaxAppliedAt, &s.TakenAt)
+		return s, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sumBetween := func(after, upTo int64) (int64, error) {
+		var sum int64
+		query := `SELECT COALESCE(SUM(value), 0) FROM sum_history WHERE id > $1 AND id <= $2`
+		err := tx.QueryRow(ctx, query, after, upTo).Scan(&sum)
+		return sum, err
+	}
+
+	// Walk the snapshot chain from an empty total
+	var prev Snapshot
+	for _, s := range snapshots {
+		// Truncation deletes from the oldest rows up, so a stretch is intact
+		// if history still reaches back to its start
+		intact := s.HistoryID == prev.HistoryID || (minHistoryID != nil && *minHistoryID <= prev.HistoryID+1)
+		if !intact {
+			v.Skipped++
+			prev = s
+			continue
+		}
+
+		sum, err := sumBetween(prev.HistoryID, s.HistoryID)
+		if err != nil {
+			return nil, err
+		}
+		v.Segments++
+		if expected := prev.Total + sum; expected != s.Total {
+			v.Drifts = append(v.Drifts, Drift{
+				AfterHistoryID: prev.HistoryID,
+				ToHistoryID:    s.HistoryID,
+				From:           prev.MaxAppliedAt,
+				To:             s.MaxAppliedAt,
+				Expected:       expected,
+				Actual:         s.Total,
+			})
+		}
+		prev = s
+	}
+
+	// History after the latest snapshot is never truncated
+	var sum int64
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(SUM(value), 0) FROM sum_history WHERE id > $1`, prev.HistoryID).Scan(&sum); err != nil {
+		return nil, err
+	}
+	v.Segments++
+	v.Expected = prev.Total + sum
+	if v.Expected != v.Stored {
+		v.Drifts = append(v.Drifts, Drift{
+			AfterHistoryID: prev.HistoryID,
+			From:           prev.MaxAppliedAt,
+			Expected:       v.Expected,
+			Actual:         v.Stored,
+		})
+	}
+
+	return &v, nil
+}
@@ -0,0 +1,127 @@
+package summary
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aelhady03/sumflow/pkg/telemetry"
+)
+
+type Config struct {
+	// SampleInterval is how often the consumed-message counters are sampled.
+	SampleInterval time.Duration
+	// Window is the period the summary ratios are computed over.
+	Window time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		SampleInterval: 10 * time.Second,
+		Window:         5 * time.Minute,
+	}
+}
+
+// Summary describes consumption over a window.
+type Summary struct {
+	Window         time.Duration      `json:"-"`
+	Consumed       float64            `json:"consumed"`
+	Duplicates     float64            `json:"duplicates"`
+	DuplicateRatio float64            `json:"duplicate_ratio"`
+	ByStatus       map[string]float64 `json:"by_status"`
+}
+
+type sample struct {
+	at     time.Time
+	counts map[string]float64
+}
+
+// Tracker samples the consumer's message counters so ratios such as the share
+// of duplicates can be reported over a recent window rather than since startup.
+type Tracker struct {
+	metrics *telemetry.Metrics
+	topic   string
+	config  Config
+	stopCh  chan struct{}
+
+	mu      sync.Mutex
+	samples []sample
+}
+
+func NewTracker(metrics *telemetry.Metrics, topic string, config Config) *Tracker {
+	return &Tracker{
+		metrics: metrics,
+		topic:   topic,
+		config:  config,
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start begins sampling in the background
+func (t *Tracker) Start(ctx context.Context) {
+	t.record()
+	go t.run(ctx)
+}
+
+// Stop signals the tracker to stop sampling
+func (t *Tracker) Stop() {
+	close(t.stopCh)
+}
+
+func (t *Tracker) run(ctx context.Context) {
+	ticker := time.NewTicker(t.config.SampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.stopCh:
+			return
+		case <-ticker.C:
+			t.record()
+		}
+	}
+}
+
+func (t *Tracker) record() {
+	now := time.Now()
+	s := sample{at: now, counts: t.metrics.ConsumedByStatus(t.topic)}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples = append(t.samples, s)
+	// Keep one sample at or before the window start as the baseline
+	cutoff := now.Add(-t.config.Window)
+	for len(t.samples) > 2 && !t.samples[1].at.After(cutoff) {
+		t.samples = t.samples[1:]
+	}
+}
+
+// Summary returns consumption counts between the oldest sample in the window and now.
+func (t *Tracker) Summary() Summary {
+	current := t.metrics.ConsumedByStatus(t.topic)
+
+	t.mu.Lock()
+	var baseline sample
+	if len(t.samples) > 0 {
+		baseline = t.samples[0]
+	}
+	t.mu.Unlock()
+
+	sum := Summary{
+		Window:   t.config.Window,
+		ByStatus: make(map[string]float64),
+	}
+	for status, count := range current {
+		delta := count - baseline.counts[status]
+		sum.ByStatus[status] = delta
+		sum.Consumed += delta
+	}
+	sum.Duplicates = sum.ByStatus["duplicate"]
+	if sum.Consumed > 0 {
+		sum.DuplicateRatio = sum.Duplicates / sum.Consumed
+	}
+	return sum
+}